@@ -0,0 +1,11 @@
+package naming
+
+// TmpDir is the folder for incomplete files (inside the storage root).
+const TmpDir = ".tmp"
+
+// TrashDir is the folder for trashed files (inside the storage root).
+const TrashDir = ".trash"
+
+// shardNameLen is the minimal name length for sharded files.
+// Storage names of parts have 128 chars (bundles have an additional prefix).
+const shardNameLen = 128
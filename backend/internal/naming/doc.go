@@ -0,0 +1,9 @@
+/*
+Package naming provides the name format shared by the storage backends (local, sftp and s3).
+
+Each storage file is saved as '<name>.<md5>.<random>'. Files with a long name (parts and bundles)
+are sharded into sub folders: 'ab/cd/<name>.<md5>.<random>'. The file id is the path relative
+to the storage root (separated with '/').
+
+*/
+package naming
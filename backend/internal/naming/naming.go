@@ -0,0 +1,72 @@
+package naming
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"path"
+	"strings"
+)
+
+// LimitedFile combines a limited reader with the closer of the file.
+type LimitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// CheckId returns the id of a storage file (path relative to the storage root, separated with '/').
+// Absolute paths and paths outside the storage root are rejected.
+func CheckId(file interf.File) (string, error) {
+	// check input
+	if file == nil {
+		return "", errors.New("file is nil")
+	}
+	id := file.Id()
+	if id == "" || path.IsAbs(id) || path.Clean(id) != id || strings.HasPrefix(id, "..") {
+		return "", fmt.Errorf("invalid file id: '%s'", id)
+	}
+
+	return id, nil
+}
+
+// FileId returns the relative path for a new file: '[ab/cd/]<name>.<md5>.<random>'
+func FileId(name, md5 string) string {
+	// random suffix (the same name and content can be stored multiple times)
+	id := fmt.Sprintf("%s.%s.%s", name, md5, RandomHex())
+
+	// sharding: use the last 128 chars (part names are hex strings)
+	if len(name) >= shardNameLen {
+		key := name[len(name)-shardNameLen:]
+		id = path.Join(key[0:2], key[2:4], id)
+	}
+	return id
+}
+
+// RandomHex returns 16 random hex chars.
+func RandomHex() string {
+	rnd := make([]byte, 8)
+	_, _ = rand.Read(rnd)
+	return fmt.Sprintf("%x", rnd)
+}
+
+// ParseFileName splits the file name '<name>.<md5>.<random>' into name and md5.
+func ParseFileName(fileName string) (name, md5 string, ok bool) {
+	// split from right (the name can contain dots)
+	i := strings.LastIndex(fileName, ".")
+	if i < 0 {
+		return
+	}
+	j := strings.LastIndex(fileName[:i], ".")
+	if j < 1 {
+		return
+	}
+
+	// check md5 (hex string)
+	name, md5 = fileName[:j], fileName[j+1:i]
+	if len(md5) != 32 || strings.Trim(md5, "0123456789abcdef") != "" {
+		return "", "", false
+	}
+	return name, md5, true
+}
@@ -0,0 +1,73 @@
+package naming_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/backend/internal/naming"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFileId(t *testing.T) {
+	md5 := "d41d8cd98f00b204e9800998ecf8427e"
+
+	// short name: no sharding
+	id := naming.FileId("index.db2", md5)
+	if strings.Contains(id, "/") || !strings.HasPrefix(id, "index.db2."+md5+".") {
+		t.Errorf("wrong id: %s", id)
+	}
+
+	// long name: sharding with the last 128 chars
+	long := "abcd" + strings.Repeat("0", 124)
+	id = naming.FileId("b-"+long, md5)
+	if path.Dir(id) != "ab/cd" {
+		t.Errorf("wrong shard: %s", id)
+	}
+
+	// random suffix
+	if naming.FileId("x", md5) == naming.FileId("x", md5) {
+		t.Error("ids are not unique")
+	}
+
+	// round trip
+	name, m, ok := naming.ParseFileName(path.Base(id))
+	if !ok || name != "b-"+long || m != md5 {
+		t.Errorf("wrong parse: %s %s %v", name, m, ok)
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	md5 := "d41d8cd98f00b204e9800998ecf8427e"
+
+	for _, s := range []string{
+		"",
+		"name",
+		"name." + md5,
+		"." + md5 + ".1234",
+		"name.D41D8CD98F00B204E9800998ECF8427E.1234", // upper case
+		"name.d41d8cd98f00b204e9800998ecf8427.1234",  // too short
+	} {
+		if _, _, ok := naming.ParseFileName(s); ok {
+			t.Errorf("invalid name accepted: '%s'", s)
+		}
+	}
+
+	name, m, ok := naming.ParseFileName("a.b.c." + md5 + ".1234")
+	if !ok || name != "a.b.c" || m != md5 {
+		t.Errorf("wrong parse: %s %s %v", name, m, ok)
+	}
+}
+
+func TestCheckId(t *testing.T) {
+	for _, id := range []string{"", "/abs", "../up", "a/../b", "a//b"} {
+		if _, err := naming.CheckId(impl.NewFile(id, "n", 0, 0, "")); err == nil {
+			t.Errorf("invalid id accepted: '%s'", id)
+		}
+	}
+	if _, err := naming.CheckId(nil); err == nil {
+		t.Error("nil file accepted")
+	}
+	if id, err := naming.CheckId(impl.NewFile("ab/cd/x", "n", 0, 0, "")); err != nil || id != "ab/cd/x" {
+		t.Errorf("valid id rejected: %v", err)
+	}
+}
@@ -0,0 +1,4 @@
+package local
+
+// packageName is used for debug and error messages
+const packageName = "local"
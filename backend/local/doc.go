@@ -0,0 +1,5 @@
+/*
+Package local provides the storage service implementation for a local directory (e.g. NAS mount or USB disk).
+
+*/
+package local
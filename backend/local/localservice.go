@@ -0,0 +1,276 @@
+package local
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/internal/naming"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// interface check: interf.Service
+var _ interf.Service = (*_LocalService)(nil)
+
+// _LocalService stores all files in a local directory.
+// Must be created with NewLocalService().
+//
+// Each storage file is saved as '<name>.<md5>.<random>'. The md5 hash is part of the
+// file name, so Update() does not have to read the file content. Files with a long
+// name (parts and bundles) are sharded into sub folders: 'ab/cd/<name>.<md5>.<random>'.
+// The file id is the path relative to the storage root (separated with '/').
+type _LocalService struct {
+	root        string
+	readerCache interf.Cache
+	debugLvl    uint8
+	mux         *sync.RWMutex
+	files       interf.Files
+}
+
+// NewLocalService returns an interface to a local directory. The root folder is created if it does not exist.
+// readerCache=nil disable the cache for ReaderAt() and MultiReaderAt()
+// debugLvl (@see impl.DebugHigh and impl.DebugOff)
+func NewLocalService(root string, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	// create root
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	// return service
+	return &_LocalService{
+		root:        root,
+		readerCache: readerCache,
+		debugLvl:    debugLvl,
+		mux:         new(sync.RWMutex),
+		files:       impl.NewFiles(nil), // empty list, set by Update()
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+// Update is the implementation of Service.Update()
+//
+// Update walks the storage root and rebuilds the internal file index.
+// The file content is not read (@see _LocalService).
+// This method is thread-safe.
+func (s *_LocalService) Update() error {
+	byId := make(map[string]interf.File)
+
+	err := filepath.Walk(s.root, func(absPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// relative path (= file id)
+		relPath, err := filepath.Rel(s.root, absPath)
+		if err != nil {
+			return err
+		}
+		id := filepath.ToSlash(relPath)
+
+		// skip temp and trash folder
+		if info.IsDir() {
+			if id == naming.TmpDir || id == naming.TrashDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// parse file name
+		name, md5, ok := naming.ParseFileName(info.Name())
+		if !ok {
+			if s.debugLvl >= impl.DebugHigh {
+				log.Printf("DEBUG: %s/Update: ignore unknown file '%s'", packageName, id)
+			}
+			return nil
+		}
+
+		// add file
+		byId[id] = impl.NewFile(id, name, info.ModTime().Unix(), info.Size(), md5)
+		return nil
+	})
+	if err != nil {
+		log.Printf("ERROR: %s/Update: %v", packageName, err)
+		return err
+	}
+
+	// set new list
+	s.mux.Lock() // WRITE Lock
+	s.files = impl.NewFiles(byId)
+	s.mux.Unlock()
+
+	log.Printf("INFO: %s/Update: successful file update (%d files)", packageName, len(byId))
+	return nil
+}
+
+// Files is the implementation of Service.Files()
+func (s *_LocalService) Files() interf.Files {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	return s.files
+}
+
+// Save is the implementation of Service.Save()
+//
+// The data is first written to the temp folder and moved to the final path after
+// the md5 hash is known. Incomplete files are never visible in the file index.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_LocalService) Save(name string, r io.Reader, max int64) (file interf.File, err error) {
+	// check input
+	name = strings.TrimSpace(name)
+	if name == "" || r == nil || strings.ContainsAny(name, "/\\") || name[0] == '.' {
+		return nil, errors.New("invalid input")
+	}
+
+	// limit reader
+	if max > 0 {
+		r = io.LimitReader(r, max)
+	}
+
+	// create temp file
+	tmpPath := filepath.Join(s.root, naming.TmpDir)
+	if err := os.MkdirAll(tmpPath, 0700); err != nil {
+		return nil, err
+	}
+	fh, err := ioutil.TempFile(tmpPath, "save-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fh.Name()) // remove temp file (if not moved)
+
+	// write and hash
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(fh, hash), r)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if e := fh.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save error: %v", err)
+	}
+
+	// move to final path
+	id := naming.FileId(name, fmt.Sprintf("%x", hash.Sum(nil)))
+	absPath := filepath.Join(s.root, filepath.FromSlash(id))
+	if err := os.MkdirAll(filepath.Dir(absPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(fh.Name(), absPath); err != nil {
+		return nil, err
+	}
+
+	// return file
+	st, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	_, md5, _ := naming.ParseFileName(path.Base(id))
+	return impl.NewFile(id, name, st.ModTime().Unix(), size, md5), nil
+}
+
+// Trash is the implementation of Service.Trash()
+//
+// Trash moves a file to the trash folder.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_LocalService) Trash(file interf.File) error {
+	absPath, err := s.absPath(file)
+	if err != nil {
+		return err
+	}
+
+	// move to trash
+	trashPath := filepath.Join(s.root, naming.TrashDir)
+	if err := os.MkdirAll(trashPath, 0700); err != nil {
+		return err
+	}
+	return os.Rename(absPath, filepath.Join(trashPath, filepath.Base(absPath)))
+}
+
+// Reader is the implementation of Service.Reader()
+// Delegate to LimitedReader with n=interf.MaxFileSize
+func (s *_LocalService) Reader(file interf.File, off int64) (io.ReadCloser, error) {
+	return s.LimitedReader(file, off, interf.MaxFileSize)
+}
+
+// LimitedReader is the implementation of Service.LimitedReader()
+//
+// LimitedReader opens the local file and seeks to the offset.
+// The connection must be closed manually with Close() after use.
+// This method is thread-safe.
+func (s *_LocalService) LimitedReader(file interf.File, off int64, n int64) (io.ReadCloser, error) {
+	absPath, err := s.absPath(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// open file
+	fh, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// check offset
+	st, err := fh.Stat()
+	if err != nil {
+		_ = fh.Close()
+		return nil, err
+	}
+	if off >= st.Size() {
+		_ = fh.Close()
+		return nil, io.EOF
+	}
+
+	// seek
+	if _, err := fh.Seek(off, io.SeekStart); err != nil {
+		_ = fh.Close()
+		return nil, err
+	}
+
+	// return
+	return &naming.LimitedFile{Reader: io.LimitReader(fh, n), Closer: fh}, nil
+}
+
+// ReaderAt is the implementation of Service.ReaderAt()
+func (s *_LocalService) ReaderAt(file interf.File) (interf.ReaderAt, error) {
+	return impl.NewReaderAt(file, s, s.readerCache, s.debugLvl)
+}
+
+// MultiReaderAt is the implementation of Service.MultiReaderAt()
+func (s *_LocalService) MultiReaderAt(list []interf.File) (interf.ReaderAt, error) {
+	if len(list) == 1 {
+		// use the normal ReaderAt for single files
+		return s.ReaderAt(list[0])
+	} else {
+		// MultiReaderAt
+		return impl.NewMultiReaderAt(list, s, s.readerCache, s.debugLvl)
+	}
+}
+
+// Cache returns the internal cache instance. Can be NIL.
+func (s *_LocalService) Cache() interf.Cache {
+	return s.readerCache
+}
+
+//---------  Helper  -------------------------------------------------------------------------------------------------//
+
+// absPath returns the local path of a storage file.
+func (s *_LocalService) absPath(file interf.File) (string, error) {
+	id, err := naming.CheckId(file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(id)), nil
+}
@@ -0,0 +1,179 @@
+package local_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/backend/local"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalService(t *testing.T) {
+	root, err := ioutil.TempDir("", "localServiceTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s, err := local.NewLocalService(root, nil, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: invalid names
+	for _, name := range []string{"", " ", "a/b", ".hidden"} {
+		if _, err := s.Save(name, strings.NewReader("x"), 0); err == nil {
+			t.Errorf("no error: '%s'", name)
+		}
+	}
+
+	// TEST: save (with max)
+	partName := strings.Repeat("ab12", 32) // 128 chars
+	f1, err := s.Save(partName, strings.NewReader("0123456789"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f1.Name() != partName || f1.Size() != 5 || f1.Md5() != "4100c4d44da9177247e44a5fc1546778" {
+		t.Fatalf("%s %d %s", f1.Name(), f1.Size(), f1.Md5())
+	}
+	if !strings.HasPrefix(f1.Id(), "ab/12/"+partName+".") {
+		t.Errorf("no sharding: %s", f1.Id())
+	}
+	f2, err := s.Save(core.IndexName, strings.NewReader("db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f3, err := s.Save(core.IndexName, strings.NewReader("db"), 0) // same name and content
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f2.Id() == f3.Id() || strings.Contains(f2.Id(), "/") {
+		t.Errorf("wrong id: %s %s", f2.Id(), f3.Id())
+	}
+
+	// TEST: files are visible after update (foreign files are ignored)
+	_ = ioutil.WriteFile(filepath.Join(root, "foreign.txt"), []byte("x"), 0600)
+	if len(s.Files().All()) != 0 {
+		t.Fatal("update without call")
+	}
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files().All()) != 3 {
+		t.Fatalf("wrong len: %d", len(s.Files().All()))
+	}
+	if f, err := s.Files().ByAttr(partName, 5, f1.Md5()); err != nil || f.Id() != f1.Id() {
+		t.Fatalf("ByAttr: %v", err)
+	}
+	if _, err := s.Files().ByAttr(partName, 5, "00000000000000000000000000000000"); err == nil {
+		t.Fatal("ByAttr: no error")
+	}
+	if f, err := s.Files().ById(f2.Id()); err != nil || f.Md5() != f2.Md5() || f.Size() != 2 {
+		t.Fatalf("ById: %v", err)
+	}
+
+	// TEST: reader
+	r, err := s.LimitedReader(f1, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "123" {
+		t.Errorf("wrong data: %s", b)
+	}
+	if _, err := s.Reader(f1, 5); err != io.EOF {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// TEST: readerAt
+	rAt, err := s.ReaderAt(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if n, err := rAt.ReadAt(buf, 3); n != 2 || string(buf) != "34" {
+		t.Errorf("n=%d, err=%v, data=%s", n, err, buf)
+	}
+	_ = rAt.Close()
+
+	// TEST: trash
+	if err := s.Trash(f2); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Update()
+	if len(s.Files().All()) != 2 {
+		t.Fatal("trash fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, ".trash", f2.Id())); err != nil {
+		t.Error(err)
+	}
+	if err := s.Trash(f2); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestLocalService_upload(t *testing.T) {
+	root, err := ioutil.TempDir("", "localServiceTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// test data
+	dataDir := filepath.Join(root, "data")
+	_ = os.MkdirAll(filepath.Join(dataDir, "sub"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "a.txt"), bytes.Repeat([]byte("text "), 1000), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "sub", "b.dat"), []byte{1, 2, 3}, 0600)
+	keyPath := filepath.Join(root, "key.dat")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+
+	// scan & upload
+	vDb, _, _, err := db.FromScan(dataDir, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, _ := local.NewLocalService(filepath.Join(root, "storage"), nil, impl.DebugOff)
+	if err := core.Upload(dataDir, vDb, keyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload with a new service
+	service, _ = local.NewLocalService(filepath.Join(root, "storage"), nil, impl.DebugOff)
+	_ = service.Update()
+	vDb2, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read files
+	for _, name := range []string{"a.txt", "sub/b.dat"} {
+		vFile := vDb2.VFiles[name]
+		rAt, err := core.Open(vFile, vDb2, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(filepath.Join(dataDir, name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// verify
+	report, err := core.Verify(vDb2, service, true, impl.DebugOff)
+	if err != nil || !report.Ok() {
+		t.Errorf("err=%v, report=%#v", err, report)
+	}
+}
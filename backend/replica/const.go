@@ -0,0 +1,9 @@
+package replica
+
+import "errors"
+
+// packageName is used for debug and error messages
+const packageName = "replica"
+
+// errWrongMd5 is returned at the end of a whole file read, if the copy has the wrong md5 hash.
+var errWrongMd5 = errors.New("wrong md5 hash")
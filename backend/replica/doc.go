@@ -0,0 +1,5 @@
+/*
+Package replica provides a storage service that replicates all files to multiple storage services (read failover).
+
+*/
+package replica
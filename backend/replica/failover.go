@@ -0,0 +1,115 @@
+package replica
+
+import (
+	"fmt"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"hash"
+	"io"
+	"log"
+)
+
+// _FailoverReader reads a file from the first readable copy.
+// If a read fails, the reader continues with the next copy at the current offset.
+//
+// If the whole file is read (hash), the md5 hash is checked at the end while streaming. A wrong md5 hash
+// is returned as error with the last data (errWrongMd5) and the copy is no longer used (the next read uses another copy).
+type _FailoverReader struct {
+	s      *_ReplicaService
+	file   interf.File
+	copies []copyFile
+	idx    int           // current copy
+	cur    io.ReadCloser // current reader (nil: not open)
+	off    int64         // current offset
+	end    int64         // end of the data (exclusive)
+	hash   hash.Hash     // md5 hash (nil: partial read)
+	err    error         // last error
+}
+
+// open opens the current or the next readable copy at the current offset.
+func (r *_FailoverReader) open() error {
+	for ; r.idx < len(r.copies); r.idx++ {
+		c := r.copies[r.idx]
+		rc, err := r.s.replicas[c.replica].Service.LimitedReader(c.file, r.off, r.end-r.off)
+		if err != nil {
+			r.fail(c, err, false)
+			continue
+		}
+		r.cur = rc
+		return nil
+	}
+
+	// no copy left
+	if r.err == nil {
+		r.err = fmt.Errorf("file not found: '%s'", r.file.Id())
+	}
+	return r.err
+}
+
+// Read implements io.Reader
+func (r *_FailoverReader) Read(p []byte) (int, error) {
+	for {
+		// end of data
+		if r.off >= r.end {
+			return 0, io.EOF
+		}
+
+		// open reader
+		if r.cur == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		// limit buffer
+		if int64(len(p)) > r.end-r.off {
+			p = p[:r.end-r.off]
+		}
+
+		// read
+		n, err := r.cur.Read(p)
+		r.off += int64(n)
+		if r.hash != nil {
+			r.hash.Write(p[:n])
+		}
+
+		// end of data: check md5 hash
+		if r.off >= r.end {
+			if r.hash != nil && fmt.Sprintf("%x", r.hash.Sum(nil)) != r.file.Md5() {
+				r.fail(r.copies[r.idx], errWrongMd5, true)
+				return n, errWrongMd5
+			}
+			return n, io.EOF
+		}
+
+		// read error: next copy
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.fail(r.copies[r.idx], err, false)
+			_ = r.cur.Close()
+			r.cur = nil
+			r.idx++
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close implements io.Closer
+func (r *_FailoverReader) Close() error {
+	if r.cur != nil {
+		err := r.cur.Close()
+		r.cur = nil
+		return err
+	}
+	return nil
+}
+
+// fail logs a read error and counts it in the health state.
+func (r *_FailoverReader) fail(c copyFile, err error, bad bool) {
+	r.err = err
+	log.Printf("WARNING: %s/Read: replica '%s': '%s': %v", packageName, r.s.replicas[c.replica].Name, c.file.Id(), err)
+	r.s.setReadError(c, err, bad)
+}
@@ -0,0 +1,568 @@
+package replica
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// interface check: interf.Service
+var _ interf.Service = (*_ReplicaService)(nil)
+
+// Replica is a named storage service (e.g. 'gdrive' or 'local:/mnt/nas').
+type Replica struct {
+	Name    string
+	Service interf.Service
+}
+
+// Health is the state of a single replica.
+type Health struct {
+	Name      string `json:"name"`
+	Healthy   bool   `json:"healthy"`     // false: the last Update, Save or Trash failed
+	Files     int    `json:"files"`       // number of files (after the last Update)
+	Missing   int    `json:"missing"`     // files that exist on another replica, but not on this one
+	ReadErrs  int    `json:"read_errors"` // failed reads and copies with a wrong md5 hash (since the last Update)
+	LastError string `json:"last_error"`  // the last error (empty: no error)
+}
+
+// Service is a storage service that replicates all files to multiple storage services.
+//
+// Save and Trash are sent to all healthy replicas.
+// Files returns all files that exist on at least one replica (same name, size and md5).
+// Reads use the first replica with a copy of the file and fall back to the next replica,
+// if the copy is missing, unreadable or has the wrong md5 hash.
+// The md5 hash is only checked at the end of whole file reads (@see LimitedReader), not for ranges.
+type Service interface {
+	interf.Service
+
+	// Replicas returns the storage services of all replicas (e.g. for core.Clean).
+	Replicas() []interf.Service
+
+	// Health returns the state of all replicas.
+	Health() []Health
+
+	// Repair copies all files that are missing on a healthy replica from another replica.
+	// Returns the number of copied files.
+	Repair() (int, error)
+}
+
+// _ReplicaService is the implementation of Service.
+// Must be created with NewReplicaService().
+//
+// A file of this service stands for all copies with the same name, size and md5 hash.
+// The file id is '<name>|<size>|<md5>'.
+type _ReplicaService struct {
+	replicas    []Replica
+	readerCache interf.Cache
+	debugLvl    uint8
+	mux         *sync.RWMutex
+	files       interf.Files
+	copies      map[string][]copyFile // file id -> copies (read order)
+	healthy     []bool
+	lastErr     []string
+	readErrs    []int
+	bad         map[string]bool // copies with a wrong md5 hash (replica|id)
+}
+
+// copyFile is a single copy of a file on a replica.
+type copyFile struct {
+	replica int
+	file    interf.File
+}
+
+// NewReplicaService returns a service that replicates all files to the replicas.
+// The order of the replicas defines the read priority.
+// readerCache=nil disable the cache for ReaderAt() and MultiReaderAt()
+// debugLvl (@see impl.DebugHigh and impl.DebugOff)
+func NewReplicaService(replicas []Replica, readerCache interf.Cache, debugLvl uint8) (Service, error) {
+	// check input
+	if len(replicas) == 0 {
+		return nil, errors.New("no replicas")
+	}
+	for i, r := range replicas {
+		if r.Service == nil {
+			return nil, fmt.Errorf("replica %d: service is nil", i)
+		}
+	}
+
+	// all replicas are healthy at the beginning
+	healthy := make([]bool, len(replicas))
+	for i := range healthy {
+		healthy[i] = true
+	}
+
+	// return service
+	return &_ReplicaService{
+		replicas:    replicas,
+		readerCache: readerCache,
+		debugLvl:    debugLvl,
+		mux:         new(sync.RWMutex),
+		files:       impl.NewFiles(nil), // empty list, set by Update()
+		copies:      make(map[string][]copyFile),
+		healthy:     healthy,
+		lastErr:     make([]string, len(replicas)),
+		readErrs:    make([]int, len(replicas)),
+		bad:         make(map[string]bool),
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+// Update is the implementation of Service.Update()
+//
+// Update updates all replicas and merges the file lists.
+// A replica with an update error is marked as unhealthy and ignored until the next update.
+// Returns an error only if all replicas fail.
+// This method is thread-safe.
+func (s *_ReplicaService) Update() error {
+	healthy := make([]bool, len(s.replicas))
+	lastErr := make([]string, len(s.replicas))
+	copies := make(map[string][]copyFile)
+	byId := make(map[string]interf.File)
+
+	var firstErr error
+	for i, r := range s.replicas {
+		// update replica
+		if err := r.Service.Update(); err != nil {
+			log.Printf("WARNING: %s/Update: replica '%s': %v", packageName, r.Name, err)
+			lastErr[i] = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		healthy[i] = true
+
+		// merge files
+		for _, f := range r.Service.Files().All() {
+			id := fileId(f)
+			copies[id] = append(copies[id], copyFile{replica: i, file: f})
+			if _, ok := byId[id]; !ok {
+				byId[id] = impl.NewFile(id, f.Name(), f.ModTime(), f.Size(), f.Md5())
+			}
+		}
+	}
+
+	// all replicas fail
+	if !anyTrue(healthy) {
+		log.Printf("ERROR: %s/Update: all replicas fail: %v", packageName, firstErr)
+		return firstErr
+	}
+
+	// set new lists
+	s.mux.Lock() // WRITE Lock
+	s.files = impl.NewFiles(byId)
+	s.copies = copies
+	s.healthy = healthy
+	s.lastErr = lastErr
+	s.readErrs = make([]int, len(s.replicas))
+	s.bad = make(map[string]bool)
+	s.mux.Unlock()
+
+	// log health
+	for _, h := range s.Health() {
+		if !h.Healthy || h.Missing > 0 {
+			log.Printf("WARNING: %s/Update: replica '%s': healthy=%v, files=%d, missing=%d", packageName, h.Name, h.Healthy, h.Files, h.Missing)
+		}
+	}
+	log.Printf("INFO: %s/Update: successful file update (%d files, %d replicas)", packageName, len(byId), len(s.replicas))
+	return nil
+}
+
+// Files is the implementation of Service.Files()
+func (s *_ReplicaService) Files() interf.Files {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	return s.files
+}
+
+// Save is the implementation of Service.Save()
+//
+// The data is first written to a local temp file and then saved on all healthy replicas.
+// Each running Save needs temp disk space for the whole file (e.g. n upload workers: n parts, up to db.PartSize each).
+// A replica with a save error is marked as unhealthy (@see Repair).
+// Returns an error only if no replica could save the file.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_ReplicaService) Save(name string, r io.Reader, max int64) (file interf.File, err error) {
+	// check input
+	if r == nil {
+		return nil, errors.New("invalid input")
+	}
+
+	// limit reader
+	if max > 0 {
+		r = io.LimitReader(r, max)
+	}
+
+	// write to temp file and hash
+	fh, err := ioutil.TempFile("", "splitfs-replica-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(fh, hash), r)
+	if err != nil {
+		return nil, fmt.Errorf("save error: %v", err)
+	}
+	md5Str := fmt.Sprintf("%x", hash.Sum(nil))
+
+	// save on all healthy replicas
+	var saved interf.File
+	newCopies := make([]copyFile, 0, len(s.replicas))
+	for i, rep := range s.replicas {
+		if !s.isHealthy(i) {
+			continue
+		}
+		if _, err = fh.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		f, e := rep.Service.Save(name, fh, 0)
+		if e == nil && (f.Size() != size || f.Md5() != md5Str) {
+			e = fmt.Errorf("wrong size or md5: %d, %s", f.Size(), f.Md5())
+			_ = rep.Service.Trash(f)
+		}
+		if e != nil {
+			log.Printf("WARNING: %s/Save: replica '%s': '%s': %v", packageName, rep.Name, name, e)
+			s.setError(i, e)
+			err = e
+			continue
+		}
+		if saved == nil {
+			saved = f
+		}
+		newCopies = append(newCopies, copyFile{replica: i, file: f})
+	}
+
+	// no replica
+	if saved == nil {
+		if err == nil {
+			err = errors.New("no healthy replica")
+		}
+		log.Printf("ERROR: %s/Save: '%s': %v", packageName, name, err)
+		return nil, err
+	}
+
+	// remember the copies (for Trash and Reader before the next Update)
+	id := fileId(saved)
+	s.mux.Lock() // WRITE Lock
+	s.copies[id] = append(s.copies[id], newCopies...)
+	s.mux.Unlock()
+
+	// return file
+	return impl.NewFile(id, saved.Name(), saved.ModTime(), saved.Size(), saved.Md5()), nil
+}
+
+// Trash is the implementation of Service.Trash()
+//
+// Trash removes all copies of the file on all healthy replicas.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_ReplicaService) Trash(file interf.File) error {
+	if file == nil {
+		return errors.New("file is nil")
+	}
+	copies := s.copiesOf(file)
+	if len(copies) == 0 {
+		return fmt.Errorf("file not found: '%s'", file.Id())
+	}
+
+	// trash all copies
+	var err error
+	for _, c := range copies {
+		if e := s.replicas[c.replica].Service.Trash(c.file); e != nil {
+			log.Printf("WARNING: %s/Trash: replica '%s': '%s': %v", packageName, s.replicas[c.replica].Name, c.file.Id(), e)
+			s.setError(c.replica, e)
+			err = e
+		}
+	}
+	return err
+}
+
+// Reader is the implementation of Service.Reader()
+// Delegate to LimitedReader with n=interf.MaxFileSize
+func (s *_ReplicaService) Reader(file interf.File, off int64) (io.ReadCloser, error) {
+	return s.LimitedReader(file, off, interf.MaxFileSize)
+}
+
+// LimitedReader is the implementation of Service.LimitedReader()
+//
+// LimitedReader opens the first readable copy of the file (streaming).
+// If a read fails, the reader continues with the next copy at the same offset.
+// If the reader covers the whole file, the md5 hash is checked when the end is reached: a wrong hash
+// is returned instead of io.EOF and the copy is no longer used, so the next read uses another copy.
+// The data of a range (off > 0 or n < size) is not checked.
+// The connection must be closed manually with Close() after use.
+// This method is thread-safe.
+func (s *_ReplicaService) LimitedReader(file interf.File, off int64, n int64) (io.ReadCloser, error) {
+	if file == nil {
+		return nil, errors.New("file is nil")
+	}
+	if off >= file.Size() {
+		return nil, io.EOF
+	}
+
+	// end of data
+	end := file.Size()
+	if n > 0 && off+n < end {
+		end = off + n
+	}
+
+	// open first copy
+	r := &_FailoverReader{s: s, file: file, copies: s.copiesOf(file), off: off, end: end}
+	if off == 0 && end == file.Size() && file.Md5() != "" {
+		r.hash = md5.New() // whole file: check md5 at the end
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReaderAt is the implementation of Service.ReaderAt()
+//
+// ReaderAt reads sectors (@see LimitedReader). The md5 hash is only checked, if a sector reader
+// reaches the end of the file. Data read through ReaderAt or MultiReaderAt (e.g. webdav) is not
+// protected against corrupt copies.
+func (s *_ReplicaService) ReaderAt(file interf.File) (interf.ReaderAt, error) {
+	return impl.NewReaderAt(file, s, s.readerCache, s.debugLvl)
+}
+
+// MultiReaderAt is the implementation of Service.MultiReaderAt()
+func (s *_ReplicaService) MultiReaderAt(list []interf.File) (interf.ReaderAt, error) {
+	if len(list) == 1 {
+		// use the normal ReaderAt for single files
+		return s.ReaderAt(list[0])
+	} else {
+		// MultiReaderAt
+		return impl.NewMultiReaderAt(list, s, s.readerCache, s.debugLvl)
+	}
+}
+
+// Cache returns the internal cache instance. Can be NIL.
+func (s *_ReplicaService) Cache() interf.Cache {
+	return s.readerCache
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+// Replicas is the implementation of Service.Replicas()
+func (s *_ReplicaService) Replicas() []interf.Service {
+	list := make([]interf.Service, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		list = append(list, r.Service)
+	}
+	return list
+}
+
+// Health is the implementation of Service.Health()
+func (s *_ReplicaService) Health() []Health {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	list := make([]Health, len(s.replicas))
+	for i, r := range s.replicas {
+		list[i] = Health{Name: r.Name, Healthy: s.healthy[i], ReadErrs: s.readErrs[i], LastError: s.lastErr[i]}
+	}
+	for _, copies := range s.copies {
+		found := make([]bool, len(s.replicas))
+		for _, c := range copies {
+			found[c.replica] = true
+		}
+		for i := range list {
+			if found[i] {
+				list[i].Files++
+			} else {
+				list[i].Missing++
+			}
+		}
+	}
+	return list
+}
+
+// Repair is the implementation of Service.Repair()
+//
+// The files are copied without decryption. Each copy is checked (size and md5).
+// Unhealthy replicas are skipped. Update() is called before and after the repair.
+func (s *_ReplicaService) Repair() (int, error) {
+	if err := s.Update(); err != nil {
+		return 0, err
+	}
+
+	// sorted list of all files
+	files := s.Files().All()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Id() < files[j].Id()
+	})
+
+	count := 0
+	var err error
+	for _, f := range files {
+		copies := s.copiesOf(f)
+		found := make([]bool, len(s.replicas))
+		for _, c := range copies {
+			found[c.replica] = true
+		}
+
+		for i, rep := range s.replicas {
+			if found[i] || !s.isHealthy(i) {
+				continue
+			}
+			if s.debugLvl >= impl.DebugLow {
+				log.Printf("DEBUG: %s/Repair: copy '%s' to replica '%s'", packageName, f.Name(), rep.Name)
+			}
+			if e := s.copyTo(f, i); e != nil {
+				log.Printf("ERROR: %s/Repair: replica '%s': '%s': %v", packageName, rep.Name, f.Name(), e)
+				s.setError(i, e)
+				err = e
+				continue
+			}
+			count++
+		}
+	}
+
+	// update file lists
+	if count > 0 {
+		if e := s.Update(); e != nil && err == nil {
+			err = e
+		}
+	}
+	log.Printf("INFO: %s/Repair: %d files copied", packageName, count)
+	return count, err
+}
+
+//---------  Helper  -------------------------------------------------------------------------------------------------//
+
+// fileId returns the id of a file in this service: '<name>|<size>|<md5>'
+func fileId(f interf.File) string {
+	return fmt.Sprintf("%s|%d|%s", f.Name(), f.Size(), f.Md5())
+}
+
+// copiesOf returns all copies of a file on healthy replicas (without copies with a wrong md5 hash).
+func (s *_ReplicaService) copiesOf(file interf.File) []copyFile {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	list := make([]copyFile, 0)
+	for _, c := range s.copies[file.Id()] {
+		if s.healthy[c.replica] && !s.bad[badKey(c)] {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// copyTo copies a file from another replica to the replica i.
+// A copy with a wrong md5 hash is skipped and the next copy is used.
+func (s *_ReplicaService) copyTo(file interf.File, i int) error {
+	var err error
+	for n := len(s.copiesOf(file)); n > 0; n-- {
+		if err = s.copyOnce(file, i); err == nil || !errors.Is(err, errWrongMd5) {
+			return err
+		}
+	}
+	return err
+}
+
+// copyOnce copies a file from the first readable copy to the replica i.
+func (s *_ReplicaService) copyOnce(file interf.File, i int) error {
+	r, err := s.Reader(file, 0)
+	if err == io.EOF {
+		r, err = ioutil.NopCloser(strings.NewReader("")), nil // empty file
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the reader error (e.g. wrong md5 hash) is returned instead of the save error
+	er := &_ErrReader{Reader: r}
+	f, err := s.replicas[i].Service.Save(file.Name(), er, 0)
+	if er.err != nil {
+		if err == nil {
+			_ = s.replicas[i].Service.Trash(f)
+		}
+		return er.err
+	}
+	if err != nil {
+		return err
+	}
+	if f.Size() != file.Size() || (file.Md5() != "" && f.Md5() != file.Md5()) {
+		_ = s.replicas[i].Service.Trash(f)
+		return fmt.Errorf("wrong size or md5: %d, %s", f.Size(), f.Md5())
+	}
+	return nil
+}
+
+// _ErrReader remembers the first read error (except io.EOF).
+type _ErrReader struct {
+	io.Reader
+	err error
+}
+
+// Read implements io.Reader
+func (r *_ErrReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// isHealthy returns the health of the replica i.
+func (s *_ReplicaService) isHealthy(i int) bool {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	return s.healthy[i]
+}
+
+// setError marks the replica i as unhealthy.
+func (s *_ReplicaService) setError(i int, err error) {
+	s.mux.Lock() // WRITE Lock
+	defer s.mux.Unlock()
+
+	s.healthy[i] = false
+	s.lastErr[i] = err.Error()
+}
+
+// setReadError counts a read error of a replica (the replica stays healthy).
+// A copy with a wrong md5 hash (bad=true) is no longer used for reading.
+func (s *_ReplicaService) setReadError(c copyFile, err error, bad bool) {
+	s.mux.Lock() // WRITE Lock
+	defer s.mux.Unlock()
+
+	if bad {
+		s.bad[badKey(c)] = true
+	}
+	s.readErrs[c.replica]++
+	s.lastErr[c.replica] = err.Error()
+}
+
+// badKey is the key for the bad list.
+func badKey(c copyFile) string {
+	return fmt.Sprintf("%d|%s", c.replica, c.file.Id())
+}
+
+// anyTrue returns true if at least one element is true.
+func anyTrue(list []bool) bool {
+	for _, b := range list {
+		if b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package replica_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/SchnorcherSepp/splitfs/backend/replica"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testService simulates broken storage services.
+type testService struct {
+	interf.Service
+	updateErr bool  // Update() fails
+	corrupt   bool  // LimitedReader() returns zeros
+	failAfter int64 // LimitedReader() fails after n bytes (0: off)
+}
+
+func (s *testService) Update() error {
+	if s.updateErr {
+		return errors.New("update error")
+	}
+	return s.Service.Update()
+}
+
+func (s *testService) LimitedReader(file interf.File, off int64, n int64) (io.ReadCloser, error) {
+	r, err := s.Service.LimitedReader(file, off, n)
+	if err != nil {
+		return r, err
+	}
+	if s.corrupt {
+		b, _ := ioutil.ReadAll(r)
+		return ioutil.NopCloser(bytes.NewReader(make([]byte, len(b)))), nil
+	}
+	if s.failAfter > 0 {
+		return ioutil.NopCloser(io.MultiReader(io.LimitReader(r, s.failAfter), errReader{})), nil
+	}
+	return r, nil
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read error") }
+
+// newTestService returns a replica service with n ram services.
+func newTestService(t *testing.T, n int) (replica.Service, []*testService) {
+	list := make([]replica.Replica, 0, n)
+	services := make([]*testService, 0, n)
+	for i := 0; i < n; i++ {
+		ts := &testService{Service: impl.NewRamService(nil, impl.DebugOff)}
+		services = append(services, ts)
+		list = append(list, replica.Replica{Name: string(rune('A' + i)), Service: ts})
+	}
+	s, err := replica.NewReplicaService(list, nil, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, services
+}
+
+func readAll(s interf.Service, f interf.File) (string, error) {
+	r, err := s.Reader(f, 0)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	return string(b), err
+}
+
+func TestNewReplicaService(t *testing.T) {
+	if _, err := replica.NewReplicaService(nil, nil, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+	if _, err := replica.NewReplicaService([]replica.Replica{{Name: "A"}}, nil, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestReplicaService(t *testing.T) {
+	s, services := newTestService(t, 3)
+	data := strings.Repeat("0123456789", 100)
+
+	// TEST: save on all replicas
+	f, err := s.Save("test.dat", strings.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 1000 || f.Name() != "test.dat" {
+		t.Fatalf("%#v", f)
+	}
+	_ = s.Update()
+	if len(s.Files().All()) != 1 {
+		t.Fatalf("wrong len: %d", len(s.Files().All()))
+	}
+	for _, h := range s.Health() {
+		if !h.Healthy || h.Files != 1 || h.Missing != 0 {
+			t.Errorf("%#v", h)
+		}
+	}
+	if f2, err := s.Files().ByAttr("test.dat", 1000, f.Md5()); err != nil || f2.Id() != f.Id() {
+		t.Fatalf("ByAttr: %v", err)
+	}
+
+	// TEST: limited reader
+	r, err := s.LimitedReader(f, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "5678901234" {
+		t.Errorf("wrong data: %s", b)
+	}
+	if _, err := s.LimitedReader(f, 1000, 10); err != io.EOF {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// TEST: missing on A -> read from B
+	af, _ := services[0].Files().ByAttr("test.dat", 1000, f.Md5())
+	_ = services[0].Trash(af)
+	_ = s.Update()
+	if h := s.Health()[0]; h.Missing != 1 || h.Files != 0 {
+		t.Errorf("%#v", h)
+	}
+	if is, err := readAll(s, f); err != nil || is != data {
+		t.Errorf("read error: %v", err)
+	}
+
+	// TEST: repair
+	if n, err := s.Repair(); err != nil || n != 1 {
+		t.Fatalf("n=%d, err=%v", n, err)
+	}
+	if h := s.Health()[0]; h.Missing != 0 || h.Files != 1 {
+		t.Errorf("%#v", h)
+	}
+
+	// TEST: read error after 100 bytes on A -> continue with B
+	services[0].failAfter = 100
+	if is, err := readAll(s, f); err != nil || is != data {
+		t.Errorf("read error: %v", err)
+	}
+	if h := s.Health()[0]; h.ReadErrs != 1 || !h.Healthy {
+		t.Errorf("%#v", h)
+	}
+	services[0].failAfter = 0
+
+	// TEST: wrong md5 on A -> error at the end, then A is no longer used
+	services[0].corrupt = true
+	if _, err := readAll(s, f); err == nil {
+		t.Error("no error")
+	}
+	if h := s.Health()[0]; h.ReadErrs != 2 || !h.Healthy {
+		t.Errorf("%#v", h)
+	}
+	if is, err := readAll(s, f); err != nil || is != data {
+		t.Errorf("read error: %v", err)
+	}
+	if h := s.Health()[0]; h.ReadErrs != 2 {
+		t.Errorf("bad copy used again: %#v", h)
+	}
+	services[0].corrupt = false
+
+	// TEST: update error on C -> C is ignored
+	services[2].updateErr = true
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.Health()[2]; h.Healthy || h.LastError == "" {
+		t.Errorf("%#v", h)
+	}
+	f3, err := s.Save("new.dat", strings.NewReader("new"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = services[2].Service.Update()
+	if len(services[2].Files().All()) != 1 {
+		t.Error("save on unhealthy replica")
+	}
+
+	// TEST: trash on all healthy replicas
+	if err := s.Trash(f3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trash(f); err != nil {
+		t.Fatal(err)
+	}
+	services[2].updateErr = false
+	_ = s.Update()
+	if len(s.Files().All()) != 1 || s.Health()[2].Files != 1 || s.Health()[0].Missing != 1 {
+		t.Errorf("trash fail: %#v", s.Health())
+	}
+
+	// TEST: all replicas fail
+	for _, ts := range services {
+		ts.updateErr = true
+	}
+	if err := s.Update(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestReplicaService_corrupt(t *testing.T) {
+	s, services := newTestService(t, 3)
+	data := strings.Repeat("0123456789", 100)
+
+	f, err := s.Save("test.dat", strings.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Update()
+
+	// TEST: A serves corrupt bytes -> range reads are streamed (not checked)
+	services[0].corrupt = true
+	r, err := s.Reader(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if n, err := r.Read(buf); err != nil || n != 10 {
+		t.Errorf("n=%d, err=%v", n, err)
+	}
+	_ = r.Close()
+	if h := s.Health()[0]; h.ReadErrs != 0 {
+		t.Errorf("%#v", h)
+	}
+
+	// TEST: whole file -> md5 error at the end, the next read succeeds from B
+	if _, err := readAll(s, f); err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Errorf("wrong error: %v", err)
+	}
+	if is, err := readAll(s, f); err != nil || is != data {
+		t.Errorf("read error: %v", err)
+	}
+
+	// TEST: repair from a corrupt copy -> the next copy is used
+	s, services = newTestService(t, 3)
+	f, _ = s.Save("test.dat", strings.NewReader(data), 0)
+	_ = services[2].Update()
+	cf, _ := services[2].Files().ByAttr("test.dat", 1000, f.Md5())
+	_ = services[2].Trash(cf)
+	_ = s.Update()
+	services[0].corrupt = true
+	if n, err := s.Repair(); err != nil || n != 1 {
+		t.Fatalf("n=%d, err=%v", n, err)
+	}
+	services[0].corrupt = false
+	_ = services[2].Update()
+	cf, err = services[2].Files().ByAttr("test.dat", 1000, f.Md5())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is, err := readAll(services[2], cf); err != nil || is != data {
+		t.Errorf("wrong copy: %v", err)
+	}
+
+	// TEST: all copies corrupt -> error
+	s, services = newTestService(t, 2)
+	f, _ = s.Save("test.dat", strings.NewReader(data), 0)
+	_ = s.Update()
+	services[0].corrupt = true
+	services[1].corrupt = true
+	for i := 0; i < 2; i++ {
+		if _, err := readAll(s, f); err == nil {
+			t.Error("no error")
+		}
+	}
+	for _, h := range s.Health() {
+		if h.ReadErrs != 1 {
+			t.Errorf("%#v", h)
+		}
+	}
+}
+
+func TestReplicaService_upload(t *testing.T) {
+	root, err := ioutil.TempDir("", "replicaServiceTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// test data
+	dataDir := filepath.Join(root, "data")
+	_ = os.MkdirAll(filepath.Join(dataDir, "sub"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "a.txt"), bytes.Repeat([]byte("text "), 1000), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "sub", "b.dat"), []byte{1, 2, 3}, 0600)
+	keyPath := filepath.Join(root, "key.dat")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+
+	// scan & upload
+	vDb, _, _, err := db.FromScan(dataDir, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, services := newTestService(t, 2)
+	if err := core.Upload(dataDir, vDb, keyFile.IndexKey(), s, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// add an unknown file on B and remove all files on A
+	_, _ = services[1].Save(strings.Repeat("a", 128), strings.NewReader("unknown"), 0)
+	_ = services[0].Update()
+	for _, f := range services[0].Files().All() {
+		_ = services[0].Trash(f)
+	}
+
+	// read files (A is empty)
+	_ = s.Update()
+	vDb2, err := core.LoadDb(s, keyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.dat"} {
+		vFile := vDb2.VFiles[name]
+		rAt, err := core.Open(vFile, vDb2, s, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(filepath.Join(dataDir, name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// clean (per replica) and repair
+	if err := core.Clean(vDb2, keyFile.IndexKey(), s, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Repair(); err != nil || n != 3 { // 2 parts + index
+		t.Fatalf("n=%d, err=%v", n, err)
+	}
+	for _, h := range s.Health() {
+		if h.Files != 3 || h.Missing != 0 {
+			t.Errorf("%#v", h)
+		}
+	}
+	report, err := core.Verify(vDb2, s, true, impl.DebugOff)
+	if err != nil || !report.Ok() {
+		t.Errorf("err=%v, report=%#v", err, report)
+	}
+}
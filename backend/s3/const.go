@@ -0,0 +1,13 @@
+package s3
+
+// packageName is used for debug and error messages
+const packageName = "s3"
+
+// trashPrefix is the key prefix for trashed objects (after the storage prefix).
+const trashPrefix = ".trash/"
+
+// defaultRegion is used if no region is set (MinIO and most other servers ignore the region).
+const defaultRegion = "us-east-1"
+
+// emptySHA256 is the hex encoded sha256 hash of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
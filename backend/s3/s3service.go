@@ -0,0 +1,459 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/internal/naming"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interface check: interf.Service
+var _ interf.Service = (*_S3Service)(nil)
+
+// Config contains the connection settings for NewS3Service().
+type Config struct {
+
+	// Endpoint is the server url with scheme (e.g. http://127.0.0.1:9000).
+	// Path-style requests are used: <Endpoint>/<Bucket>/<Key>
+	Endpoint string
+
+	// Region is used for the signature (default: us-east-1).
+	Region string
+
+	// Bucket must exist.
+	Bucket string
+
+	// Prefix is the key prefix for all storage objects (optional, e.g. 'splitfs/').
+	Prefix string
+
+	// AccessKey and SecretKey are the credentials.
+	AccessKey string
+	SecretKey string
+}
+
+// _S3Service stores all files as objects in a S3 bucket.
+// Must be created with NewS3Service().
+//
+// Each storage file is saved as '<prefix>[ab/cd/]<name>.<md5>.<random>' (@see naming). Files with a long
+// name (parts and bundles) are sharded. The file id is the object key.
+// The md5 hash is part of the key and is also saved as object metadata 'x-amz-meta-md5'.
+// The ETag is not used: it is not the md5 hash for multipart uploads and encrypted objects (SSE-KMS, SSE-C).
+// Older objects '<prefix>[ab/cd/]<name>.<random>' have the md5 hash only in the metadata (one HEAD request per object).
+type _S3Service struct {
+	conf        Config
+	endpoint    *url.URL
+	client      *http.Client
+	readerCache interf.Cache
+	debugLvl    uint8
+	mux         *sync.RWMutex
+	files       interf.Files
+	md5Cache    map[string]string // object key -> md5 from metadata (objects are immutable)
+}
+
+// NewS3Service returns an interface to a S3 bucket. No request is sent (@see Update).
+// readerCache=nil disable the cache for ReaderAt() and MultiReaderAt()
+// debugLvl (@see impl.DebugHigh and impl.DebugOff)
+func NewS3Service(conf Config, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	// check endpoint
+	endpoint, err := url.Parse(strings.TrimRight(conf.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint: '%s'", conf.Endpoint)
+	}
+
+	// check bucket
+	if conf.Bucket == "" || strings.Contains(conf.Bucket, "/") {
+		return nil, fmt.Errorf("invalid bucket: '%s'", conf.Bucket)
+	}
+
+	// defaults
+	if conf.Region == "" {
+		conf.Region = defaultRegion
+	}
+	conf.Prefix = strings.Trim(conf.Prefix, "/")
+	if conf.Prefix != "" {
+		conf.Prefix += "/"
+	}
+
+	// return service
+	return &_S3Service{
+		conf:        conf,
+		endpoint:    endpoint,
+		client:      &http.Client{},
+		readerCache: readerCache,
+		debugLvl:    debugLvl,
+		mux:         new(sync.RWMutex),
+		files:       impl.NewFiles(nil), // empty list, set by Update()
+		md5Cache:    make(map[string]string),
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+// Update is the implementation of Service.Update()
+//
+// Update lists all objects with the storage prefix and rebuilds the internal file index.
+// This method is thread-safe.
+func (s *_S3Service) Update() error {
+	byId := make(map[string]interf.File)
+
+	token := ""
+	for {
+		// list objects (max. 1000 per request)
+		query := url.Values{"list-type": {"2"}, "prefix": {s.conf.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil, nil, emptySHA256, 0)
+		if err != nil {
+			log.Printf("ERROR: %s/Update: %v", packageName, err)
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			log.Printf("ERROR: %s/Update: %v", packageName, err)
+			return err
+		}
+
+		// add files
+		for _, obj := range result.Contents {
+			if f := s.newFile(obj); f != nil {
+				byId[f.Id()] = f
+			}
+		}
+
+		// next page
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	// set new list
+	s.mux.Lock() // WRITE Lock
+	s.files = impl.NewFiles(byId)
+	s.mux.Unlock()
+
+	log.Printf("INFO: %s/Update: successful file update (%d files)", packageName, len(byId))
+	return nil
+}
+
+// Files is the implementation of Service.Files()
+func (s *_S3Service) Files() interf.Files {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	return s.files
+}
+
+// Save is the implementation of Service.Save()
+//
+// The data is first written to a local temp file to get the size and the md5 hash.
+// Then the object is uploaded with a single PUT request (max. 5 GB).
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_S3Service) Save(name string, r io.Reader, max int64) (file interf.File, err error) {
+	// check input
+	name = strings.TrimSpace(name)
+	if name == "" || r == nil || strings.ContainsAny(name, "/\\") || name[0] == '.' {
+		return nil, errors.New("invalid input")
+	}
+
+	// limit reader
+	if max > 0 {
+		r = io.LimitReader(r, max)
+	}
+
+	// write to temp file and hash
+	fh, err := ioutil.TempFile("", "splitfs-s3-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fh, md5Hash, sha256Hash), r)
+	if err != nil {
+		return nil, fmt.Errorf("save error: %v", err)
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	md5Sum := md5Hash.Sum(nil)
+
+	// upload
+	key := s.conf.Prefix + naming.FileId(name, fmt.Sprintf("%x", md5Sum))
+	header := http.Header{
+		"Content-Md5":    {base64.StdEncoding.EncodeToString(md5Sum)},
+		"X-Amz-Meta-Md5": {fmt.Sprintf("%x", md5Sum)},
+	}
+	resp, err := s.do("PUT", key, nil, header, fh, fmt.Sprintf("%x", sha256Hash.Sum(nil)), size)
+	if err != nil {
+		log.Printf("ERROR: %s/Save: %v", packageName, err)
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	// return file
+	return impl.NewFile(key, name, time.Now().Unix(), size, fmt.Sprintf("%x", md5Sum)), nil
+}
+
+// Trash is the implementation of Service.Trash()
+//
+// S3 has no trash. The object is copied to the trash prefix and then deleted.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_S3Service) Trash(file interf.File) error {
+	key, err := s.key(file)
+	if err != nil {
+		return err
+	}
+
+	// copy to trash
+	header := http.Header{"X-Amz-Copy-Source": {"/" + s.conf.Bucket + "/" + escape(key, false)}}
+	trashKey := s.conf.Prefix + trashPrefix + strings.TrimPrefix(key, s.conf.Prefix)
+	resp, err := s.do("PUT", trashKey, nil, header, nil, emptySHA256, 0)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	// delete
+	resp, err = s.do("DELETE", key, nil, nil, nil, emptySHA256, 0)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// Reader is the implementation of Service.Reader()
+// Delegate to LimitedReader with n=interf.MaxFileSize
+func (s *_S3Service) Reader(file interf.File, off int64) (io.ReadCloser, error) {
+	return s.LimitedReader(file, off, interf.MaxFileSize)
+}
+
+// LimitedReader is the implementation of Service.LimitedReader()
+//
+// LimitedReader sends a range GET request.
+// The connection must be closed manually with Close() after use.
+// This method is thread-safe.
+func (s *_S3Service) LimitedReader(file interf.File, off int64, n int64) (io.ReadCloser, error) {
+	key, err := s.key(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// check offset
+	if off < 0 || n <= 0 {
+		return nil, errors.New("invalid input")
+	}
+	if off >= file.Size() {
+		return nil, io.EOF
+	}
+
+	// range request
+	end := off + n - 1
+	if end >= file.Size() {
+		end = file.Size() - 1
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end)}}
+	resp, err := s.do("GET", key, nil, header, nil, emptySHA256, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent && off > 0 {
+		_ = resp.Body.Close()
+		return nil, errors.New("range request not supported")
+	}
+
+	// return
+	return &naming.LimitedFile{Reader: io.LimitReader(resp.Body, end-off+1), Closer: resp.Body}, nil
+}
+
+// ReaderAt is the implementation of Service.ReaderAt()
+func (s *_S3Service) ReaderAt(file interf.File) (interf.ReaderAt, error) {
+	return impl.NewReaderAt(file, s, s.readerCache, s.debugLvl)
+}
+
+// MultiReaderAt is the implementation of Service.MultiReaderAt()
+func (s *_S3Service) MultiReaderAt(list []interf.File) (interf.ReaderAt, error) {
+	if len(list) == 1 {
+		// use the normal ReaderAt for single files
+		return s.ReaderAt(list[0])
+	} else {
+		// MultiReaderAt
+		return impl.NewMultiReaderAt(list, s, s.readerCache, s.debugLvl)
+	}
+}
+
+// Cache returns the internal cache instance. Can be NIL.
+func (s *_S3Service) Cache() interf.Cache {
+	return s.readerCache
+}
+
+//---------  Helper  -------------------------------------------------------------------------------------------------//
+
+// listBucketResult is the response of ListObjectsV2.
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []listObject
+}
+
+// listObject is a single object in listBucketResult.
+type listObject struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+// errorResponse is the error body of a failed request.
+type errorResponse struct {
+	Code    string
+	Message string
+}
+
+// do sends a signed request for the object key (key="" for the bucket).
+// The response body must be closed if no error is returned.
+// Responses with a status >= 300 return an error.
+func (s *_S3Service) do(method, key string, query url.Values, header http.Header, body io.Reader, payloadHash string, size int64) (*http.Response, error) {
+	// build url (path-style)
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.conf.Bucket + "/" + key
+	u.RawPath = escape(u.Path, false)
+	u.RawQuery = query.Encode()
+
+	// build request
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sign(req, s.conf.AccessKey, s.conf.SecretKey, s.conf.Region, payloadHash, time.Now())
+
+	// debug
+	if s.debugLvl >= impl.DebugHigh {
+		log.Printf("DEBUG: %s/do: %s %s", packageName, method, u.Path)
+	}
+
+	// send
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		var e errorResponse
+		_ = xml.Unmarshal(b, &e)
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%s '%s': %s: %s %s", method, key, resp.Status, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+// newFile returns the storage file of a listed object (or nil for foreign and trashed objects).
+func (s *_S3Service) newFile(obj listObject) interf.File {
+	rel := strings.TrimPrefix(obj.Key, s.conf.Prefix)
+	if strings.HasPrefix(rel, trashPrefix) {
+		return nil
+	}
+
+	// name: '<name>.<md5>.<random>'
+	base := path.Base(rel)
+	name, md5, ok := naming.ParseFileName(base)
+	if !ok {
+		// older objects: '<name>.<random>'
+		i := strings.LastIndex(base, ".")
+		if i < 1 || !isHex(base[i+1:], 16) {
+			if s.debugLvl >= impl.DebugHigh {
+				log.Printf("DEBUG: %s/Update: ignore unknown object '%s'", packageName, obj.Key)
+			}
+			return nil
+		}
+		name = base[:i]
+
+		// md5: metadata or ETag (only if the metadata is missing)
+		var err error
+		if md5, err = s.metaMd5(obj.Key); err != nil {
+			md5 = strings.ToLower(strings.Trim(obj.ETag, "\""))
+			if !isHex(md5, 32) {
+				log.Printf("WARNING: %s/Update: no md5 for '%s': %v", packageName, obj.Key, err)
+				return nil
+			}
+		}
+	}
+
+	return impl.NewFile(obj.Key, name, obj.LastModified.Unix(), obj.Size, md5)
+}
+
+// metaMd5 reads the md5 from the object metadata (HEAD request).
+// The result is cached, because objects are immutable.
+func (s *_S3Service) metaMd5(key string) (string, error) {
+	s.mux.RLock()
+	md5, ok := s.md5Cache[key]
+	s.mux.RUnlock()
+	if ok {
+		return md5, nil
+	}
+
+	resp, err := s.do("HEAD", key, nil, nil, nil, emptySHA256, 0)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	md5 = strings.ToLower(resp.Header.Get("X-Amz-Meta-Md5"))
+	if !isHex(md5, 32) {
+		return "", errors.New("metadata 'x-amz-meta-md5' not found")
+	}
+
+	s.mux.Lock()
+	s.md5Cache[key] = md5
+	s.mux.Unlock()
+	return md5, nil
+}
+
+// key returns the object key of a storage file.
+func (s *_S3Service) key(file interf.File) (string, error) {
+	if file == nil {
+		return "", errors.New("file is nil")
+	}
+	key := file.Id()
+	if key == "" || !strings.HasPrefix(key, s.conf.Prefix) {
+		return "", fmt.Errorf("invalid file id: '%s'", key)
+	}
+	return key, nil
+}
+
+// isHex checks the length and the chars of a lower case hex string.
+func isHex(s string, length int) bool {
+	return len(s) == length && strings.Trim(s, "0123456789abcdef") == ""
+}
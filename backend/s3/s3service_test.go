@@ -0,0 +1,360 @@
+package s3_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/s3"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal S3 server (path-style, one bucket, no signature check).
+type fakeS3 struct {
+	bucket        string
+	mux           sync.Mutex
+	objects       map[string][]byte
+	meta          map[string]string // key -> x-amz-meta-md5
+	multipartETag bool              // simulate multipart uploads (ETag is not a md5 hash)
+	encryptedETag bool              // simulate SSE-KMS or SSE-C (ETag is a hex string, but not the md5 hash)
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: make(map[string][]byte), meta: make(map[string]string)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	// check auth and bucket
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/") {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/") {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+
+	switch {
+	case r.Method == "GET" && key == "":
+		f.list(w, r.URL.Query())
+
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		data, ok := f.objects[strings.TrimPrefix(src, "/"+f.bucket+"/")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		f.objects[key] = data
+		_, _ = fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+
+	case r.Method == "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "<Error><Code>BadDigest</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		f.meta[key] = r.Header.Get("X-Amz-Meta-Md5")
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Amz-Meta-Md5", f.meta[key])
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			_, _ = fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			if start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[start : end+1])
+			return
+		}
+		_, _ = w.Write(data)
+
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list is ListObjectsV2 with max. 2 keys per page (to test the pagination).
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	keys := make([]string, 0)
+	for k := range f.objects {
+		if strings.HasPrefix(k, query.Get("prefix")) && k > query.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string
+		Contents              []object
+	}{}
+	for i, k := range keys {
+		if i == 2 {
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[1]
+			break
+		}
+		etag := fmt.Sprintf("\"%x\"", md5.Sum(f.objects[k]))
+		if f.encryptedETag {
+			etag = fmt.Sprintf("\"%x\"", md5.Sum(append([]byte("sse"), f.objects[k]...)))
+		}
+		if f.multipartETag {
+			etag = "\"0123456789abcdef0123456789abcdef-" + strconv.Itoa(i+2) + "\""
+		}
+		result.Contents = append(result.Contents, object{Key: k, LastModified: time.Now(), ETag: etag, Size: len(f.objects[k])})
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+func TestNewS3Service(t *testing.T) {
+	for _, conf := range []s3.Config{
+		{Endpoint: "127.0.0.1:9000", Bucket: "b"},
+		{Endpoint: "ftp://127.0.0.1:9000", Bucket: "b"},
+		{Endpoint: "http://127.0.0.1:9000", Bucket: ""},
+		{Endpoint: "http://127.0.0.1:9000", Bucket: "a/b"},
+	} {
+		if _, err := s3.NewS3Service(conf, nil, impl.DebugOff); err == nil {
+			t.Errorf("no error: %#v", conf)
+		}
+	}
+}
+
+func TestS3Service(t *testing.T) {
+	fake := newFakeS3("bucket")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := s3.Config{Endpoint: server.URL, Bucket: "bucket", Prefix: "/backup/", AccessKey: "ak", SecretKey: "sk"}
+	s, err := s3.NewS3Service(conf, nil, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: invalid names
+	for _, name := range []string{"", " ", "a/b", ".hidden"} {
+		if _, err := s.Save(name, strings.NewReader("x"), 0); err == nil {
+			t.Errorf("no error: '%s'", name)
+		}
+	}
+
+	// TEST: save (with max)
+	partName := strings.Repeat("ab12", 32) // 128 chars
+	f1, err := s.Save(partName, strings.NewReader("0123456789"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f1.Name() != partName || f1.Size() != 5 || f1.Md5() != "4100c4d44da9177247e44a5fc1546778" {
+		t.Fatalf("%s %d %s", f1.Name(), f1.Size(), f1.Md5())
+	}
+	if !strings.HasPrefix(f1.Id(), "backup/ab/12/"+partName+".") {
+		t.Errorf("no sharding: %s", f1.Id())
+	}
+	f2, _ := s.Save(core.IndexName, strings.NewReader("db"), 0)
+	f3, _ := s.Save(core.IndexName, strings.NewReader("db"), 0) // same name and content
+	if f2.Id() == f3.Id() || f2.Id() != "backup/"+relKey(f2.Id()) {
+		t.Errorf("wrong id: %s %s", f2.Id(), f3.Id())
+	}
+
+	// TEST: update (pagination; foreign objects are ignored)
+	fake.objects["backup/foreign.txt"] = []byte("x")
+	fake.objects["other/"+core.IndexName+".0123456789abcdef"] = []byte("x")
+	if len(s.Files().All()) != 0 {
+		t.Fatal("update without call")
+	}
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files().All()) != 3 {
+		t.Fatalf("wrong len: %d", len(s.Files().All()))
+	}
+	if f, err := s.Files().ByAttr(partName, 5, f1.Md5()); err != nil || f.Id() != f1.Id() {
+		t.Fatalf("ByAttr: %v", err)
+	}
+
+	// TEST: md5 from the key (the ETag is ignored)
+	fake.multipartETag = true
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := s.Files().ByAttr(core.IndexName, 2, f2.Md5()); err != nil || f.Md5() != f2.Md5() {
+		t.Fatalf("ByAttr: %v", err)
+	}
+	fake.multipartETag = false
+
+	// TEST: older objects '<name>.<random>': md5 from metadata (encrypted ETag), ETag only without metadata
+	legacyMd5 := fmt.Sprintf("%x", md5.Sum([]byte("legacy")))
+	fake.objects["backup/legacy.dat.0123456789abcdef"] = []byte("legacy")
+	fake.meta["backup/legacy.dat.0123456789abcdef"] = legacyMd5
+	fake.objects["backup/nometa.dat.0123456789abcdef"] = []byte("legacy")
+	fake.encryptedETag = true
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Files().ByAttr("legacy.dat", 6, legacyMd5); err != nil {
+		t.Errorf("ByAttr: %v", err)
+	}
+	if _, err := s.Files().ByAttr("nometa.dat", 6, legacyMd5); err == nil {
+		t.Error("md5 from encrypted ETag")
+	}
+	fake.encryptedETag = false
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Files().ByAttr("nometa.dat", 6, legacyMd5); err != nil {
+		t.Errorf("ByAttr: %v", err)
+	}
+	delete(fake.objects, "backup/legacy.dat.0123456789abcdef")
+	delete(fake.objects, "backup/nometa.dat.0123456789abcdef")
+	_ = s.Update()
+
+	// TEST: reader
+	r, err := s.LimitedReader(f1, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "123" {
+		t.Errorf("wrong data: %s", b)
+	}
+	if _, err := s.Reader(f1, 5); err != io.EOF {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// TEST: readerAt
+	rAt, err := s.ReaderAt(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if n, err := rAt.ReadAt(buf, 3); n != 2 || string(buf) != "34" {
+		t.Errorf("n=%d, err=%v, data=%s", n, err, buf)
+	}
+	_ = rAt.Close()
+
+	// TEST: trash
+	if err := s.Trash(f2); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Update()
+	if len(s.Files().All()) != 2 {
+		t.Fatal("trash fail")
+	}
+	if _, ok := fake.objects["backup/.trash/"+relKey(f2.Id())]; !ok {
+		t.Error("not in trash")
+	}
+	if err := s.Trash(f2); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestS3Service_upload(t *testing.T) {
+	server := httptest.NewServer(newFakeS3("bucket"))
+	defer server.Close()
+
+	root, err := ioutil.TempDir("", "s3ServiceTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// test data
+	dataDir := filepath.Join(root, "data")
+	_ = os.MkdirAll(filepath.Join(dataDir, "sub"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "a.txt"), bytes.Repeat([]byte("text "), 1000), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "sub", "b.dat"), []byte{1, 2, 3}, 0600)
+	keyPath := filepath.Join(root, "key.dat")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+
+	// scan & upload
+	vDb, _, _, err := db.FromScan(dataDir, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := s3.Config{Endpoint: server.URL, Bucket: "bucket", AccessKey: "ak", SecretKey: "sk"}
+	service, _ := s3.NewS3Service(conf, nil, impl.DebugOff)
+	if err := core.Upload(dataDir, vDb, keyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload with a new service
+	service, _ = s3.NewS3Service(conf, impl.NewCache(10), impl.DebugOff)
+	_ = service.Update()
+	vDb2, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read files
+	for _, name := range []string{"a.txt", "sub/b.dat"} {
+		vFile := vDb2.VFiles[name]
+		rAt, err := core.Open(vFile, vDb2, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(filepath.Join(dataDir, name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// verify
+	report, err := core.Verify(vDb2, service, true, impl.DebugOff)
+	if err != nil || !report.Ok() {
+		t.Errorf("err=%v, report=%#v", err, report)
+	}
+}
+
+// relKey returns the key without prefix.
+func relKey(key string) string {
+	return key[strings.Index(key, "/")+1:]
+}
@@ -0,0 +1,4 @@
+package sftp
+
+// packageName is used for debug and error messages
+const packageName = "sftp"
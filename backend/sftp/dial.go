@@ -0,0 +1,65 @@
+package sftp
+
+import (
+	"errors"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+)
+
+// Dial connects to a SSH server and starts the SFTP subsystem.
+// The host key is checked against the known_hosts file.
+// Authentication with a private key file (keyFile) and/or a password. Empty values are ignored.
+//
+// Example:
+//   client, err := Dial("backup.example.com:22", "user", "", "/home/user/.ssh/id_ed25519", "/home/user/.ssh/known_hosts")
+func Dial(addr, user, password, keyFile, knownHostsFile string) (*pkgsftp.Client, error) {
+	// host key check
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// auth methods
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no key file and no password")
+	}
+
+	// default port
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	// connect
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := pkgsftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,5 @@
+/*
+Package sftp provides the storage service implementation for a remote directory over SFTP (e.g. offsite box with SSH access).
+
+*/
+package sftp
@@ -0,0 +1,395 @@
+package sftp
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/internal/naming"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	pkgsftp "github.com/pkg/sftp"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// interface check: interf.Service
+var _ interf.Service = (*_SftpService)(nil)
+
+// _SftpService stores all files in a remote directory over SFTP.
+// Must be created with NewSftpService().
+//
+// SFTP has no server-side md5, so the md5 hash is calculated during the upload and
+// saved in the file name: '<name>.<md5>.<random>'. Update() only needs a directory listing.
+// Files with a long name (parts and bundles) are sharded into sub folders: 'ab/cd/<name>.<md5>.<random>'.
+// The file id is the path relative to the storage root.
+//
+// A service created with NewSftpServiceWithDial reconnects after a lost connection and retries the
+// operation once (@see retry). Data that is already streamed (Save, open readers) is not retried.
+type _SftpService struct {
+	client      *pkgsftp.Client
+	lost        chan struct{}                   // closed if the connection of client is lost
+	dial        func() (*pkgsftp.Client, error) // reconnect (optional)
+	connMux     *sync.Mutex                     // client, lost
+	root        string
+	readerCache interf.Cache
+	debugLvl    uint8
+	mux         *sync.RWMutex
+	files       interf.Files
+}
+
+// NewSftpService returns an interface to a remote directory. The root folder is created if it does not exist.
+// The client is created with Dial() (@see Dial). A lost connection is not restored (@see NewSftpServiceWithDial).
+// readerCache=nil disable the cache for ReaderAt() and MultiReaderAt()
+// debugLvl (@see impl.DebugHigh and impl.DebugOff)
+func NewSftpService(client *pkgsftp.Client, root string, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	return newSftpService(client, nil, root, readerCache, debugLvl)
+}
+
+// NewSftpServiceWithDial is like NewSftpService, but the client is created with dial (e.g. a closure with Dial()).
+// If the connection is lost, dial is called again and the failed operation is retried once.
+func NewSftpServiceWithDial(dial func() (*pkgsftp.Client, error), root string, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	if dial == nil {
+		return nil, errors.New("dial is nil")
+	}
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return newSftpService(client, dial, root, readerCache, debugLvl)
+}
+
+// newSftpService creates the root folder and returns the service (dial is optional).
+func newSftpService(client *pkgsftp.Client, dial func() (*pkgsftp.Client, error), root string, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	// check input
+	if client == nil {
+		return nil, errors.New("client is nil")
+	}
+	if root == "" {
+		root = "."
+	}
+
+	// create root
+	if err := client.MkdirAll(root); err != nil {
+		return nil, err
+	}
+
+	// return service
+	return &_SftpService{
+		client:      client,
+		lost:        watchConn(client),
+		dial:        dial,
+		connMux:     new(sync.Mutex),
+		root:        path.Clean(root),
+		readerCache: readerCache,
+		debugLvl:    debugLvl,
+		mux:         new(sync.RWMutex),
+		files:       impl.NewFiles(nil), // empty list, set by Update()
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+// Update is the implementation of Service.Update()
+//
+// Update walks the remote storage root and rebuilds the internal file index.
+// The file content is not read (@see _SftpService).
+// This method is thread-safe.
+func (s *_SftpService) Update() error {
+	var byId map[string]interf.File
+	err := s.retry(func(client *pkgsftp.Client) (err error) {
+		byId, err = s.walk(client)
+		return err
+	})
+	if err != nil {
+		log.Printf("ERROR: %s/Update: %v", packageName, err)
+		return err
+	}
+
+	// set new list
+	s.mux.Lock() // WRITE Lock
+	s.files = impl.NewFiles(byId)
+	s.mux.Unlock()
+
+	log.Printf("INFO: %s/Update: successful file update (%d files)", packageName, len(byId))
+	return nil
+}
+
+// walk walks the remote storage root and returns all storage files (by id).
+func (s *_SftpService) walk(client *pkgsftp.Client) (map[string]interf.File, error) {
+	byId := make(map[string]interf.File)
+
+	walker := client.Walk(s.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		info := walker.Stat()
+
+		// relative path (= file id)
+		id := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.root), "/")
+
+		// skip temp and trash folder
+		if info.IsDir() {
+			if id == naming.TmpDir || id == naming.TrashDir {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		// parse file name
+		name, md5, ok := naming.ParseFileName(info.Name())
+		if !ok {
+			if s.debugLvl >= impl.DebugHigh {
+				log.Printf("DEBUG: %s/Update: ignore unknown file '%s'", packageName, id)
+			}
+			continue
+		}
+
+		// add file
+		byId[id] = impl.NewFile(id, name, info.ModTime().Unix(), info.Size(), md5)
+	}
+	return byId, nil
+}
+
+// Files is the implementation of Service.Files()
+func (s *_SftpService) Files() interf.Files {
+	s.mux.RLock() // READ Lock
+	defer s.mux.RUnlock()
+
+	return s.files
+}
+
+// Save is the implementation of Service.Save()
+//
+// The data is first written to the remote temp folder and renamed after
+// the md5 hash is known. Incomplete files are never visible in the file index.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_SftpService) Save(name string, r io.Reader, max int64) (file interf.File, err error) {
+	// check input
+	name = strings.TrimSpace(name)
+	if name == "" || r == nil || strings.ContainsAny(name, "/\\") || name[0] == '.' {
+		return nil, errors.New("invalid input")
+	}
+
+	// limit reader
+	if max > 0 {
+		r = io.LimitReader(r, max)
+	}
+
+	// create temp file (the data is not retried after a lost connection)
+	tmpPath := path.Join(s.root, naming.TmpDir)
+	tmpFile := path.Join(tmpPath, "save-"+naming.RandomHex())
+	var client *pkgsftp.Client
+	var fh *pkgsftp.File
+	err = s.retry(func(c *pkgsftp.Client) (err error) {
+		if err = c.MkdirAll(tmpPath); err != nil {
+			return err
+		}
+		client = c
+		fh, err = c.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Remove(tmpFile) // remove temp file (if not moved)
+
+	// write and hash
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(fh, hash), r)
+	if e := fh.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save error: %v", err)
+	}
+
+	// check size (remote)
+	st, err := client.Stat(tmpFile)
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() != size {
+		return nil, fmt.Errorf("save error: remote size %d != %d", st.Size(), size)
+	}
+
+	// move to final path
+	md5 := fmt.Sprintf("%x", hash.Sum(nil))
+	id := naming.FileId(name, md5)
+	absPath := path.Join(s.root, id)
+	if err := client.MkdirAll(path.Dir(absPath)); err != nil {
+		return nil, err
+	}
+	if err := client.Rename(tmpFile, absPath); err != nil {
+		return nil, err
+	}
+
+	// return file
+	return impl.NewFile(id, name, st.ModTime().Unix(), size, md5), nil
+}
+
+// Trash is the implementation of Service.Trash()
+//
+// Trash moves a file to the trash folder.
+// Don't forget to call Update().
+// This method is thread-safe.
+func (s *_SftpService) Trash(file interf.File) error {
+	absPath, err := s.absPath(file)
+	if err != nil {
+		return err
+	}
+
+	// move to trash
+	trashPath := path.Join(s.root, naming.TrashDir)
+	return s.retry(func(client *pkgsftp.Client) error {
+		if err := client.MkdirAll(trashPath); err != nil {
+			return err
+		}
+		return client.Rename(absPath, path.Join(trashPath, path.Base(absPath)))
+	})
+}
+
+// Reader is the implementation of Service.Reader()
+// Delegate to LimitedReader with n=interf.MaxFileSize
+func (s *_SftpService) Reader(file interf.File, off int64) (io.ReadCloser, error) {
+	return s.LimitedReader(file, off, interf.MaxFileSize)
+}
+
+// LimitedReader is the implementation of Service.LimitedReader()
+//
+// LimitedReader opens the remote file and seeks to the offset.
+// The connection must be closed manually with Close() after use.
+// This method is thread-safe.
+func (s *_SftpService) LimitedReader(file interf.File, off int64, n int64) (io.ReadCloser, error) {
+	absPath, err := s.absPath(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// check offset
+	if off >= file.Size() {
+		return nil, io.EOF
+	}
+
+	// open file and seek
+	var fh *pkgsftp.File
+	err = s.retry(func(client *pkgsftp.Client) (err error) {
+		if fh, err = client.Open(absPath); err != nil {
+			return err
+		}
+		if _, err = fh.Seek(off, io.SeekStart); err != nil {
+			_ = fh.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// return
+	return &naming.LimitedFile{Reader: io.LimitReader(fh, n), Closer: fh}, nil
+}
+
+// ReaderAt is the implementation of Service.ReaderAt()
+func (s *_SftpService) ReaderAt(file interf.File) (interf.ReaderAt, error) {
+	return impl.NewReaderAt(file, s, s.readerCache, s.debugLvl)
+}
+
+// MultiReaderAt is the implementation of Service.MultiReaderAt()
+func (s *_SftpService) MultiReaderAt(list []interf.File) (interf.ReaderAt, error) {
+	if len(list) == 1 {
+		// use the normal ReaderAt for single files
+		return s.ReaderAt(list[0])
+	} else {
+		// MultiReaderAt
+		return impl.NewMultiReaderAt(list, s, s.readerCache, s.debugLvl)
+	}
+}
+
+// Cache returns the internal cache instance. Can be NIL.
+func (s *_SftpService) Cache() interf.Cache {
+	return s.readerCache
+}
+
+//---------  Helper  -------------------------------------------------------------------------------------------------//
+
+// absPath returns the remote path of a storage file.
+func (s *_SftpService) absPath(file interf.File) (string, error) {
+	id, err := naming.CheckId(file)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.root, id), nil
+}
+
+// conn returns the current client and its lost channel.
+func (s *_SftpService) conn() (*pkgsftp.Client, chan struct{}) {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	return s.client, s.lost
+}
+
+// retry calls op with the current client. If op fails because the connection is lost,
+// the service reconnects (@see NewSftpServiceWithDial) and calls op once more.
+func (s *_SftpService) retry(op func(client *pkgsftp.Client) error) error {
+	client, lost := s.conn()
+	err := op(client)
+	if err == nil || s.dial == nil || !isLost(err, lost) {
+		return err
+	}
+
+	// reconnect and retry
+	log.Printf("WARNING: %s/retry: connection lost: %v (reconnect)", packageName, err)
+	if e := s.reconnect(client); e != nil {
+		log.Printf("ERROR: %s/retry: reconnect: %v", packageName, e)
+		return err
+	}
+	client, _ = s.conn()
+	return op(client)
+}
+
+// reconnect replaces the lost client with a new one (only once, if several operations fail at the same time).
+func (s *_SftpService) reconnect(old *pkgsftp.Client) error {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+
+	if s.client != old {
+		return nil // already replaced
+	}
+	client, err := s.dial()
+	if err != nil {
+		return err
+	}
+	_ = old.Close()
+	s.client = client
+	s.lost = watchConn(client)
+	return nil
+}
+
+// watchConn returns a channel that is closed if the connection of the client is lost.
+func watchConn(client *pkgsftp.Client) chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(lost)
+	}()
+	return lost
+}
+
+// isLost returns true, if the error is caused by a lost connection.
+func isLost(err error, lost chan struct{}) bool {
+	if errors.Is(err, pkgsftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+	select {
+	case <-lost:
+		return true
+	default:
+		return false
+	}
+}
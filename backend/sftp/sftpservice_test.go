@@ -0,0 +1,371 @@
+package sftp_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/SchnorcherSepp/splitfs/backend/sftp"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startSshd starts a local SSH server with the SFTP subsystem (user: test, password: secret).
+// Returns the address, the path of a known_hosts file and a function that drops all open connections.
+func startSshd(t *testing.T, dir string) (string, string, func()) {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "test" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mux sync.Mutex
+	conns := make([]net.Conn, 0)
+	go func() {
+		for {
+			nConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mux.Lock()
+			conns = append(conns, nConn)
+			mux.Unlock()
+			go serveSftp(nConn, config)
+		}
+	}()
+	drop := func() {
+		mux.Lock()
+		defer mux.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+		conns = conns[:0]
+	}
+
+	// known_hosts
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, signer.PublicKey())
+	_ = ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+
+	return l.Addr().String(), knownHosts, drop
+}
+
+// serveSftp handles a single SSH connection.
+func serveSftp(nConn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				_ = req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		server, err := pkgsftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		go func() {
+			_ = server.Serve()
+			_ = server.Close()
+		}()
+	}
+}
+
+func TestDial(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sftpServiceTest")
+	defer os.RemoveAll(dir)
+	addr, knownHosts, _ := startSshd(t, dir)
+
+	// TEST: wrong password
+	if _, err := sftp.Dial(addr, "test", "wrong", "", knownHosts); err == nil {
+		t.Error("no error")
+	}
+	// TEST: no auth
+	if _, err := sftp.Dial(addr, "test", "", "", knownHosts); err == nil {
+		t.Error("no error")
+	}
+	// TEST: unknown host key
+	_ = ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0600)
+	if _, err := sftp.Dial(addr, "test", "secret", "", filepath.Join(dir, "empty")); err == nil {
+		t.Error("no error")
+	}
+	// TEST: ok
+	client, err := sftp.Dial(addr, "test", "secret", "", knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+}
+
+func TestSftpService(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sftpServiceTest")
+	defer os.RemoveAll(dir)
+	addr, knownHosts, _ := startSshd(t, dir)
+	client, err := sftp.Dial(addr, "test", "secret", "", knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	root := filepath.Join(dir, "storage")
+	s, err := sftp.NewSftpService(client, root, nil, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: invalid names
+	for _, name := range []string{"", " ", "a/b", ".hidden"} {
+		if _, err := s.Save(name, strings.NewReader("x"), 0); err == nil {
+			t.Errorf("no error: '%s'", name)
+		}
+	}
+
+	// TEST: save (with max)
+	partName := strings.Repeat("ab12", 32) // 128 chars
+	f1, err := s.Save(partName, strings.NewReader("0123456789"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f1.Name() != partName || f1.Size() != 5 || f1.Md5() != "4100c4d44da9177247e44a5fc1546778" {
+		t.Fatalf("%s %d %s", f1.Name(), f1.Size(), f1.Md5())
+	}
+	if !strings.HasPrefix(f1.Id(), "ab/12/"+partName+".") {
+		t.Errorf("no sharding: %s", f1.Id())
+	}
+	f2, _ := s.Save(core.IndexName, strings.NewReader("db"), 0)
+	f3, _ := s.Save(core.IndexName, strings.NewReader("db"), 0) // same name and content
+	if f2.Id() == f3.Id() || strings.Contains(f2.Id(), "/") {
+		t.Errorf("wrong id: %s %s", f2.Id(), f3.Id())
+	}
+
+	// TEST: files are visible after update (foreign files are ignored)
+	_ = ioutil.WriteFile(filepath.Join(root, "foreign.txt"), []byte("x"), 0600)
+	if len(s.Files().All()) != 0 {
+		t.Fatal("update without call")
+	}
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files().All()) != 3 {
+		t.Fatalf("wrong len: %d", len(s.Files().All()))
+	}
+	if f, err := s.Files().ByAttr(partName, 5, f1.Md5()); err != nil || f.Id() != f1.Id() {
+		t.Fatalf("ByAttr: %v", err)
+	}
+
+	// TEST: reader
+	r, err := s.LimitedReader(f1, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "123" {
+		t.Errorf("wrong data: %s", b)
+	}
+	if _, err := s.Reader(f1, 5); err != io.EOF {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// TEST: readerAt
+	rAt, err := s.ReaderAt(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if n, err := rAt.ReadAt(buf, 3); n != 2 || string(buf) != "34" {
+		t.Errorf("n=%d, err=%v, data=%s", n, err, buf)
+	}
+	_ = rAt.Close()
+
+	// TEST: trash
+	if err := s.Trash(f2); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Update()
+	if len(s.Files().All()) != 2 {
+		t.Fatal("trash fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, ".trash", f2.Id())); err != nil {
+		t.Error(err)
+	}
+	if err := s.Trash(f2); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestSftpService_reconnect(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sftpServiceTest")
+	defer os.RemoveAll(dir)
+	addr, knownHosts, drop := startSshd(t, dir)
+	dial := func() (*pkgsftp.Client, error) {
+		return sftp.Dial(addr, "test", "secret", "", knownHosts)
+	}
+
+	// TEST: no dial
+	if _, err := sftp.NewSftpServiceWithDial(nil, dir, nil, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+
+	// without reconnect
+	client, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "storage")
+	s1, _ := sftp.NewSftpService(client, root, nil, impl.DebugOff)
+	s2, err := sftp.NewSftpServiceWithDial(dial, root, nil, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := s2.Save("test.dat", strings.NewReader("data"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: lost connection -> reconnect and retry (Update, Reader, Save, Trash)
+	drop()
+	time.Sleep(100 * time.Millisecond)
+	if err := s1.Update(); err == nil {
+		t.Error("no error without reconnect")
+	}
+	if err := s2.Update(); err != nil || len(s2.Files().All()) != 1 {
+		t.Fatalf("update after reconnect: %v", err)
+	}
+	drop()
+	time.Sleep(100 * time.Millisecond)
+	r, err := s2.Reader(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "data" {
+		t.Errorf("wrong data: %s", b)
+	}
+	drop()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s2.Save("new.dat", strings.NewReader("new"), 0); err != nil {
+		t.Error(err)
+	}
+	drop()
+	time.Sleep(100 * time.Millisecond)
+	if err := s2.Trash(f); err != nil {
+		t.Error(err)
+	}
+	_ = s2.Update()
+	if len(s2.Files().All()) != 1 {
+		t.Errorf("wrong len: %d", len(s2.Files().All()))
+	}
+}
+
+func TestSftpService_upload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sftpServiceTest")
+	defer os.RemoveAll(dir)
+	addr, knownHosts, _ := startSshd(t, dir)
+	client, err := sftp.Dial(addr, "test", "secret", "", knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// test data
+	dataDir := filepath.Join(dir, "data")
+	_ = os.MkdirAll(filepath.Join(dataDir, "sub"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "a.txt"), bytes.Repeat([]byte("text "), 1000), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dataDir, "sub", "b.dat"), []byte{1, 2, 3}, 0600)
+	keyPath := filepath.Join(dir, "key.dat")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+
+	// scan & upload
+	vDb, _, _, err := db.FromScan(dataDir, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, _ := sftp.NewSftpService(client, filepath.Join(dir, "storage"), nil, impl.DebugOff)
+	if err := core.Upload(dataDir, vDb, keyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// second upload: all parts exist (ByAttr)
+	if err := service.Update(); err != nil {
+		t.Fatal(err)
+	}
+	before := len(service.Files().All())
+	if err := core.Upload(dataDir, vDb, keyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if after := len(service.Files().All()); after != before {
+		t.Errorf("double upload: %d != %d", after, before)
+	}
+
+	// reload with a new service
+	service, _ = sftp.NewSftpService(client, filepath.Join(dir, "storage"), nil, impl.DebugOff)
+	_ = service.Update()
+	vDb2, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read files
+	for _, name := range []string{"a.txt", "sub/b.dat"} {
+		vFile := vDb2.VFiles[name]
+		rAt, err := core.Open(vFile, vDb2, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(filepath.Join(dataDir, name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// verify
+	report, err := core.Verify(vDb2, service, true, impl.DebugOff)
+	if err != nil || !report.Ok() {
+		t.Errorf("err=%v, report=%#v", err, report)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// File is the content of the config file (YAML).
+//
+//   default: home                # optional: repository without --repo
+//   repos:
+//     home:
+//       key-file: key.dat        # the keys are the long flag names (e.g. db-file, root-dir, folder-id, local-addr)
+//       backend: [gdrive, local]
+//
+// Relative paths are relative to the folder of the config file.
+type File struct {
+	Default string          `yaml:"default"`
+	Repos   map[string]Repo `yaml:"repos"`
+
+	// dir is the folder of the config file (for relative paths)
+	dir string
+}
+
+// Repo is a named repository with flag values (long flag name -> value).
+type Repo map[string]interface{}
+
+// Load reads and parses the config file. Unknown keys outside the repositories are errors.
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse (strict)
+	f := new(File)
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("config file '%s': %v", path, err)
+	}
+
+	// checks
+	if len(f.Repos) == 0 {
+		return nil, fmt.Errorf("config file '%s': no repos defined", path)
+	}
+	if f.Default != "" {
+		if _, ok := f.Repos[f.Default]; !ok {
+			return nil, fmt.Errorf("config file '%s': default repo '%s' does not exist", path, f.Default)
+		}
+	}
+
+	// folder for relative paths
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f.dir = filepath.Dir(abs)
+	return f, nil
+}
+
+// Repo returns the repository with this name.
+// Without a name, the default repository (or the only one) is returned.
+func (f *File) Repo(name string) (Repo, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" && len(f.Repos) == 1 {
+		for n := range f.Repos {
+			name = n
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no repo selected (--%s): %s", RepoFlag, strings.Join(f.Names(), ", "))
+	}
+
+	repo, ok := f.Repos[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown repo '%s': %s", name, strings.Join(f.Names(), ", "))
+	}
+	if repo == nil {
+		repo = make(Repo)
+	}
+	return repo, nil
+}
+
+// Names returns the sorted names of all repositories.
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Repos))
+	for name := range f.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error, if a repository contains a key that is not a known flag name.
+// The flags --config and --repo are not allowed in a repository.
+func (f *File) Check(flagNames map[string]bool) error {
+	for _, name := range f.Names() {
+		keys := make([]string, 0)
+		for key := range f.Repos[name] {
+			if !flagNames[key] || key == ConfigFlag || key == RepoFlag {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			return fmt.Errorf("repo '%s': unknown keys: %s", name, strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
+
+// Path returns the path relative to the folder of the config file.
+// Absolute paths and paths with '~' are not changed.
+func (f *File) Path(p string) string {
+	if p == "" || filepath.IsAbs(p) || strings.HasPrefix(p, "~") {
+		return p
+	}
+	return filepath.Join(f.dir, p)
+}
+
+// errNoConfig is returned if --repo is used without a config file.
+var errNoConfig = errors.New("--" + RepoFlag + " requires a config file (--" + ConfigFlag + ")")
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"github.com/alecthomas/kong"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const testConfig = `
+default: home
+repos:
+  home:
+    key-file: home.dat
+    backend: [gdrive, local]
+    cache-size-mb: 100
+  nas:
+    key-file: /etc/nas.dat
+    db-file: ~/nas.db2
+`
+
+// testCLI is a small command line with the flags --config and --repo
+type testCLI struct {
+	Config string `name:"config" type:"path"`
+	Repo   string `name:"repo"`
+
+	Upload struct {
+		KeyFile string   `short:"k" type:"path" default:"key.dat"`
+		DbFile  string   `short:"d" type:"path" default:"index.db2"`
+		Backend []string `short:"b" enum:"gdrive,local" default:"gdrive"`
+	} `cmd:""`
+
+	Webdav struct {
+		KeyFile     string `short:"k" type:"path" default:"key.dat"`
+		CacheSizeMB int    `short:"m" default:"500"`
+	} `cmd:""`
+}
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, _ := ioutil.TempDir("", "configTest")
+	p := path.Join(dir, "splitfs.yaml")
+	if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p, func() { os.RemoveAll(dir) }
+}
+
+func parse(args ...string) (*testCLI, error) {
+	cli := new(testCLI)
+	parser, err := kong.New(cli, kong.Resolvers(NewResolver()))
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.Parse(args)
+	return cli, err
+}
+
+func TestLoad(t *testing.T) {
+	p, cleanup := writeConfig(t, testConfig)
+	defer cleanup()
+
+	// TEST: load and select repos
+	f, err := Load(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := f.Repo(""); err != nil || r["key-file"] != "home.dat" {
+		t.Errorf("wrong default repo: %v, %v", r, err)
+	}
+	if r, err := f.Repo("nas"); err != nil || r["key-file"] != "/etc/nas.dat" {
+		t.Errorf("wrong repo: %v, %v", r, err)
+	}
+	if _, err := f.Repo("xxx"); err == nil {
+		t.Error("unknown repo found")
+	}
+
+	// TEST: relative paths
+	if f.Path("home.dat") != path.Join(path.Dir(p), "home.dat") || f.Path("/etc/nas.dat") != "/etc/nas.dat" || f.Path("~/x") != "~/x" {
+		t.Error("wrong path")
+	}
+
+	// TEST: unknown keys
+	if err := f.Check(map[string]bool{"key-file": true, "backend": true, "cache-size-mb": true, "db-file": true}); err != nil {
+		t.Error(err)
+	}
+	if err := f.Check(map[string]bool{"key-file": true, "backend": true}); err == nil {
+		t.Error("unknown key not found")
+	}
+
+	// TEST: invalid files
+	for _, content := range []string{
+		"repos: {}",                       // no repos
+		"default: xxx\nrepos:\n  a: {}",   // unknown default
+		"defaults: a\nrepos:\n  a: {}",    // unknown top level key
+		"repos:\n  a: [key-file]",         // repo is not a map
+		"repos:\n  a:\n    key-file: x\n", // ok
+	} {
+		p2, cleanup2 := writeConfig(t, content)
+		_, err := Load(p2)
+		cleanup2()
+		if (err == nil) != (content == "repos:\n  a:\n    key-file: x\n") {
+			t.Errorf("wrong result for '%s': %v", content, err)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	p, cleanup := writeConfig(t, testConfig)
+	defer cleanup()
+	dir := path.Dir(p)
+
+	// TEST: without config
+	cli, err := parse("upload")
+	if err != nil || cli.Upload.KeyFile == path.Join(dir, "home.dat") {
+		t.Errorf("wrong key file: %s, %v", cli.Upload.KeyFile, err)
+	}
+	if _, err := parse("upload", "--repo", "home"); err == nil {
+		t.Error("--repo without --config")
+	}
+
+	// TEST: default repo
+	cli, err = parse("upload", "--config", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli.Upload.KeyFile != path.Join(dir, "home.dat") || len(cli.Upload.Backend) != 2 || cli.Upload.Backend[1] != "local" {
+		t.Errorf("wrong values: %v", cli.Upload)
+	}
+	cli, err = parse("webdav", "--config", p)
+	if err != nil || cli.Webdav.CacheSizeMB != 100 {
+		t.Errorf("wrong values: %v, %v", cli.Webdav, err)
+	}
+
+	// TEST: CLI flags override config values
+	cli, err = parse("upload", "--config", p, "-k", "/tmp/cli.dat")
+	if err != nil || cli.Upload.KeyFile != "/tmp/cli.dat" {
+		t.Errorf("wrong key file: %s, %v", cli.Upload.KeyFile, err)
+	}
+
+	// TEST: named repo (and defaults for missing keys)
+	cli, err = parse("upload", "--config", p, "--repo", "nas")
+	if err != nil || cli.Upload.KeyFile != "/etc/nas.dat" || len(cli.Upload.Backend) != 1 {
+		t.Errorf("wrong values: %v, %v", cli.Upload, err)
+	}
+	if _, err := parse("upload", "--config", p, "--repo", "xxx"); err == nil {
+		t.Error("unknown repo")
+	}
+
+	// TEST: unknown keys and invalid values
+	p2, cleanup2 := writeConfig(t, "repos:\n  a:\n    key-file: x\n    folder-id: y\n")
+	defer cleanup2()
+	if _, err := parse("upload", "--config", p2); err == nil {
+		t.Error("unknown key")
+	}
+	p3, cleanup3 := writeConfig(t, "repos:\n  a:\n    backend: [ftp]\n")
+	defer cleanup3()
+	if _, err := parse("upload", "--config", p3); err == nil {
+		t.Error("invalid value")
+	}
+}
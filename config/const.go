@@ -0,0 +1,10 @@
+package config
+
+// packageName is used for debug and error messages
+const packageName = "config"
+
+// ConfigFlag is the name of the flag with the path to the config file.
+const ConfigFlag = "config"
+
+// RepoFlag is the name of the flag with the selected repository.
+const RepoFlag = "repo"
@@ -0,0 +1,5 @@
+/*
+Package config loads the config file with named repositories and provides the values as flag defaults (kong resolver).
+
+*/
+package config
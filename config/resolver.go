@@ -0,0 +1,95 @@
+package config
+
+import (
+	"github.com/alecthomas/kong"
+)
+
+var _ kong.Resolver = (*_Resolver)(nil)
+
+// _Resolver provides the values of the selected repository as flag values.
+type _Resolver struct {
+	loaded bool
+	file   *File
+	repo   Repo
+	err    error
+}
+
+// NewResolver returns a kong resolver for the config file (flag --config) and the repository (flag --repo).
+// Flags on the command line override the config values and the config values override the flag defaults.
+// Without --config, the resolver does nothing.
+func NewResolver() kong.Resolver {
+	return new(_Resolver)
+}
+
+// Resolve returns the config value of the flag or nil.
+func (r *_Resolver) Resolve(ctx *kong.Context, _ *kong.Path, flag *kong.Flag) (interface{}, error) {
+	// errors are returned by Validate (without the flag name)
+	r.load(ctx)
+	if r.repo == nil {
+		return nil, nil
+	}
+
+	v, ok := r.repo[flag.Name]
+	if !ok || flag.Name == ConfigFlag || flag.Name == RepoFlag {
+		return nil, nil
+	}
+
+	// relative paths
+	if s, ok := v.(string); ok && flag.Tag.Type == "path" {
+		v = r.file.Path(s)
+	}
+	return v, nil
+}
+
+// Validate returns the load error or checks all repositories for unknown keys (all flags of all commands are known).
+func (r *_Resolver) Validate(app *kong.Application) error {
+	if r.err != nil || r.file == nil {
+		return r.err
+	}
+	names := make(map[string]bool)
+	flagNames(app.Node, names)
+	return r.file.Check(names)
+}
+
+// load reads the config file once (the flags --config and --repo are read from the context).
+func (r *_Resolver) load(ctx *kong.Context) {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+
+	// flags
+	path, name := "", ""
+	for _, flag := range ctx.Flags() {
+		switch flag.Name {
+		case ConfigFlag:
+			path, _ = ctx.FlagValue(flag).(string)
+		case RepoFlag:
+			name, _ = ctx.FlagValue(flag).(string)
+		}
+	}
+
+	// no config file
+	if path == "" {
+		if name != "" {
+			r.err = errNoConfig
+		}
+		return
+	}
+
+	// load
+	r.file, r.err = Load(path)
+	if r.err == nil {
+		r.repo, r.err = r.file.Repo(name)
+	}
+}
+
+// flagNames adds the names of all flags of the node and all sub commands.
+func flagNames(node *kong.Node, names map[string]bool) {
+	for _, flag := range node.Flags {
+		names[flag.Name] = true
+	}
+	for _, child := range node.Children {
+		flagNames(child, names)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"sort"
+	"sync"
+)
+
+var _ interf.ReaderAt = (*_ChunkReaderAt)(nil)
+
+// _ChunkReaderAt combines the parts of a file with variable sizes (content-defined chunking, @see db.ChunkOptions).
+// impl.NewMultiReaderAt needs parts of the same size (except the last part).
+type _ChunkReaderAt struct {
+	readers []interf.ReaderAt
+	offsets []int64 // plain offset of each part (ascending)
+	mux     *sync.RWMutex
+}
+
+// newChunkReaderAt returns a ReaderAt for a series of files with any sizes (at least two files).
+// The files are read with the service (e.g. a CryptRService).
+func newChunkReaderAt(files []interf.File, service interf.ReaderService, cache interf.Cache, debugLvl uint8) (interf.ReaderAt, error) {
+	if len(files) <= 1 || service == nil {
+		return nil, errors.New("can't create new ChunkReaderAt with len(files)<=1 or service=nil")
+	}
+
+	readers := make([]interf.ReaderAt, 0, len(files))
+	offsets := make([]int64, 0, len(files))
+	off := int64(0)
+	for _, f := range files {
+		if f.Size() <= 0 {
+			closeAll(readers)
+			return nil, errors.New("ChunkReaderAt can't combine empty files")
+		}
+		r, err := impl.NewReaderAt(f, service, cache, debugLvl)
+		if err != nil {
+			closeAll(readers)
+			return nil, err
+		}
+		readers = append(readers, r)
+		offsets = append(offsets, off)
+		off += f.Size()
+	}
+
+	return &_ChunkReaderAt{
+		readers: readers,
+		offsets: offsets,
+		mux:     new(sync.RWMutex),
+	}, nil
+}
+
+//--------------------------------------------------------------------------------------------------------------------//
+
+func (r *_ChunkReaderAt) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	closeAll(r.readers)
+	return nil
+}
+
+func (r *_ChunkReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	// first part: the last part with a start offset <= off
+	partNo := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i] > off }) - 1
+
+	read := 0
+	var err error
+	for partNo < len(r.readers) && read < len(p) {
+		var n int
+		n, err = r.readers[partNo].ReadAt(p[read:], off+int64(read)-r.offsets[partNo])
+		read += n
+		if err != nil && err != io.EOF {
+			return read, err // serious error
+		}
+		if n == 0 {
+			break // no data
+		}
+		partNo++
+	}
+
+	if read == len(p) {
+		return read, nil // buffer is full (ignore EOF)
+	}
+	return read, io.EOF
+}
+
+func (r *_ChunkReaderAt) Stat() map[string]uint64 {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	ret := make(map[string]uint64)
+	for i, inner := range r.readers {
+		for k, v := range inner.Stat() {
+			if v > 0 {
+				ret[fmt.Sprintf("[%d] %s", i, k)] = v
+			}
+		}
+	}
+	return ret
+}
+
+// closeAll closes all readers.
+func closeAll(readers []interf.ReaderAt) {
+	for _, inner := range readers {
+		_ = inner.Close()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/SchnorcherSepp/splitfs/db"
+	"github.com/SchnorcherSepp/splitfs/progress"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"log"
@@ -11,12 +12,17 @@ import (
 )
 
 // Clean removes no longer referenced data from the storage.
+// Parts and bundles of all snapshots and shares are also referenced (@see Snapshots and Shares), so the indexKey
+// is required to read them. Clean fails if a snapshot or share can't be read (nothing is deleted).
 // If the database does not contain any bundles, all bundles are ignored in storage (BundleMode=off).
 // If the try flag is true, no data is deleted.
-func Clean(vDB db.Db, service interf.Service, try bool, debugLvl uint8) error {
-	// debug (0=off, 1=debug, 2=high)
-	debug := debugLvl >= impl.DebugLow
+func Clean(vDB db.Db, indexKey []byte, service interf.Service, try bool, debugLvl uint8) error {
+	return CleanWithProgress(vDB, indexKey, service, try, nil, debugLvl)
+}
 
+// CleanWithProgress removes no longer referenced data like Clean and reports each removed file to rep (optional).
+// In try mode, the files are reported as skipped.
+func CleanWithProgress(vDB db.Db, indexKey []byte, service interf.Service, try bool, rep *progress.Reporter, debugLvl uint8) error {
 	// nil check
 	if service == nil {
 		return errors.New("service is nil")
@@ -27,8 +33,49 @@ func Clean(vDB db.Db, service interf.Service, try bool, debugLvl uint8) error {
 		return err
 	}
 
+	// all snapshots
+	dbs, err := snapshotDbs(service, indexKey)
+	if err != nil {
+		return err
+	}
+	if len(dbs) > 0 {
+		log.Printf("INFO: %s/Clean: %d snapshots found", packageName, len(dbs))
+	}
+
+	// all shares
+	shares, err := shareDbs(service, indexKey)
+	if err != nil {
+		return err
+	}
+	if len(shares) > 0 {
+		log.Printf("INFO: %s/Clean: %d shares found", packageName, len(shares))
+	}
+	dbs = append([]db.Db{vDB}, append(dbs, shares...)...)
+
+	return clean(dbs, service, try, rep, debugLvl)
+}
+
+// clean removes all data that is not referenced by one of the databases.
+// The service file list must be up to date (@see interf.Service.Update).
+func clean(dbs []db.Db, service interf.Service, try bool, rep *progress.Reporter, debugLvl uint8) error {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	// replicated service: clean each replica separately
+	if r, ok := service.(replicated); ok {
+		for _, rs := range r.Replicas() {
+			if err := rs.Update(); err != nil {
+				return err
+			}
+			if err := clean(dbs, rs, try, rep, debugLvl); err != nil {
+				return err
+			}
+		}
+		return service.Update()
+	}
+
 	// get all files
-	unknownParts, unknownBundles, _ := unknown(vDB, service, debug)
+	unknownParts, unknownBundles, _ := unknown(dbs, service, debug)
 	duplicates := duplicates(service, debug)
 
 	// build remove list
@@ -37,7 +84,10 @@ func Clean(vDB db.Db, service interf.Service, try bool, debugLvl uint8) error {
 	removeList = append(removeList, unknownParts...)
 	removeList = append(removeList, duplicates...)
 
-	bundleMode := len(vDB.Bundles) > 0
+	bundleMode := false
+	for _, vDB := range dbs {
+		bundleMode = bundleMode || len(vDB.Bundles) > 0
+	}
 	if bundleMode {
 		log.Printf("INFO: %s/Clean: bundle mode on", packageName)
 		removeList = append(removeList, unknownBundles...)
@@ -48,6 +98,16 @@ func Clean(vDB db.Db, service interf.Service, try bool, debugLvl uint8) error {
 		log.Printf("INFO: %s/Clean: try mode on: nothing is deleted", packageName)
 	}
 
+	// progress (one stage per service)
+	if rep != nil {
+		total := int64(0)
+		for _, f := range removeList {
+			total += f.Size()
+		}
+		rep.Start(progress.StageClean, int64(len(removeList)), total)
+		defer rep.Finish()
+	}
+
 	// REMOVE
 	for i, f := range removeList {
 		// log
@@ -55,25 +115,34 @@ func Clean(vDB db.Db, service interf.Service, try bool, debugLvl uint8) error {
 			log.Printf("DEBUG: %s/Clean: remove [%d/%d] '%s': id=%s, size=%d", packageName, i+1, len(removeList), f.Name(), f.Id(), f.Size())
 		}
 		// remove
-		if !try {
-			if err := service.Trash(f); err != nil {
-				return err
-			}
+		if try {
+			rep.Item(progress.Skipped, f.Name(), f.Size())
+			continue
+		}
+		if err := service.Trash(f); err != nil {
+			rep.Item(progress.Failed, f.Name(), f.Size())
+			return err
 		}
+		rep.Item(progress.Removed, f.Name(), f.Size())
 	}
 
 	// success
 	return nil
 }
 
-// unknown returns all online files that are not in the database
-func unknown(vDB db.Db, service interf.Service, debug bool) (unknownParts, unknownBundles, unknownRest []interf.File) {
+// replicated is implemented by services that store all files on multiple services (e.g. replica.Service).
+type replicated interface {
+	Replicas() []interf.Service
+}
+
+// unknown returns all online files that are not in one of the databases
+func unknown(dbs []db.Db, service interf.Service, debug bool) (unknownParts, unknownBundles, unknownRest []interf.File) {
 	unknownParts = make([]interf.File, 0)
 	unknownBundles = make([]interf.File, 0)
 	unknownRest = make([]interf.File, 0)
 
 	// get lists
-	dbParts := allDbParts(vDB)
+	dbParts := allDbParts(dbs...)
 	onlineParts, onlineBundles, onlineRest := allOnlineParts(service)
 
 	// 1) onlineParts
@@ -144,25 +213,27 @@ func unknown(vDB db.Db, service interf.Service, debug bool) (unknownParts, unkno
 	return
 }
 
-// allDbParts extracts all parts from db.
-func allDbParts(vDB db.Db) []db.VFilePart {
+// allDbParts extracts all parts from the databases.
+func allDbParts(dbs ...db.Db) []db.VFilePart {
 	var allParts = make(map[string]db.VFilePart)
 
-	// get all file parts
-	for _, file := range vDB.VFiles {
-		for _, part := range file.Parts {
+	for _, vDB := range dbs {
+		// get all file parts
+		for _, file := range vDB.VFiles {
+			for _, part := range file.Parts {
+				key := part.StorageName + "|" + part.StorageMd5
+				allParts[key] = part
+			}
+		}
+
+		// get all bundle parts
+		for _, bundle := range vDB.Bundles {
+			var part = bundle.VFilePart
 			key := part.StorageName + "|" + part.StorageMd5
 			allParts[key] = part
 		}
 	}
 
-	// get all bundle parts
-	for _, bundle := range vDB.Bundles {
-		var part = bundle.VFilePart
-		key := part.StorageName + "|" + part.StorageMd5
-		allParts[key] = part
-	}
-
 	// return list
 	list := make([]db.VFilePart, 0, len(allParts))
 	for _, p := range allParts {
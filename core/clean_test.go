@@ -19,13 +19,13 @@ func TestClean_all(t *testing.T) {
 	_ = service.Update()
 
 	// TEST: service == nil
-	err := core.Clean(db.Db{}, nil, false, impl.DebugOff)
+	err := core.Clean(db.Db{}, nil, nil, false, impl.DebugOff)
 	if fmt.Sprintf("%v", err) != "service is nil" {
 		t.Error("no error")
 	}
 
 	// TEST: try
-	err = core.Clean(db.Db{}, service, true, impl.DebugOff)
+	err = core.Clean(db.Db{}, nil, service, true, impl.DebugOff)
 	if err != nil {
 		t.Error(err)
 	}
@@ -39,7 +39,7 @@ func TestClean_all(t *testing.T) {
 	//   * Only 'part' is deleted here.
 	//   * 'bundle' is not deleted because the database does not contain any bundles (=> the function 'bundle' is deactivated)
 	//   * 'rest' is never deleted.
-	err = core.Clean(db.Db{}, service, false, impl.DebugOff)
+	err = core.Clean(db.Db{}, nil, service, false, impl.DebugOff)
 	if err != nil {
 		t.Error(err)
 	}
@@ -50,7 +50,7 @@ func TestClean_all(t *testing.T) {
 
 	// TEST: bundle-mode-on (TRY)
 	vDb := db.Db{Bundles: map[string]db.Bundle{"bundle1": {}}}
-	err = core.Clean(vDb, service, true, impl.DebugOff)
+	err = core.Clean(vDb, nil, service, true, impl.DebugOff)
 	if err != nil {
 		t.Error(err)
 	}
@@ -60,7 +60,7 @@ func TestClean_all(t *testing.T) {
 	}
 
 	// TEST: bundle-mode-on (DO)
-	err = core.Clean(vDb, service, false, impl.DebugOff)
+	err = core.Clean(vDb, nil, service, false, impl.DebugOff)
 	if err != nil {
 		t.Error(err)
 	}
@@ -97,7 +97,7 @@ func TestClean_attribute_duplicate(t *testing.T) {
 	}
 
 	// clear duplicates (valid files in db)
-	err := core.Clean(vDb, service, false, impl.DebugHigh)
+	err := core.Clean(vDb, nil, service, false, impl.DebugHigh)
 	if err != nil {
 		t.Error(err)
 	}
@@ -111,7 +111,7 @@ func TestClean_attribute_duplicate(t *testing.T) {
 	tmp := vDb.Bundles["bub"]
 	tmp.StorageSize = 33
 	vDb.Bundles["bub"] = tmp
-	err = core.Clean(vDb, service, false, impl.DebugHigh)
+	err = core.Clean(vDb, nil, service, false, impl.DebugHigh)
 	if err != nil {
 		t.Error(err)
 	}
@@ -1,7 +1,21 @@
 package core
 
+import "time"
+
 // packageName is used for debug and error messages.
 const packageName = "core"
 
 // IndexName is the storageName of the db.
 const IndexName = "index.db2"
+
+// SnapshotPrefix is the prefix of the snapshot storageNames (@see SnapshotName).
+const SnapshotPrefix = "snapshot-"
+
+// ShareRefPrefix is the prefix of the reference copies of the shares (@see UploadShare).
+const ShareRefPrefix = "shareref-"
+
+// SnapshotTimeFormat is the time format (UTC) of the snapshot names.
+const SnapshotTimeFormat = "20060102T150405Z"
+
+// quotaWindow is the time window of the daily upload cap (rolling 24 hours, @see Quota).
+const quotaWindow = 24 * time.Hour
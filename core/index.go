@@ -0,0 +1,85 @@
+package core
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"log"
+	"os"
+	"sort"
+)
+
+// IndexFile returns the storage file of the database (@see IndexName).
+// If there are several files (e.g. an interrupted upload), the newest file is returned (@see IndexFiles).
+// The check is based on the service file list (offline).
+func IndexFile(service interf.Service) (interf.File, error) {
+	list := IndexFiles(service, IndexName)
+	if len(list) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return list[0], nil
+}
+
+// IndexFiles returns all storage files with the name (e.g. IndexName), newest first.
+// The order is deterministic: by ModTime and then by Id. The Id does not reflect the upload order,
+// so after each successful upload all other copies are removed (@see uploadIndex).
+// The check is based on the service file list (offline).
+func IndexFiles(service interf.Service, name string) []interf.File {
+	list := make([]interf.File, 0, 1)
+	for _, f := range service.Files().All() {
+		if f.Name() == name {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ModTime() != list[j].ModTime() {
+			return list[i].ModTime() > list[j].ModTime()
+		}
+		return list[i].Id() > list[j].Id()
+	})
+	return list
+}
+
+// ReadDb downloads and decrypts the database from the given storage file.
+func ReadDb(f interf.File, service interf.Service, dbKey []byte) (db.Db, error) {
+	// open reader to db file
+	r, err := service.Reader(f, 0)
+	if err != nil {
+		return db.NewDb(), err
+	}
+	defer r.Close() // CLOSE
+
+	// read db
+	return db.FromReader(r, dbKey)
+}
+
+// LoadDb downloads and decrypts the database from the storage (@see LoadIndex).
+// The service file list must be up to date (@see interf.Service.Update).
+func LoadDb(service interf.Service, dbKey []byte) (db.Db, error) {
+	vDb, _, err := LoadIndex(service, IndexName, dbKey)
+	return vDb, err
+}
+
+// LoadIndex downloads and decrypts the newest valid database with the name (e.g. IndexName or a share index).
+// Invalid files (e.g. broken uploads or other keys) are skipped and the next older file is used.
+// The storage file of the database is also returned (e.g. to detect changes). If no file is valid, the
+// error of the newest file is returned.
+// The service file list must be up to date (@see interf.Service.Update).
+func LoadIndex(service interf.Service, name string, dbKey []byte) (db.Db, interf.File, error) {
+	list := IndexFiles(service, name)
+	if len(list) == 0 {
+		return db.NewDb(), nil, os.ErrNotExist
+	}
+
+	var firstErr error
+	for _, f := range list {
+		vDb, err := ReadDb(f, service, dbKey)
+		if err == nil {
+			return vDb, f, nil
+		}
+		log.Printf("WARNING: %s/LoadIndex: skip '%s' (%s): %v", packageName, name, f.Id(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return db.NewDb(), nil, firstErr
+}
@@ -0,0 +1,160 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// modTimeService returns the files with other modification times (key: file id).
+type modTimeService struct {
+	interf.Service
+	modTime map[string]int64
+}
+
+func (s *modTimeService) Files() interf.Files {
+	byId := make(map[string]interf.File)
+	for _, f := range s.Service.Files().All() {
+		byId[f.Id()] = impl.NewFile(f.Id(), f.Name(), s.modTime[f.Id()], f.Size(), f.Md5())
+	}
+	return impl.NewFiles(byId)
+}
+
+// corruptService saves all files without the last byte.
+type corruptService struct {
+	interf.Service
+}
+
+func (s *corruptService) Save(name string, r io.Reader, max int64) (interf.File, error) {
+	b, _ := ioutil.ReadAll(r)
+	return s.Service.Save(name, bytes.NewReader(b[:len(b)-1]), max)
+}
+
+// raceService saves a second (broken) copy with each index upload (simulates a concurrent upload of another client).
+type raceService struct {
+	interf.Service
+}
+
+func (s *raceService) Save(name string, r io.Reader, max int64) (interf.File, error) {
+	if name == core.IndexName {
+		_, _ = s.Service.Save(name, bytes.NewReader(make([]byte, 100)), max)
+	}
+	return s.Service.Save(name, r, max)
+}
+
+// saveIndex saves the db as index file and returns the file id.
+func saveIndex(t *testing.T, service interf.Service, vDb db.Db, key []byte) string {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := db.ToWriter(vDb, key, buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := service.Save(core.IndexName, buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Id()
+}
+
+func TestLoadIndex(t *testing.T) {
+	ram := impl.NewRamService(nil, impl.DebugOff)
+	service := &modTimeService{Service: ram, modTime: make(map[string]int64)}
+	key := testUploadKeyFile.IndexKey()
+
+	// TEST: no index
+	if _, _, err := core.LoadIndex(service, core.IndexName, key); err != os.ErrNotExist {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// three indexes: old, new and broken (newest)
+	oldDb := db.NewDb()
+	oldDb.VFiles["old"] = db.VirtFile{RelPath: "old"}
+	newDb := db.NewDb()
+	newDb.VFiles["new"] = db.VirtFile{RelPath: "new"}
+	service.modTime[saveIndex(t, ram, oldDb, key)] = 100
+	newId := saveIndex(t, ram, newDb, key)
+	service.modTime[newId] = 200
+	f, _ := ram.Save(core.IndexName, bytes.NewReader(make([]byte, 100)), 0)
+	service.modTime[f.Id()] = 300
+	_ = ram.Update()
+
+	// TEST: order (newest first)
+	list := core.IndexFiles(service, core.IndexName)
+	if len(list) != 3 || list[0].ModTime() != 300 || list[1].ModTime() != 200 || list[2].ModTime() != 100 {
+		t.Fatalf("wrong order: %v", list)
+	}
+	if f, err := core.IndexFile(service); err != nil || f.ModTime() != 300 {
+		t.Errorf("wrong index file: %v, %v", f, err)
+	}
+
+	// TEST: the newest valid index is loaded
+	vDb, f, err := core.LoadIndex(service, core.IndexName, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vDb.VFiles["new"]; !ok || f.Id() != newId {
+		t.Errorf("wrong db: %v", vDb.VFiles)
+	}
+
+	// TEST: same modification time (deterministic)
+	for id := range service.modTime {
+		service.modTime[id] = 400
+	}
+	first := core.IndexFiles(service, core.IndexName)[0].Id()
+	for i := 0; i < 10; i++ {
+		if id := core.IndexFiles(service, core.IndexName)[0].Id(); id != first {
+			t.Fatalf("not deterministic: %s != %s", id, first)
+		}
+	}
+
+	// TEST: wrong key
+	if _, _, err := core.LoadIndex(service, core.IndexName, make([]byte, 32)); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestUploadIndex_replace(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+	oldIndex, _ := core.IndexFile(service)
+
+	// TEST: broken upload (the old index is kept)
+	if err := core.Upload(rootPath, vDb, key, &corruptService{Service: service}, impl.DebugOff); err == nil {
+		t.Fatal("no error")
+	}
+	_ = service.Update()
+	if list := core.IndexFiles(service, core.IndexName); len(list) != 1 || list[0].Id() != oldIndex.Id() {
+		t.Fatalf("wrong index list: %v", list)
+	}
+	if _, err := core.LoadDb(service, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: upload replaces the old index
+	if err := core.Upload(rootPath, vDb, key, service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if list := core.IndexFiles(service, core.IndexName); len(list) != 1 || list[0].Id() == oldIndex.Id() {
+		t.Fatalf("wrong index list: %v", list)
+	}
+
+	// TEST: upload removes copies that are not in the file list before the upload
+	if err := core.Upload(rootPath, vDb, key, &raceService{Service: service}, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	list := core.IndexFiles(service, core.IndexName)
+	if len(list) != 1 {
+		t.Fatalf("wrong index list: %v", list)
+	}
+	if _, err := core.ReadDb(list[0], service, key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Journal is the progress file of an upload (@see UploadOptions.Journal).
+// Each started and each finished part or bundle is appended as a line ('S <key>' or 'D <key>'),
+// so an interrupted upload can be resumed without existence checks for the finished parts.
+// Parts that were started but not finished are checked on storage and partial files are removed (@see cleanPartial).
+// An upload that was stopped by the quota is marked as paused ('P'), it is continued by the next run.
+type Journal struct {
+	path    string
+	mux     *sync.Mutex
+	fh      *os.File
+	done    map[string]bool // finished parts (key: @see partKey)
+	started map[string]bool // started parts (not finished yet)
+	resumed int             // finished parts from the previous runs
+	paused  bool            // last run stopped by the quota
+}
+
+// JournalExists returns true, if the journal of an unfinished upload exists.
+func JournalExists(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && !st.IsDir()
+}
+
+// JournalPaused returns true, if the last run of the journal was stopped by the quota (@see Journal.Pause).
+// A paused upload can be continued without --resume, there are no partial files.
+func JournalPaused(path string) bool {
+	j := &Journal{
+		done:    make(map[string]bool),
+		started: make(map[string]bool),
+	}
+	return j.read(path) == nil && j.paused
+}
+
+// OpenJournal opens the journal and reads the progress of the previous runs.
+// If restart is true or the journal does not exist, a new journal is created.
+func OpenJournal(path string, restart bool) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		mux:     new(sync.Mutex),
+		done:    make(map[string]bool),
+		started: make(map[string]bool),
+	}
+
+	// read old journal
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if restart {
+		flag |= os.O_TRUNC
+	} else if err := j.read(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	j.resumed = len(j.done)
+
+	// open for append
+	fh, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.fh = fh
+	return j, nil
+}
+
+// Resumed returns the number of finished parts and bundles from the previous runs.
+func (j *Journal) Resumed() int {
+	return j.resumed
+}
+
+// Close closes the journal file (the journal is kept for the next run).
+func (j *Journal) Close() error {
+	return j.fh.Close()
+}
+
+// Pause marks the upload as paused (quota reached) and closes the journal file.
+func (j *Journal) Pause() error {
+	j.mux.Lock()
+	_, err := fmt.Fprintf(j.fh, "P quota\n")
+	j.mux.Unlock()
+	if err != nil {
+		_ = j.fh.Close()
+		return err
+	}
+	return j.fh.Close()
+}
+
+// Remove closes and deletes the journal (upload finished).
+func (j *Journal) Remove() error {
+	_ = j.fh.Close()
+	return os.Remove(j.path)
+}
+
+// isDone returns true, if the part was uploaded by a previous or the current run.
+func (j *Journal) isDone(key string) bool {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.done[key]
+}
+
+// start writes the start of an upload to the journal.
+func (j *Journal) start(key string) error {
+	return j.write('S', key)
+}
+
+// finish writes a finished upload to the journal.
+func (j *Journal) finish(key string) error {
+	return j.write('D', key)
+}
+
+// read reads the progress of the previous runs.
+func (j *Journal) read(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	s := bufio.NewScanner(fh)
+	for s.Scan() {
+		line := s.Text()
+		if len(line) < 3 || line[1] != ' ' {
+			continue // broken line (e.g. crash while writing)
+		}
+		switch key := line[2:]; line[0] {
+		case 'S':
+			j.started[key] = true
+			j.paused = false
+		case 'D':
+			j.done[key] = true
+			delete(j.started, key)
+			j.paused = false
+		case 'P':
+			j.paused = true
+		}
+	}
+	return s.Err()
+}
+
+// write appends a line to the journal and syncs the file.
+func (j *Journal) write(kind byte, key string) error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if _, err := fmt.Fprintf(j.fh, "%c %s\n", kind, key); err != nil {
+		return err
+	}
+	if kind == 'D' {
+		j.done[key] = true
+		delete(j.started, key)
+	} else {
+		j.started[key] = true
+	}
+	return j.fh.Sync()
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// partKey returns the journal key of a part or bundle.
+func partKey(part db.VFilePart) string {
+	return fmt.Sprintf("%s|%d|%s", part.StorageName, part.StorageSize, part.StorageMd5)
+}
+
+// cleanPartial checks the parts that were started but not finished by a previous run.
+// Complete storage files are marked as finished, partial files (wrong size or md5) are removed.
+func cleanPartial(j *Journal, service interf.Service, debug bool) error {
+	j.mux.Lock()
+	started := make([]string, 0, len(j.started))
+	for key := range j.started {
+		started = append(started, key)
+	}
+	j.mux.Unlock()
+	if len(started) == 0 {
+		return nil
+	}
+
+	// storage files by name
+	byName := make(map[string][]interf.File)
+	for _, f := range service.Files().All() {
+		byName[f.Name()] = append(byName[f.Name()], f)
+	}
+
+	for _, key := range started {
+		parts := strings.SplitN(key, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		complete := false
+		for _, f := range byName[parts[0]] {
+			if fmt.Sprintf("%d", f.Size()) == parts[1] && (parts[2] == "" || f.Md5() == parts[2]) {
+				complete = true
+				continue
+			}
+			// partial upload
+			if debug {
+				log.Printf("DEBUG: %s/cleanPartial: remove partial file '%s' (%d bytes)", packageName, f.Name(), f.Size())
+			}
+			if err := service.Trash(f); err != nil {
+				log.Printf("ERROR: %s/cleanPartial: '%s': %v", packageName, f.Name(), err)
+				return err
+			}
+		}
+		if complete {
+			if err := j.finish(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package core_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/local"
+	"github.com/SchnorcherSepp/splitfs/core"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+	journalPath := path.Join(rootPath, "upload.journal")
+	total := len(service.Files().All()) - 1 // without index
+
+	// each run uses a new service instance (empty file list, like a new process)
+	storageDir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+	newService := func(dir string) interf.Service {
+		s, err := local.NewLocalService(path.Join(storageDir, dir), nil, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// TEST: interrupted upload
+	j, err := core.OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.UploadWithOptions(rootPath, vDb, key, &failService{Service: newService("a"), n: 2}, core.UploadOptions{Journal: j}, impl.DebugOff); err == nil {
+		t.Fatal("no error")
+	}
+	_ = j.Close()
+	if !core.JournalExists(journalPath) {
+		t.Fatal("no journal")
+	}
+
+	// TEST: resume (the finished parts are not uploaded again)
+	j, err = core.OpenJournal(journalPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Resumed() != 2 {
+		t.Errorf("wrong resumed count: %d", j.Resumed())
+	}
+	cs := &countService{Service: newService("a"), saved: make(map[string]int)}
+	if err := core.UploadWithOptions(rootPath, vDb, key, cs, core.UploadOptions{Journal: j}, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.saved) != total-2+1 || cs.saved[core.IndexName] != 1 {
+		t.Errorf("wrong uploads: %v", cs.saved)
+	}
+	if err := j.Remove(); err != nil || core.JournalExists(journalPath) {
+		t.Errorf("journal not removed: %v", err)
+	}
+
+	// TEST: restart (the journal is discarded)
+	_ = ioutil.WriteFile(journalPath, []byte("D x|1|y\n"), 0600)
+	if j, _ = core.OpenJournal(journalPath, true); j.Resumed() != 0 {
+		t.Errorf("wrong resumed count: %d", j.Resumed())
+	}
+	_ = j.Remove()
+
+	// TEST: partial file of a started upload
+	part := vDb.VFiles["sub/deeper/c.dat"].Parts[0]
+	if _, err := newService("b").Save(part.StorageName, bytes.NewReader([]byte("partial")), 0); err != nil {
+		t.Fatal(err)
+	}
+	resumed := newService("b")
+	line := fmt.Sprintf("S %s|%d|%s\nD broken", part.StorageName, part.StorageSize, part.StorageMd5)
+	_ = ioutil.WriteFile(journalPath, []byte(line), 0600)
+	if j, err = core.OpenJournal(journalPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.UploadWithOptions(rootPath, vDb, key, resumed, core.UploadOptions{Journal: j}, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = j.Remove()
+	_ = resumed.Update()
+	n := 0
+	for _, f := range resumed.Files().All() {
+		if f.Name() == part.StorageName {
+			n++
+			if f.Md5() != part.StorageMd5 || f.Size() != part.StorageSize {
+				t.Errorf("partial file not replaced: %d", f.Size())
+			}
+		}
+	}
+	if n != 1 {
+		t.Errorf("wrong file count: %d", n)
+	}
+
+	// TEST: paused by the quota (a new run is not paused)
+	if j, err = core.OpenJournal(journalPath, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Pause(); err != nil || !core.JournalPaused(journalPath) {
+		t.Errorf("journal not paused: %v", err)
+	}
+	_ = ioutil.WriteFile(journalPath, []byte("P quota\nS x|1|y\n"), 0600)
+	if core.JournalPaused(journalPath) {
+		t.Errorf("journal paused")
+	}
+	_ = os.Remove(journalPath)
+}
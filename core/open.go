@@ -114,12 +114,17 @@ func Open(file db.VirtFile, vDb db.Db, service interf.Service, debugLvl uint8) (
 
 	// CASE 3 (default): multi part file
 	// -> NewMultiReaderAt
+	// -> newChunkReaderAt (parts with variable sizes)
 	//-----------------------------------------------------------
 
 	// get all parts as storage file
 	files := make([]interf.File, 0, len(file.Parts))
 	keys := make(map[string][]byte)
-	for _, part := range file.Parts {
+	chunked := false
+	for i, part := range file.Parts {
+		if size := file.Parts[0].StorageSize; i < len(file.Parts)-1 && part.StorageSize != size || part.StorageSize > size {
+			chunked = true // only the last part can be smaller
+		}
 		sf, err := service.Files().ByAttr(part.StorageName, part.StorageSize, part.StorageMd5)
 		if err != nil {
 			return nil, err // ERROR
@@ -131,6 +136,14 @@ func Open(file db.VirtFile, vDb db.Db, service interf.Service, debugLvl uint8) (
 	// cryptService
 	cryptService := newCryptRService(service, keys)
 
+	// content-defined chunking
+	if chunked {
+		if debug {
+			log.Printf("DEBUG: %s/Open: ChunkReaderAt for '%s' with %d bytes and %d parts", packageName, file.Name(), file.FileSize, len(file.Parts))
+		}
+		return newChunkReaderAt(files, cryptService, service.Cache(), debugLvl) // --> EXIT CASE 3
+	}
+
 	// return (default)
 	if debug {
 		log.Printf("DEBUG: %s/Open: MultiReaderAt for '%s' with %d bytes and %d parts", packageName, file.Name(), file.FileSize, len(file.Parts))
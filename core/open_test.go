@@ -8,7 +8,9 @@ import (
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path"
 	"reflect"
@@ -314,6 +316,77 @@ func TestOpen_ReadTest(t *testing.T) {
 	}
 }
 
+// CASE 3: multi part file with variable part sizes (content-defined chunking)
+func TestOpen_chunked(t *testing.T) {
+	// test folder: random data and data with repeated blocks
+	rootPath, _ := ioutil.TempDir("", "chunkTest")
+	defer os.RemoveAll(rootPath)
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(42)).Read(data)
+	_ = ioutil.WriteFile(path.Join(rootPath, "random.dat"), data, 0600)
+	_ = ioutil.WriteFile(path.Join(rootPath, "repeated.dat"), bytes.Repeat(data[:100000], 20), 0600)
+
+	opts := db.ScanOptions{Chunking: db.ChunkOptions{Min: 4 * 1024, Avg: 16 * 1024, Max: 64 * 1024}}
+	vDb, _, _, err := db.FromScanWithOptions(rootPath, db.NewDb(), opts, impl.DebugOff, testUploadKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := impl.NewRamService(nil, impl.DebugOff)
+	if err := core.Upload(rootPath, vDb, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+
+	chunked := 0
+	for _, v := range vDb.VFiles {
+		if v.IsDir || len(v.Parts) <= 1 {
+			continue
+		}
+		chunked++
+
+		// disk
+		orig, err := ioutil.ReadFile(path.Join(rootPath, v.RelPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// ram
+		stdoutBuf := bytes.NewBuffer(make([]byte, 0))
+		log.SetOutput(stdoutBuf)
+		r, err := core.Open(v, vDb, service, impl.DebugLow)
+		log.SetOutput(os.Stdout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(stdoutBuf.String(), "ChunkReaderAt for ") {
+			t.Errorf("wrong reader: %s", stdoutBuf.String())
+		}
+
+		// reads across the part boundaries and behind the end of the file
+		for _, size := range []int{100, 4000, 33333, 100000} {
+			buf := make([]byte, size)
+			for off := int64(0); off < v.FileSize+int64(size); off += int64(size)*3/4 + 1 {
+				n, err := r.ReadAt(buf, off)
+				want := orig[minInt(int(off), len(orig)):minInt(int(off)+size, len(orig))]
+				if n != len(want) || !bytes.Equal(buf[:n], want) || (n < size) != (err == io.EOF) {
+					t.Fatalf("'%s': off=%d, size=%d, n=%d, err=%v", v.RelPath, off, size, n, err)
+				}
+			}
+		}
+		_ = r.Close()
+	}
+	if chunked != 2 {
+		t.Fatalf("wrong number of chunked files: %d", chunked)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 //--------------------------------------------------------------------------------------------------------------------//
 
 func TestRace_Open(t *testing.T) {
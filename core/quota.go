@@ -0,0 +1,294 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quota caps the uploaded bytes per run and per rolling 24 hours (@see UploadOptions.Quota),
+// e.g. for the daily upload limit of Google Drive (about 750 GB).
+// The uploads are saved in a history file, so the daily cap works over several runs.
+// Parts and bundles that exist on storage are not counted.
+type Quota struct {
+	path    string // history file (optional)
+	mux     *sync.Mutex
+	perRun  int64         // 0=no cap
+	perDay  int64         // 0=no cap
+	run     int64         // uploaded and reserved bytes of this run (@see begin)
+	pending int64         // reserved bytes (upload in progress)
+	history []_QuotaEntry // uploads of the last 24 hours
+	reached bool          // no new uploads (this run)
+	now     func() time.Time
+}
+
+// _QuotaEntry is an upload in the history file.
+type _QuotaEntry struct {
+	time time.Time
+	size int64
+}
+
+// QuotaError is returned by UploadWithOptions, if the quota is reached.
+// The uploaded index contains only the files that are online (Db): changed files keep their previous version and
+// new files are missing. The other files are uploaded by the next run.
+type QuotaError struct {
+	Db      db.Db // uploaded index
+	Missing int   // new files that are not in the index
+	Skipped int   // parts and bundles that are not uploaded
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("upload quota reached: %d parts and bundles are not uploaded (%d files not in the index)", e.Skipped, e.Missing)
+}
+
+// OpenQuota reads the history file (optional, ""=per run cap only) and removes the entries older than quotaWindow.
+// A cap of 0 is no cap. A cap smaller than a part (@see db.PartSize) blocks all larger parts.
+func OpenQuota(path string, perRun, perDay int64) (*Quota, error) {
+	q := &Quota{
+		path:   path,
+		mux:    new(sync.Mutex),
+		perRun: perRun,
+		perDay: perDay,
+		now:    time.Now,
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	// read history
+	fh, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		since := time.Now().Add(-quotaWindow)
+		s := bufio.NewScanner(fh)
+		for s.Scan() {
+			f := strings.Fields(s.Text())
+			if len(f) != 2 {
+				continue // broken line
+			}
+			t, err1 := strconv.ParseInt(f[0], 10, 64)
+			n, err2 := strconv.ParseInt(f[1], 10, 64)
+			if err1 != nil || err2 != nil || time.Unix(t, 0).Before(since) {
+				continue // broken or old line
+			}
+			q.history = append(q.history, _QuotaEntry{time: time.Unix(t, 0), size: n})
+		}
+		err = s.Err()
+		_ = fh.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// write compact history
+	var b strings.Builder
+	for _, e := range q.history {
+		b.WriteString(fmt.Sprintf("%d %d\n", e.time.Unix(), e.size))
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Reached returns true, if a part or bundle of the last run was not uploaded because of the quota.
+func (q *Quota) Reached() bool {
+	if q == nil {
+		return false
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.reached
+}
+
+// Used returns the uploaded bytes of this run and of the last 24 hours (all runs).
+func (q *Quota) Used() (run, day int64) {
+	if q == nil {
+		return 0, 0
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.run - q.pending, q.dayUsed() - q.pending
+}
+
+// SetClock replaces the clock of the daily cap (default: time.Now), e.g. for tests.
+func (q *Quota) SetClock(now func() time.Time) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.now = now
+}
+
+// begin starts a new run: the per run cap and the reached state are reset and old uploads are removed from the history.
+// A quota can be used by several runs (e.g. watch), the daily cap counts all runs.
+func (q *Quota) begin() {
+	if q == nil {
+		return
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.run = 0
+	q.reached = false
+
+	since := q.now().Add(-quotaWindow)
+	history := q.history[:0]
+	for _, e := range q.history {
+		if !e.time.Before(since) {
+			history = append(history, e)
+		}
+	}
+	q.history = history
+}
+
+// reserve returns true, if size bytes can be uploaded (the bytes are reserved).
+// After the first part that exceeds a cap, no more parts are reserved (the upload stops).
+func (q *Quota) reserve(size int64) bool {
+	if q == nil {
+		return true
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.reached ||
+		(q.perRun > 0 && q.run+size > q.perRun) ||
+		(q.perDay > 0 && q.dayUsed()+size > q.perDay) {
+		q.reached = true
+		return false
+	}
+	q.run += size
+	q.pending += size
+	return true
+}
+
+// release returns the reserved bytes of a failed upload.
+func (q *Quota) release(size int64) {
+	if q == nil {
+		return
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.run -= size
+	q.pending -= size
+}
+
+// commit saves the reserved bytes of a finished upload in the history file.
+func (q *Quota) commit(size int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	e := _QuotaEntry{time: q.now(), size: size}
+	q.pending -= size
+	q.history = append(q.history, e)
+	if q.path == "" {
+		return nil
+	}
+	fh, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fh, "%d %d\n", e.time.Unix(), e.size); err != nil {
+		_ = fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// dayUsed returns the bytes of the last 24 hours including the reserved bytes (mutex must be locked).
+func (q *Quota) dayUsed() int64 {
+	since := q.now().Add(-quotaWindow)
+	sum := q.pending
+	for _, e := range q.history {
+		if !e.time.Before(since) {
+			sum += e.size
+		}
+	}
+	return sum
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// onlineDb returns a copy of the db without the new versions of files with parts that are not uploaded (skipped, key: @see partKey).
+// A changed file keeps its previous version from the last uploaded index (oldDb), which is still online. This version is
+// read from its parts (the old bundles are not in the db). Only new files are removed (missing), also from the folder content.
+// Bundles that are not uploaded or that contain a file that is not uploaded are removed and the files are read from
+// their parts (like db.Subtree).
+func onlineDb(vDB, oldDb db.Db, skipped map[string]bool) (newDb db.Db, missing int) {
+	newDb = db.NewDb()
+
+	// files
+	replaced := make(map[string]bool) // new version not uploaded
+	removed := make(map[string]bool)  // not in the new db
+	for k, vFile := range vDB.VFiles {
+		ok := true
+		for _, part := range vFile.Parts {
+			if skipped[partKey(part)] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			newDb.VFiles[k] = vFile
+			continue
+		}
+		replaced[k] = true
+		if oldFile, exists := oldDb.VFiles[k]; exists && !oldFile.IsDir {
+			oldFile.AlsoInBundle = ""
+			newDb.VFiles[k] = oldFile
+			continue
+		}
+		removed[k] = true
+		missing++
+	}
+
+	// folder content
+	for k, vFile := range newDb.VFiles {
+		if !vFile.IsDir {
+			continue
+		}
+		content := make([]db.FolderEl, 0, len(vFile.FolderContent))
+		for _, el := range vFile.FolderContent {
+			if !removed[path.Join(k, el.RelPath)] {
+				content = append(content, el)
+			}
+		}
+		vFile.FolderContent = content
+		newDb.VFiles[k] = vFile
+	}
+
+	// bundles
+	for k, bundle := range vDB.Bundles {
+		ok := !skipped[partKey(bundle.VFilePart)]
+		for _, id := range bundle.Content {
+			if replaced[id] {
+				ok = false
+			}
+		}
+		if ok {
+			if newDb.Bundles == nil {
+				newDb.Bundles = make(map[string]db.Bundle)
+			}
+			newDb.Bundles[k] = bundle
+			continue
+		}
+		for _, id := range bundle.Content {
+			if vFile, exists := newDb.VFiles[id]; exists && vFile.AlsoInBundle == k {
+				vFile.AlsoInBundle = ""
+				newDb.VFiles[id] = vFile
+			}
+		}
+	}
+	return newDb, missing
+}
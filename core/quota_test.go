@@ -0,0 +1,247 @@
+package core_test
+
+import (
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/local"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	rootPath, vDb, _ := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+	historyPath := path.Join(rootPath, "upload.quota")
+
+	// all parts and bundles (the bundles are uploaded last)
+	parts := int64(0)
+	for _, vFile := range vDb.VFiles {
+		for _, part := range vFile.Parts {
+			parts += part.StorageSize
+		}
+	}
+	total := parts
+	for _, bundle := range vDb.Bundles {
+		total += bundle.StorageSize
+	}
+
+	// TEST: per run cap (only the uploaded files are in the index)
+	ram := impl.NewRamService(nil, impl.DebugOff)
+	q, err := core.OpenQuota(historyPath, parts/2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = core.UploadWithOptions(rootPath, vDb, key, ram, core.UploadOptions{Quota: q}, impl.DebugOff)
+	quotaErr, ok := err.(*core.QuotaError)
+	if !ok {
+		t.Fatalf("no quota error: %v", err)
+	}
+	if !q.Reached() || quotaErr.Missing == 0 || quotaErr.Skipped < quotaErr.Missing || len(quotaErr.Db.VFiles)+quotaErr.Missing != len(vDb.VFiles) || len(quotaErr.Db.Bundles) != 0 {
+		t.Fatalf("wrong result: missing=%d, files=%d, bundles=%d", quotaErr.Missing, len(quotaErr.Db.VFiles), len(quotaErr.Db.Bundles))
+	}
+	run, day := q.Used()
+	if run <= 0 || run > parts/2 || run != day {
+		t.Errorf("wrong used bytes: run=%d, day=%d", run, day)
+	}
+	_ = ram.Update()
+	checkOnline(t, quotaErr.Db, ram)
+	if index, _, err := core.LoadIndex(ram, core.IndexName, key); err != nil || len(index.VFiles) != len(quotaErr.Db.VFiles) {
+		t.Errorf("wrong index: %d files, %v", len(index.VFiles), err)
+	}
+
+	// TEST: daily cap over several runs (the history is loaded)
+	q, err = core.OpenQuota(historyPath, 0, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, day := q.Used(); day != run {
+		t.Errorf("wrong history: %d != %d", day, run)
+	}
+	if err := core.UploadWithOptions(rootPath, vDb, key, ram, core.UploadOptions{Quota: q}, impl.DebugOff); err == nil {
+		t.Error("no quota error")
+	}
+
+	// TEST: next run uploads the remaining files
+	q, err = core.OpenQuota(historyPath, 0, 2*total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.UploadWithOptions(rootPath, vDb, key, ram, core.UploadOptions{Quota: q}, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = ram.Update()
+	checkOnline(t, vDb, ram)
+	if _, day := q.Used(); day != total {
+		t.Errorf("wrong daily bytes: %d != %d", day, total)
+	}
+
+	// TEST: old uploads are removed from the history
+	old := time.Now().Add(-25 * time.Hour).Unix()
+	_ = ioutil.WriteFile(historyPath, []byte(fmt.Sprintf("%d 1000\nbroken\n%d 7\n", old, time.Now().Unix())), 0600)
+	if q, err = core.OpenQuota(historyPath, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, day := q.Used(); day != 7 {
+		t.Errorf("wrong history: %d", day)
+	}
+}
+
+func TestQuota_changedFiles(t *testing.T) {
+	rootPath, oldDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+
+	// changed and new file
+	_ = ioutil.WriteFile(path.Join(rootPath, "sub/a.dat"), []byte("changed"), 0600)
+	_ = ioutil.WriteFile(path.Join(rootPath, "sub/new.dat"), []byte("new"), 0600)
+	vDb, _, _, err := db.FromScan(rootPath, oldDb, impl.DebugOff, testUploadKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vDb.MakeBundles(testUploadKeyFile, impl.DebugOff)
+
+	// TEST: no new parts -> the changed file keeps its previous version, the new file is missing
+	q, err := core.OpenQuota("", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = core.UploadWithOptions(rootPath, vDb, key, service, core.UploadOptions{Quota: q}, impl.DebugOff)
+	quotaErr, ok := err.(*core.QuotaError)
+	if !ok {
+		t.Fatalf("no quota error: %v", err)
+	}
+	if quotaErr.Missing != 1 {
+		t.Errorf("wrong missing: %d", quotaErr.Missing)
+	}
+	a, ok := quotaErr.Db.VFiles["sub/a.dat"]
+	if !ok || a.FileSize != oldDb.VFiles["sub/a.dat"].FileSize || a.Parts[0].StorageName != oldDb.VFiles["sub/a.dat"].Parts[0].StorageName || a.AlsoInBundle != "" {
+		t.Errorf("wrong version: %#v", a)
+	}
+	if _, ok := quotaErr.Db.VFiles["sub/new.dat"]; ok {
+		t.Error("new file in the index")
+	}
+	for _, el := range quotaErr.Db.VFiles["sub"].FolderContent {
+		if el.RelPath == "new.dat" {
+			t.Error("new file in the folder content")
+		}
+	}
+	if len(quotaErr.Db.VFiles["sub"].FolderContent) != len(oldDb.VFiles["sub"].FolderContent) {
+		t.Errorf("wrong folder content: %v", quotaErr.Db.VFiles["sub"].FolderContent)
+	}
+	_ = service.Update()
+	checkOnline(t, quotaErr.Db, service)
+}
+
+// checkOnline fails if a part or bundle of the db is not on storage.
+func checkOnline(t *testing.T, vDb db.Db, service interf.Service) {
+	for _, vFile := range vDb.VFiles {
+		for _, part := range vFile.Parts {
+			if _, err := service.Files().ByAttr(part.StorageName, part.StorageSize, part.StorageMd5); err != nil {
+				t.Errorf("part of '%s' not online: %v", vFile.RelPath, err)
+			}
+		}
+		if vFile.AlsoInBundle != "" {
+			if _, ok := vDb.Bundles[vFile.AlsoInBundle]; !ok {
+				t.Errorf("bundle of '%s' not in db", vFile.RelPath)
+			}
+		}
+	}
+	for _, bundle := range vDb.Bundles {
+		if _, err := service.Files().ByAttr(bundle.StorageName, bundle.StorageSize, bundle.StorageMd5); err != nil {
+			t.Errorf("bundle '%s' not online: %v", bundle.StorageName, err)
+		}
+	}
+}
+
+func TestQuota_resume(t *testing.T) {
+	rootPath, vDb, _ := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+	journalPath := path.Join(rootPath, "upload.journal")
+
+	// each run uses a new service instance (empty file list, like a new process)
+	storageDir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+	newService := func() interf.Service {
+		s, err := local.NewLocalService(storageDir, nil, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	parts := int64(0)
+	for _, vFile := range vDb.VFiles {
+		for _, part := range vFile.Parts {
+			parts += part.StorageSize
+		}
+	}
+
+	// run 1: quota reached (paused)
+	j, err := core.OpenJournal(journalPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := core.OpenQuota("", parts/2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = core.UploadWithOptions(rootPath, vDb, key, newService(), core.UploadOptions{Journal: j, Quota: q}, impl.DebugOff)
+	quotaErr, ok := err.(*core.QuotaError)
+	if !ok {
+		t.Fatalf("no quota error: %v", err)
+	}
+	_ = j.Pause()
+	_ = j.Close()
+
+	// an uploaded file is changed
+	names := make([]string, 0)
+	for name, vFile := range quotaErr.Db.VFiles {
+		if len(vFile.Parts) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		t.Fatal("no uploaded file")
+	}
+	sort.Strings(names)
+	prev := quotaErr.Db.VFiles[names[0]]
+	_ = ioutil.WriteFile(path.Join(rootPath, names[0]), []byte("changed"), 0600)
+	vDb, _, _, err = db.FromScan(rootPath, vDb, impl.DebugOff, testUploadKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: run 2 resumes, the changed file keeps the version of run 1
+	if j, err = core.OpenJournal(journalPath, false); err != nil || j.Resumed() == 0 {
+		t.Fatalf("not resumed: %v", err)
+	}
+	defer j.Remove()
+	if q, err = core.OpenQuota("", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	service := newService()
+	err = core.UploadWithOptions(rootPath, vDb, key, service, core.UploadOptions{Journal: j, Quota: q}, impl.DebugOff)
+	if quotaErr, ok = err.(*core.QuotaError); !ok {
+		t.Fatalf("no quota error: %v", err)
+	}
+	is, ok := quotaErr.Db.VFiles[names[0]]
+	if !ok {
+		t.Fatalf("changed file dropped: %s", names[0])
+	}
+	if is.FileSize != prev.FileSize || is.Parts[0].StorageName != prev.Parts[0].StorageName {
+		t.Errorf("wrong version: %#v", is)
+	}
+	_ = service.Update()
+	checkOnline(t, quotaErr.Db, service)
+}
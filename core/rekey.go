@@ -0,0 +1,247 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Rekey replaces the key of the database and returns the new database.
+//
+// Cheap mode (full=false): only the index is uploaded again, encrypted with newKey.IndexKey().
+// The parts are not changed, because all data keys are stored in the index.
+//
+// Full mode (full=true): the storage names and data keys of all parts and bundles are derived from newKey.
+// All parts and bundles are downloaded, re-encrypted, uploaded with the new name and finally the new index is uploaded.
+// The old objects are NOT removed (@see Clean).
+//
+// All snapshots (@see Snapshots) are also re-encrypted (and in full mode their parts are re-encrypted, too).
+// The reference copies of the shares are re-encrypted in cheap mode. A full rekey fails if shares exist (@see Shares):
+// the share indexes are encrypted with the share keys and can't be rewritten to the new storage names.
+// Rekey is resumable: a part that already exists with the new name and size is not uploaded again
+// and a part that already has the new name (database from a previous run) is skipped.
+func Rekey(vDb db.Db, oldKey, newKey *enc.KeyFile, service interf.Service, full bool, debugLvl uint8) (db.Db, error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	// nil check
+	if service == nil || oldKey == nil || newKey == nil {
+		return vDb, errors.New("service or key is nil")
+	}
+	if full && newKey.IsReader() {
+		return vDb, errors.New("full rekey requires a master key file (reader key)")
+	}
+
+	// update service list to find parts from a previous run
+	if err := service.Update(); err != nil {
+		log.Printf("ERROR: %s/Rekey: update file list: %v", packageName, err)
+		return vDb, err
+	}
+	rekeyed := make(map[string]db.VFilePart) // old part (name|md5) -> new part
+
+	// shares
+	if shares := Shares(service); full && len(shares) > 0 {
+		err := fmt.Errorf("%d shares found (%s): a full rekey breaks them, remove the shares first and share again after the rekey", len(shares), strings.Join(shares, ", "))
+		log.Printf("ERROR: %s/Rekey: %v", packageName, err)
+		return vDb, err
+	}
+
+	// snapshots and share reference copies (before the index: the new index marks the end of the rekey)
+	copies := make([]interf.File, 0)
+	for _, s := range Snapshots(service) {
+		copies = append(copies, s.File)
+	}
+	for _, name := range Shares(service) {
+		refName, _ := ShareRefName(name)
+		if list := IndexFiles(service, refName); len(list) > 0 {
+			copies = append(copies, list[0]) // newest
+		}
+	}
+	for _, f := range copies {
+		changed := true
+		sDb, err := ReadDb(f, service, oldKey.IndexKey())
+		if err != nil {
+			// already re-encrypted by a previous run?
+			var err2 error
+			if sDb, err2 = ReadDb(f, service, newKey.IndexKey()); err2 != nil {
+				log.Printf("ERROR: %s/Rekey: '%s': %v", packageName, f.Name(), err)
+				return vDb, err
+			}
+			changed = false
+		}
+		if full {
+			var partsChanged bool
+			if sDb, partsChanged, err = rekeyDb(sDb, newKey, service, rekeyed, debug); err != nil {
+				return vDb, err
+			}
+			changed = changed || partsChanged
+		}
+		if !changed {
+			continue
+		}
+		if err := uploadIndex(sDb, f.Name(), newKey.IndexKey(), service, debug); err != nil {
+			return vDb, err
+		}
+	}
+
+	// cheap mode: index only
+	if !full {
+		if err := uploadDb(vDb, newKey.IndexKey(), service, debug); err != nil {
+			return vDb, err
+		}
+		return vDb, nil
+	}
+
+	// full mode: build new db
+	newDb, _, err := rekeyDb(vDb, newKey, service, rekeyed, debug)
+	if err != nil {
+		return vDb, err
+	}
+
+	// upload db file
+	if err := uploadDb(newDb, newKey.IndexKey(), service, debug); err != nil {
+		return vDb, err
+	}
+
+	// success
+	return newDb, nil
+}
+
+// rekeyDb re-encrypts all parts and bundles of the database and returns the new database (@see rekeyPart).
+// The flag 'changed' is false, if all parts and bundles already had the new name.
+func rekeyDb(vDb db.Db, newKey *enc.KeyFile, service interf.Service, rekeyed map[string]db.VFilePart, debug bool) (newDb db.Db, changed bool, err error) {
+	newDb = db.NewDb()
+
+	// sort list
+	list := make([]db.VirtFile, 0, len(vDb.VFiles))
+	for _, vFile := range vDb.VFiles {
+		list = append(list, vFile)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RelPath < list[j].RelPath
+	})
+
+	// all parts
+	for i, vFile := range list {
+		parts := make([]db.VFilePart, 0, len(vFile.Parts))
+		for _, part := range vFile.Parts {
+			newPart, err := rekeyPart(part, newKey, service, rekeyed, debug)
+			if err != nil {
+				log.Printf("ERROR: %s/Rekey: '%s': %v", packageName, vFile.RelPath, err)
+				return vDb, changed, err
+			}
+			changed = changed || newPart.StorageName != part.StorageName
+			parts = append(parts, newPart)
+		}
+		vFile.Parts = parts
+		newDb.VFiles[vFile.Id()] = vFile
+
+		// log
+		if debug && len(vFile.Parts) > 0 {
+			log.Printf("DEBUG: %s/Rekey: [%d/%d] '%s'", packageName, i+1, len(list), vFile.RelPath)
+		}
+	}
+
+	// all bundles
+	if len(vDb.Bundles) > 0 {
+		newDb.Bundles = make(map[string]db.Bundle)
+	}
+	for _, bundle := range vDb.Bundles {
+		newPart, err := rekeyPart(bundle.VFilePart, newKey, service, rekeyed, debug)
+		if err != nil {
+			log.Printf("ERROR: %s/Rekey: bundle '%s': %v", packageName, bundle.Id(), err)
+			return vDb, changed, err
+		}
+		changed = changed || newPart.StorageName != bundle.StorageName
+		newBundle := db.Bundle{VFilePart: newPart, Content: bundle.Content}
+		newDb.Bundles[newBundle.Id()] = newBundle
+
+		// connect all virtual files with the new bundle
+		for _, relPath := range bundle.Content {
+			tmp := newDb.VFiles[relPath]
+			tmp.AlsoInBundle = newBundle.Id()
+			newDb.VFiles[relPath] = tmp
+		}
+	}
+	return newDb, changed, nil
+}
+
+// rekeyPart re-encrypts a part (or bundle) with the new key and uploads it with the new storage name.
+// The map 'rekeyed' prevents double uploads of the same part.
+func rekeyPart(part db.VFilePart, newKey *enc.KeyFile, service interf.Service, rekeyed map[string]db.VFilePart, debug bool) (db.VFilePart, error) {
+	// already done
+	key := part.StorageName + "|" + part.StorageMd5
+	if p, ok := rekeyed[key]; ok {
+		return p, nil
+	}
+
+	// new name and key
+	isBundle := strings.HasPrefix(part.StorageName, db.BundlePrefix)
+	newPart := db.VFilePart{
+		PlainSHA512:  part.PlainSHA512,
+		StorageName:  newKey.CryptName(part.PlainSHA512),
+		StorageSize:  part.StorageSize,
+		StorageMd5:   "",
+		CryptDataKey: newKey.DataKey(part.PlainSHA512),
+	}
+	if isBundle {
+		newPart.StorageName = db.BundlePrefix + newPart.StorageName
+	}
+
+	// part has already the new name (db from a previous run)
+	if newPart.StorageName == part.StorageName && bytes.Equal(newPart.CryptDataKey, part.CryptDataKey) {
+		rekeyed[key] = part
+		return part, nil
+	}
+
+	// part was already uploaded (interrupted run)
+	if f, err := service.Files().ByAttr(newPart.StorageName, newPart.StorageSize, ""); err == nil {
+		if !isBundle {
+			newPart.StorageMd5 = f.Md5()
+		}
+		rekeyed[key] = newPart
+		return newPart, nil
+	}
+
+	// old part
+	oldFile, err := service.Files().ByAttr(part.StorageName, part.StorageSize, part.StorageMd5)
+	if err != nil {
+		return part, err
+	}
+	if debug {
+		sizeInMb := float64(part.StorageSize) / (1024 * 1024)
+		log.Printf("DEBUG: %s/rekeyPart: %s -> %s (%.2f MB)", packageName, part.StorageName, newPart.StorageName, sizeInMb)
+	}
+
+	// download, decrypt, encrypt (the encryption offset is 0 for each part and bundle)
+	r, err := service.Reader(oldFile, 0)
+	if err != nil {
+		return part, err
+	}
+	defer r.Close()
+	r = enc.CryptoReader(r, 0, part.CryptDataKey)
+	r = enc.CryptoReader(r, 0, newPart.CryptDataKey)
+
+	// upload
+	f, err := service.Save(newPart.StorageName, r, 0)
+	if err != nil {
+		return part, err
+	}
+	if f.Size() != newPart.StorageSize {
+		return part, errors.New("rekey size check fail")
+	}
+	if !isBundle {
+		newPart.StorageMd5 = f.Md5()
+	}
+
+	// success
+	rekeyed[key] = newPart
+	return newPart, nil
+}
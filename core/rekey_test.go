@@ -0,0 +1,189 @@
+package core_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/SchnorcherSepp/splitfs/core"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// failService fails after n saves (simulates an interrupted run).
+type failService struct {
+	interf.Service
+	n int
+}
+
+func (s *failService) Save(name string, r io.Reader, max int64) (interf.File, error) {
+	if s.n <= 0 {
+		return nil, errors.New("save error")
+	}
+	s.n--
+	return s.Service.Save(name, r, max)
+}
+
+func TestRekey(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	keyPath := path.Join(rootPath, "new.key")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	newKey, _ := enc.LoadKeyFile(keyPath)
+
+	// TEST: nil
+	if _, err := core.Rekey(vDb, testUploadKeyFile, nil, service, false, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+
+	// snapshot (re-encrypted by all modes)
+	if _, err := core.UploadSnapshot(vDb, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: cheap mode (index only)
+	cheapDb, err := core.Rekey(vDb, testUploadKeyFile, newKey, service, false, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if _, err := core.LoadDb(service, testUploadKeyFile.IndexKey()); err == nil {
+		t.Error("old key works")
+	}
+	if _, err := core.LoadDb(service, newKey.IndexKey()); err != nil {
+		t.Fatal(err)
+	}
+	if cheapDb.VFiles["sub/a.dat"].Parts[0].StorageName != vDb.VFiles["sub/a.dat"].Parts[0].StorageName {
+		t.Error("part changed")
+	}
+	if len(service.Files().All()) != 9 { // 6 parts + 1 bundle + index + snapshot
+		t.Fatalf("wrong len: %d", len(service.Files().All()))
+	}
+	if s, err := core.FindSnapshot(service, core.Snapshots(service)[0].Name()); err != nil {
+		t.Fatal(err)
+	} else if _, err := core.ReadDb(s.File, service, newKey.IndexKey()); err != nil {
+		t.Errorf("snapshot not re-encrypted: %v", err)
+	}
+
+	// TEST: full mode, interrupted after 3 uploads
+	if _, err := core.Rekey(vDb, testUploadKeyFile, newKey, &failService{Service: service, n: 3}, true, impl.DebugOff); err == nil {
+		t.Fatal("no error")
+	}
+	_ = service.Update()
+	if len(service.Files().All()) != 12 {
+		t.Fatalf("wrong len: %d", len(service.Files().All()))
+	}
+
+	// TEST: full mode, resume (4 parts, the snapshot and the index are uploaded)
+	fs := &failService{Service: service, n: 6}
+	newDb, err := core.Rekey(vDb, testUploadKeyFile, newKey, fs, true, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.n != 0 {
+		t.Errorf("wrong upload count: %d", 6-fs.n)
+	}
+	for _, name := range []string{"text.txt", "sub/a.dat", "sub/deeper/c.dat"} {
+		if newDb.VFiles[name].Parts[0].StorageName == vDb.VFiles[name].Parts[0].StorageName {
+			t.Errorf("part not changed: %s", name)
+		}
+	}
+	for id, bundle := range newDb.Bundles {
+		if _, ok := vDb.Bundles[id]; ok || len(bundle.Content) == 0 {
+			t.Errorf("bundle not changed: %s", id)
+		}
+		for _, relPath := range bundle.Content {
+			if newDb.VFiles[relPath].AlsoInBundle != id {
+				t.Errorf("wrong bundle link: %s", relPath)
+			}
+		}
+	}
+
+	// TEST: full mode again (nothing to do)
+	fs = &failService{Service: service, n: 1}
+	if _, err := core.Rekey(newDb, testUploadKeyFile, newKey, fs, true, impl.DebugOff); err != nil || fs.n != 0 {
+		t.Fatalf("err=%v, n=%d", err, fs.n)
+	}
+
+	// TEST: clean removes the old objects
+	if err := core.Clean(newDb, newKey.IndexKey(), service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if len(service.Files().All()) != 9 {
+		t.Fatalf("wrong len: %d", len(service.Files().All()))
+	}
+	loadDb, err := core.LoadDb(service, newKey.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := core.Verify(loadDb, service, true, impl.DebugOff)
+	if err != nil || !report.Ok() || report.Bundles != 1 {
+		t.Fatalf("err=%v, report=%#v", err, report)
+	}
+
+	// TEST: content
+	for name, vFile := range loadDb.VFiles {
+		if vFile.IsDir {
+			continue
+		}
+		rAt, err := core.Open(vFile, loadDb, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(path.Join(rootPath, name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+}
+
+func TestRekey_shares(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	keyPath := path.Join(rootPath, "new.key")
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	newKey, _ := enc.LoadKeyFile(keyPath)
+
+	shareKey := bytes.Repeat([]byte{7}, 32)
+	if _, err := core.UploadShare(vDb, "/sub", "partner", shareKey, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: full mode fails (nothing is uploaded)
+	fs := &failService{Service: service, n: 1}
+	if _, err := core.Rekey(vDb, testUploadKeyFile, newKey, fs, true, impl.DebugOff); err == nil {
+		t.Fatal("no error")
+	}
+	if fs.n != 1 {
+		t.Error("uploaded")
+	}
+
+	// TEST: cheap mode re-encrypts the reference copy
+	if _, err := core.Rekey(vDb, testUploadKeyFile, newKey, service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if err := core.Clean(vDb, newKey.IndexKey(), service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	f, err := service.Files().ByName("index-partner.db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.ReadDb(f, service, shareKey); err != nil {
+		t.Error(err)
+	}
+}
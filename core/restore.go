@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tmpSuffix is appended to a file name while the file is restored.
+const tmpSuffix = ".splitfs-tmp"
+
+// Restore writes all files and folders below relPrefix from the database into targetDir.
+// The folder structure (RelPath) and the MTime are recreated. The file content is read with Open(),
+// so bundles, compressed files and multi part files are supported.
+// Files that already exist with the same size and MTime are skipped.
+//
+// The map 'failed' contains an error for each element that could not be restored (map key: RelPath).
+// The error 'retErr' is only set if the restore could not be started at all.
+func Restore(vDb db.Db, service interf.Service, relPrefix, targetDir string, debugLvl uint8) (failed map[string]error, summary string, retErr error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+	failed = make(map[string]error)
+
+	// nil check
+	if service == nil {
+		retErr = errors.New("service is nil")
+		return
+	}
+	if vDb.VFiles == nil {
+		retErr = errors.New("db is nil")
+		return
+	}
+
+	// create target dir
+	if err := os.MkdirAll(targetDir, 0700); err != nil {
+		retErr = err
+		return
+	}
+
+	// get all elements below the prefix (sorted: parent folders first)
+	list := make([]db.VirtFile, 0)
+	for _, vFile := range vDb.VFiles {
+		if InPath(vFile.RelPath, relPrefix) {
+			list = append(list, vFile)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RelPath < list[j].RelPath
+	})
+	if len(list) == 0 {
+		retErr = fmt.Errorf("path not found in db: '%s'", relPrefix)
+		return
+	}
+
+	// RESTORE
+	countFiles, countSkipped := 0, 0
+	folders := make([]db.VirtFile, 0)
+	for i, vFile := range list {
+		// target path
+		absPath, err := targetPath(targetDir, vFile.RelPath)
+		if err != nil {
+			failed[vFile.RelPath] = err
+			continue
+		}
+
+		// folder: create
+		if vFile.IsDir {
+			if err := os.MkdirAll(absPath, 0700); err != nil {
+				failed[vFile.RelPath] = err
+			} else {
+				folders = append(folders, vFile) // set MTime at the end
+			}
+			continue
+		}
+
+		// file: skip if unchanged
+		if fileMatch(absPath, vFile) {
+			countSkipped++
+			continue
+		}
+
+		// file: restore
+		if debug {
+			sizeInMb := float64(vFile.FileSize) / (1024 * 1024)
+			log.Printf("DEBUG: %s/Restore: [%d/%d] '%s' (%.2f MB)", packageName, i+1, len(list), vFile.RelPath, sizeInMb)
+		}
+		if err := restoreFile(absPath, vFile, vDb, service, debugLvl); err != nil {
+			log.Printf("ERROR: %s/Restore: '%s': %v", packageName, vFile.RelPath, err)
+			failed[vFile.RelPath] = err
+			continue
+		}
+		countFiles++
+	}
+
+	// set folder MTime (children first, because writing a file changes the folder MTime)
+	for i := len(folders) - 1; i >= 0; i-- {
+		vFile := folders[i]
+		absPath, _ := targetPath(targetDir, vFile.RelPath)
+		mtime := time.Unix(vFile.MTime, 0)
+		if err := os.Chtimes(absPath, mtime, mtime); err != nil {
+			failed[vFile.RelPath] = err
+		}
+	}
+
+	// statistic
+	summary = fmt.Sprintf("RESTORE: sum=%d, folders=%d, restored=%d, skipped=%d, failed=%d", len(list), len(folders), countFiles, countSkipped, len(failed))
+	if debug {
+		log.Printf("DEBUG: %s/Restore: %s", packageName, summary)
+	}
+	return
+}
+
+// InPath checks whether relPath is relPrefix itself or an element below relPrefix.
+// An empty prefix, '.' or '/' matches all elements.
+func InPath(relPath, relPrefix string) bool {
+	relPrefix = strings.Trim(relPrefix, "/")
+	if relPrefix == "" || relPrefix == "." {
+		return true
+	}
+	return relPath == relPrefix || strings.HasPrefix(relPath, relPrefix+"/")
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// targetPath returns the local path of a db element.
+// The path must not leave the target folder.
+func targetPath(targetDir, relPath string) (string, error) {
+	absPath := filepath.Join(targetDir, filepath.FromSlash(relPath))
+	rel, err := filepath.Rel(targetDir, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path: '%s'", relPath)
+	}
+	return absPath, nil
+}
+
+// fileMatch checks whether a local file already has the size and MTime of the virtual file.
+func fileMatch(absPath string, vFile db.VirtFile) bool {
+	st, err := os.Stat(absPath)
+	if err != nil {
+		return false // file not found
+	}
+	return !st.IsDir() && st.Size() == vFile.FileSize && st.ModTime().Unix() == vFile.MTime
+}
+
+// restoreFile writes the content of a virtual file to a temporary file and renames it at the end.
+func restoreFile(absPath string, vFile db.VirtFile, vDb db.Db, service interf.Service, debugLvl uint8) error {
+	// open storage reader
+	rAt, err := Open(vFile, vDb, service, debugLvl)
+	if err != nil {
+		return err
+	}
+	defer rAt.Close()
+
+	// write temp file
+	tmpPath := absPath + tmpSuffix
+	fh, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(fh, io.NewSectionReader(rAt, 0, vFile.FileSize))
+	if e := fh.Close(); err == nil {
+		err = e
+	}
+	if err == nil && n != vFile.FileSize {
+		err = fmt.Errorf("size check fail: %d != %d", n, vFile.FileSize)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// rename and set MTime
+	if err := os.Rename(tmpPath, absPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	mtime := time.Unix(vFile.MTime, 0)
+	return os.Chtimes(absPath, mtime, mtime)
+}
@@ -0,0 +1,179 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// initRestoreTest creates a small test folder, uploads it to a ram service and returns the db.
+func initRestoreTest(t *testing.T) (string, db.Db, interf.Service) {
+	rootPath, err := ioutil.TempDir("", "restoreTestFolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test files
+	files := map[string][]byte{
+		"zero.dat":           {},
+		"text.txt":           bytes.Repeat([]byte("compress me! "), 1000),
+		"sub/a.dat":          {1, 2, 3, 4, 5, 6, 7, 8, 9},
+		"sub/b.dat":          {9, 8, 7},
+		"sub/deeper/c.dat":   bytes.Repeat([]byte{0x00, 0xFF, 0x7E}, 30000),
+		"other/outside.dat":  {42},
+		"other/outside2.dat": {43},
+	}
+	for name, data := range files {
+		p := path.Join(rootPath, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Unix(1500000000, 0)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// scan & bundle
+	vDb, _, _, err := db.FromScan(rootPath, db.NewDb(), impl.DebugOff, testUploadKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vDb.MakeBundles(testUploadKeyFile, impl.DebugOff)
+
+	// upload
+	service := impl.NewRamService(nil, impl.DebugOff)
+	if err := core.Upload(rootPath, vDb, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Update(); err != nil {
+		t.Fatal(err)
+	}
+	return rootPath, vDb, service
+}
+
+func TestRestore(t *testing.T) {
+	rootPath, _, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	targetDir, err := ioutil.TempDir("", "restoreTestTarget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetDir)
+
+	// load db from storage
+	vDb, err := core.LoadDb(service, testUploadKeyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: restore all
+	failed, summary, err := core.Restore(vDb, service, "/", targetDir, impl.DebugOff)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("err=%v, failed=%v", err, failed)
+	}
+	t.Log(summary)
+
+	// compare content and mtime
+	for _, vFile := range vDb.VFiles {
+		p := path.Join(targetDir, vFile.RelPath)
+		st, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.ModTime().Unix() != vFile.MTime {
+			t.Errorf("wrong mtime: %s", vFile.RelPath)
+		}
+		if vFile.IsDir {
+			continue
+		}
+		is, _ := ioutil.ReadFile(p)
+		su, _ := ioutil.ReadFile(path.Join(rootPath, vFile.RelPath))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", vFile.RelPath)
+		}
+	}
+
+	// TEST: restore again -> skip all files
+	_, summary, _ = core.Restore(vDb, service, ".", targetDir, impl.DebugOff)
+	if summary != "RESTORE: sum=11, folders=4, restored=0, skipped=7, failed=0" {
+		t.Errorf("wrong summary: %s", summary)
+	}
+}
+
+func TestRestore_subtree(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	targetDir, err := ioutil.TempDir("", "restoreTestTarget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetDir)
+
+	// TEST: restore sub folder only
+	failed, summary, err := core.Restore(vDb, service, "sub/", targetDir, impl.DebugOff)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("err=%v, failed=%v", err, failed)
+	}
+	if summary != "RESTORE: sum=5, folders=2, restored=3, skipped=0, failed=0" {
+		t.Errorf("wrong summary: %s", summary)
+	}
+	if _, err := os.Stat(path.Join(targetDir, "sub/deeper/c.dat")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path.Join(targetDir, "other")); err == nil {
+		t.Error("folder outside the prefix was restored")
+	}
+
+	// TEST: unknown path
+	if _, _, err := core.Restore(vDb, service, "unknown", targetDir, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestRestore_missingPart(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	targetDir, err := ioutil.TempDir("", "restoreTestTarget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(targetDir)
+
+	// remove all parts and bundles of one file
+	vFile := vDb.VFiles["sub/deeper/c.dat"]
+	for _, f := range service.Files().All() {
+		if f.Name() == vFile.Parts[0].StorageName || f.Name() == vFile.AlsoInBundle {
+			_ = service.Trash(f)
+		}
+	}
+	_ = service.Update()
+
+	// TEST: report the failed file, restore the rest
+	failed, _, err := core.Restore(vDb, service, "sub", targetDir, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := failed["sub/deeper/c.dat"]; !ok || len(failed) != 1 {
+		t.Fatalf("failed=%v", failed)
+	}
+	if _, err := os.Stat(path.Join(targetDir, "sub/deeper/c.dat")); err == nil {
+		t.Error("failed file exists")
+	}
+	if _, err := os.Stat(path.Join(targetDir, "sub/a.dat")); err != nil {
+		t.Error(err)
+	}
+}
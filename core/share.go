@@ -0,0 +1,149 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// shareNameRegex defines the valid share names.
+var shareNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ShareIndexName returns the storageName of a partial index (share): 'index-<name>.db2'.
+func ShareIndexName(name string) (string, error) {
+	if !shareNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid share name '%s' (allowed: a-z, A-Z, 0-9, '-', '_')", name)
+	}
+	return "index-" + name + ".db2", nil
+}
+
+// ShareRefName returns the storageName of the reference copy of a share: 'shareref-<name>.db2'.
+func ShareRefName(name string) (string, error) {
+	if !shareNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid share name '%s' (allowed: a-z, A-Z, 0-9, '-', '_')", name)
+	}
+	return ShareRefPrefix + name + ".db2", nil
+}
+
+// Shares returns the names of all shares (@see ShareIndexName), sorted.
+// The check is based on the service file list (offline).
+func Shares(service interf.Service) []string {
+	found := make(map[string]bool)
+	for _, f := range service.Files().All() {
+		name := f.Name()
+		if !strings.HasPrefix(name, "index-") || !strings.HasSuffix(name, ".db2") {
+			continue
+		}
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "index-"), ".db2")
+		if shareNameRegex.MatchString(name) {
+			found[name] = true
+		}
+	}
+	list := make([]string, 0, len(found))
+	for name := range found {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// UploadShare extracts all elements below relPrefix into a standalone database (@see db.Db.Subtree)
+// and uploads it encrypted with shareKey as partial index (@see ShareIndexName).
+// An existing share with the same name is replaced. The main index (@see IndexName) is not changed.
+//
+// The share uses the parts and bundles of the main index. A reference copy of the share, encrypted with
+// the indexKey, is uploaded first (@see ShareRefName), so Clean() keeps the parts of the share.
+func UploadShare(vDb db.Db, relPrefix, name string, shareKey, indexKey []byte, service interf.Service, debugLvl uint8) (db.Db, error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	// share name
+	indexName, err := ShareIndexName(name)
+	if err != nil {
+		return db.NewDb(), err
+	}
+	refName, _ := ShareRefName(name)
+	if indexKey == nil {
+		return db.NewDb(), errors.New("index key is nil")
+	}
+
+	// update service list to find an old share
+	if err := service.Update(); err != nil {
+		log.Printf("ERROR: %s/UploadShare: update file list: %v", packageName, err)
+		return db.NewDb(), err
+	}
+
+	// extract subtree
+	sub, err := vDb.Subtree(relPrefix)
+	if err != nil {
+		log.Printf("ERROR: %s/UploadShare: '%s': %v", packageName, relPrefix, err)
+		return sub, err
+	}
+
+	// upload (reference copy first)
+	if err := uploadIndex(sub, refName, indexKey, service, debug); err != nil {
+		return sub, err
+	}
+	if err := uploadIndex(sub, indexName, shareKey, service, debug); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// RemoveShare removes the partial index (share) and its reference copy from the storage.
+// The service file list must be up to date (@see interf.Service.Update).
+func RemoveShare(name string, service interf.Service) error {
+	indexName, err := ShareIndexName(name)
+	if err != nil {
+		return err
+	}
+
+	refName, _ := ShareRefName(name)
+
+	found := false
+	for _, f := range service.Files().All() {
+		if f.Name() == refName {
+			if err := service.Trash(f); err != nil {
+				return err
+			}
+		}
+		if f.Name() == indexName {
+			if err := service.Trash(f); err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("share not found: '%s'", name)
+	}
+	return nil
+}
+
+// shareDbs reads the reference copies of all shares with the index key (e.g. to keep their parts in Clean).
+// Fails if a share has no readable reference copy (e.g. uploaded by an older version).
+// The service file list must be up to date (@see interf.Service.Update).
+func shareDbs(service interf.Service, indexKey []byte) ([]db.Db, error) {
+	list := Shares(service)
+	if len(list) > 0 && indexKey == nil {
+		return nil, fmt.Errorf("%d shares found: the index key is required", len(list))
+	}
+
+	dbs := make([]db.Db, 0, len(list))
+	for _, name := range list {
+		refName, _ := ShareRefName(name)
+		sDb, _, err := LoadIndex(service, refName, indexKey)
+		if err != nil {
+			log.Printf("ERROR: %s/shareDbs: share '%s': %v", packageName, name, err)
+			return nil, fmt.Errorf("share '%s': no readable reference copy '%s' (upload or remove the share again): %v", name, refName, err)
+		}
+		dbs = append(dbs, sDb)
+	}
+	return dbs, nil
+}
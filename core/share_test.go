@@ -0,0 +1,140 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestShareIndexName(t *testing.T) {
+	if n, err := core.ShareIndexName("partner-1"); err != nil || n != "index-partner-1.db2" {
+		t.Errorf("n=%s, err=%v", n, err)
+	}
+	for _, name := range []string{"", "a/b", "a.b", "ä"} {
+		if _, err := core.ShareIndexName(name); err == nil {
+			t.Errorf("no error: '%s'", name)
+		}
+	}
+}
+
+func TestUploadShare(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	shareKey := bytes.Repeat([]byte{7}, 32)
+	mainIndex, _ := core.IndexFile(service)
+
+	// TEST: errors
+	if _, err := core.UploadShare(vDb, "sub", "a/b", shareKey, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+	if _, err := core.UploadShare(vDb, "zero.dat", "partner", shareKey, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: upload share 'sub' (twice: replace)
+	for i := 0; i < 2; i++ {
+		if _, err := core.UploadShare(vDb, "/sub", "partner", shareKey, testUploadKeyFile.IndexKey(), service, impl.DebugOff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = service.Update()
+	f, err := service.Files().ByName("index-partner.db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(service.Files().All()) != 10 { // 6 parts + 1 bundle + index + share + reference copy
+		t.Errorf("wrong len: %d", len(service.Files().All()))
+	}
+	if idx, _ := core.IndexFile(service); idx.Id() != mainIndex.Id() {
+		t.Error("main index changed")
+	}
+
+	// TEST: read share with the share key
+	if _, err := core.ReadDb(f, service, testUploadKeyFile.IndexKey()); err == nil {
+		t.Error("main key works")
+	}
+	shareDb, err := core.ReadDb(f, service, shareKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := shareDb.VFiles["text.txt"]; ok || len(shareDb.VFiles) != 5 { // . a.dat b.dat deeper deeper/c.dat
+		t.Fatalf("wrong share: %d", len(shareDb.VFiles))
+	}
+	for _, name := range []string{"a.dat", "b.dat", "deeper/c.dat"} {
+		vFile := shareDb.VFiles[name]
+		rAt, err := core.Open(vFile, shareDb, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(path.Join(rootPath, "sub", name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// TEST: clean keeps the share
+	if err := core.Clean(vDb, testUploadKeyFile.IndexKey(), service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if _, err := service.Files().ByName("index-partner.db2"); err != nil {
+		t.Error(err)
+	}
+	if l := core.Shares(service); len(l) != 1 || l[0] != "partner" {
+		t.Errorf("wrong shares: %v", l)
+	}
+
+	// TEST: clean keeps the parts of the share (not in the main index)
+	if err := core.Clean(db.NewDb(), testUploadKeyFile.IndexKey(), service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	for _, name := range []string{"a.dat", "b.dat", "deeper/c.dat"} {
+		vFile := shareDb.VFiles[name]
+		rAt, err := core.Open(vFile, shareDb, service, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _ := ioutil.ReadAll(io.NewSectionReader(rAt, 0, vFile.FileSize))
+		su, _ := ioutil.ReadFile(path.Join(rootPath, "sub", name))
+		if !bytes.Equal(is, su) {
+			t.Errorf("wrong content after clean: %s", name)
+		}
+		_ = rAt.Close()
+	}
+
+	// TEST: clean fails without the reference copy (nothing is deleted)
+	ref, err := service.Files().ByName("shareref-partner.db2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Trash(ref); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	before := len(service.Files().All())
+	if err := core.Clean(db.NewDb(), testUploadKeyFile.IndexKey(), service, false, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+	_ = service.Update()
+	if len(service.Files().All()) != before {
+		t.Error("files deleted")
+	}
+
+	// TEST: remove share
+	if err := core.RemoveShare("partner", service); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	if err := core.RemoveShare("partner", service); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is an encrypted copy of the index in the storage (@see UploadSnapshot).
+type Snapshot struct {
+	Time time.Time   // creation time (UTC, seconds)
+	File interf.File // storage file
+}
+
+// Name returns the timestamp of the snapshot (e.g. '20211231T235959Z').
+func (s Snapshot) Name() string {
+	return s.Time.UTC().Format(SnapshotTimeFormat)
+}
+
+// SnapshotName returns the storageName of a snapshot: 'snapshot-<timestamp>.db2'.
+func SnapshotName(t time.Time) string {
+	return SnapshotPrefix + t.UTC().Format(SnapshotTimeFormat) + ".db2"
+}
+
+// Snapshots returns all snapshots sorted by time (oldest first).
+// The check is based on the service file list (offline).
+func Snapshots(service interf.Service) []Snapshot {
+	list := make([]Snapshot, 0)
+	for _, f := range service.Files().All() {
+		name := f.Name()
+		if !strings.HasPrefix(name, SnapshotPrefix) || !strings.HasSuffix(name, ".db2") {
+			continue
+		}
+		t, err := time.Parse(SnapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, SnapshotPrefix), ".db2"))
+		if err != nil {
+			continue
+		}
+		list = append(list, Snapshot{Time: t, File: f})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list
+}
+
+// FindSnapshot returns the snapshot with the name (timestamp, @see Snapshot.Name).
+// The check is based on the service file list (offline).
+func FindSnapshot(service interf.Service, name string) (Snapshot, error) {
+	for _, s := range Snapshots(service) {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("snapshot '%s' not found", name)
+}
+
+// UploadSnapshot uploads the database as snapshot with the current time and returns the snapshot name.
+// A snapshot is a copy of the index (encrypted with indexKey), so all parts stay available until the snapshot
+// is removed (@see PruneSnapshots and Clean).
+func UploadSnapshot(vDb db.Db, indexKey []byte, service interf.Service, debugLvl uint8) (string, error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	now := time.Now()
+	if err := uploadIndex(vDb, SnapshotName(now), indexKey, service, debug); err != nil {
+		return "", err
+	}
+	return Snapshot{Time: now}.Name(), nil
+}
+
+// KeepPolicy defines which snapshots are retained (@see PruneSnapshots).
+// Each rule keeps the newest snapshot of the last n days, weeks or months (in which snapshots exist).
+// A snapshot is retained, if one of the rules keeps it.
+type KeepPolicy struct {
+	Last    int // the last n snapshots
+	Daily   int // the newest snapshot of the last n days
+	Weekly  int // the newest snapshot of the last n weeks (ISO week)
+	Monthly int // the newest snapshot of the last n months
+}
+
+// Retain returns the names of all snapshots that are retained by the policy.
+func (p KeepPolicy) Retain(list []Snapshot) map[string]bool {
+	// newest first
+	sorted := append([]Snapshot{}, list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	// rules: count and bucket function
+	type rule struct {
+		n      int
+		bucket func(t time.Time) string
+		last   string
+	}
+	rules := []*rule{
+		{n: p.Last, bucket: func(t time.Time) string { return t.Format(SnapshotTimeFormat) }},
+		{n: p.Daily, bucket: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{n: p.Weekly, bucket: func(t time.Time) string { y, w := t.ISOWeek(); return fmt.Sprintf("%d-%02d", y, w) }},
+		{n: p.Monthly, bucket: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	retain := make(map[string]bool)
+	for _, s := range sorted {
+		t := s.Time.UTC()
+		for _, r := range rules {
+			if r.n <= 0 {
+				continue
+			}
+			if b := r.bucket(t); b != r.last {
+				r.last = b
+				r.n--
+				retain[s.Name()] = true
+			}
+		}
+	}
+	return retain
+}
+
+// PruneSnapshots removes all snapshots that are not retained by the policy and returns the removed snapshots.
+// At least one rule must be set. If the try flag is true, nothing is deleted.
+// The parts of the removed snapshots are removed by the next Clean.
+func PruneSnapshots(service interf.Service, policy KeepPolicy, try bool, debugLvl uint8) ([]Snapshot, error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	// nil check
+	if service == nil {
+		return nil, errors.New("service is nil")
+	}
+	if policy.Last <= 0 && policy.Daily <= 0 && policy.Weekly <= 0 && policy.Monthly <= 0 {
+		return nil, errors.New("no keep rule: all snapshots would be removed")
+	}
+
+	// update service list
+	if err := service.Update(); err != nil {
+		log.Printf("ERROR: %s/PruneSnapshots: update file list: %v", packageName, err)
+		return nil, err
+	}
+
+	// remove
+	list := Snapshots(service)
+	retain := policy.Retain(list)
+	removed := make([]Snapshot, 0)
+	for _, s := range list {
+		if retain[s.Name()] {
+			continue
+		}
+		if debug {
+			log.Printf("DEBUG: %s/PruneSnapshots: remove snapshot '%s' (try=%v)", packageName, s.Name(), try)
+		}
+		if !try {
+			if err := service.Trash(s.File); err != nil {
+				log.Printf("ERROR: %s/PruneSnapshots: remove '%s': %v", packageName, s.File.Name(), err)
+				return removed, err
+			}
+		}
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
+
+// snapshotDbs downloads and decrypts all snapshots (e.g. to keep their parts in Clean).
+// The service file list must be up to date (@see interf.Service.Update).
+func snapshotDbs(service interf.Service, indexKey []byte) ([]db.Db, error) {
+	list := Snapshots(service)
+	if len(list) > 0 && indexKey == nil {
+		return nil, fmt.Errorf("%d snapshots found: the index key is required", len(list))
+	}
+
+	dbs := make([]db.Db, 0, len(list))
+	for _, s := range list {
+		sDb, err := ReadDb(s.File, service, indexKey)
+		if err != nil {
+			log.Printf("ERROR: %s/snapshotDbs: snapshot '%s': %v", packageName, s.Name(), err)
+			return nil, fmt.Errorf("snapshot '%s': %v", s.Name(), err)
+		}
+		dbs = append(dbs, sDb)
+	}
+	return dbs, nil
+}
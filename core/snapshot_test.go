@@ -0,0 +1,149 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeepPolicy_Retain(t *testing.T) {
+	// two snapshots per day (1 Jan - 20 Feb 2021)
+	list := make([]core.Snapshot, 0)
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	for d := 0; d < 51; d++ {
+		day := start.AddDate(0, 0, d)
+		list = append(list, core.Snapshot{Time: day}, core.Snapshot{Time: day.Add(8 * time.Hour)})
+	}
+
+	tests := []struct {
+		policy core.KeepPolicy
+		want   []string
+	}{
+		{core.KeepPolicy{}, []string{}},
+		{core.KeepPolicy{Last: 3}, []string{"20210219T160000Z", "20210220T080000Z", "20210220T160000Z"}},
+		{core.KeepPolicy{Daily: 2}, []string{"20210219T160000Z", "20210220T160000Z"}},
+		{core.KeepPolicy{Weekly: 2}, []string{"20210214T160000Z", "20210220T160000Z"}},
+		{core.KeepPolicy{Monthly: 5}, []string{"20210131T160000Z", "20210220T160000Z"}},
+		{core.KeepPolicy{Last: 1, Daily: 1, Monthly: 2}, []string{"20210131T160000Z", "20210220T160000Z"}},
+	}
+	for i, tt := range tests {
+		retain := tt.policy.Retain(list)
+		got := make([]string, 0, len(retain))
+		for name := range retain {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test %d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	// TEST: no snapshots
+	if len(core.Snapshots(service)) != 0 {
+		t.Fatal("snapshots found")
+	}
+
+	// TEST: upload
+	name, err := core.UploadSnapshot(vDb, testUploadKeyFile.IndexKey(), service, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	s, err := core.FindSnapshot(service, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.File.Name() != core.SnapshotName(s.Time) {
+		t.Errorf("wrong name: %s", s.File.Name())
+	}
+	sDb, err := core.ReadDb(s.File, service, testUploadKeyFile.IndexKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sDb.VFiles) != len(vDb.VFiles) {
+		t.Errorf("wrong snapshot: %d elements", len(sDb.VFiles))
+	}
+	if _, err := core.FindSnapshot(service, "20000101T000000Z"); err == nil {
+		t.Error("no error")
+	}
+
+	// older snapshot (copy)
+	r, _ := service.Reader(s.File, 0)
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	old := s.Time.AddDate(0, -1, 0)
+	if _, err := service.Save(core.SnapshotName(old), bytes.NewReader(b), 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	list := core.Snapshots(service)
+	if len(list) != 2 || !list[0].Time.Equal(old.Truncate(time.Second)) {
+		t.Fatalf("wrong list: %v", list)
+	}
+
+	// TEST: clean keeps the parts of the snapshots
+	newDb := db.NewDb()
+	for relPath, vFile := range vDb.VFiles {
+		if relPath != "sub/deeper/c.dat" {
+			newDb.VFiles[relPath] = vFile
+		}
+	}
+	newDb.Bundles = vDb.Bundles
+	if err := core.Clean(newDb, nil, service, true, impl.DebugOff); err == nil {
+		t.Error("no error (snapshots without key)")
+	}
+	if err := core.Clean(newDb, testUploadKeyFile.IndexKey(), service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	for _, part := range vDb.VFiles["sub/deeper/c.dat"].Parts {
+		if _, err := service.Files().ByName(part.StorageName); err != nil {
+			t.Errorf("part removed: %v", err)
+		}
+	}
+
+	// TEST: prune
+	if _, err := core.PruneSnapshots(service, core.KeepPolicy{}, false, impl.DebugOff); err == nil {
+		t.Error("no error (no keep rule)")
+	}
+	removed, err := core.PruneSnapshots(service, core.KeepPolicy{Last: 1}, true, impl.DebugOff)
+	if err != nil || len(removed) != 1 || len(core.Snapshots(service)) != 2 {
+		t.Fatalf("try: err=%v, removed=%v", err, removed)
+	}
+	removed, err = core.PruneSnapshots(service, core.KeepPolicy{Last: 1}, false, impl.DebugOff)
+	if err != nil || len(removed) != 1 || removed[0].Name() != list[0].Name() {
+		t.Fatalf("err=%v, removed=%v", err, removed)
+	}
+	_ = service.Update()
+	if list := core.Snapshots(service); len(list) != 1 || list[0].Name() != name {
+		t.Fatalf("wrong list: %v", list)
+	}
+
+	// TEST: the parts are removed with the last snapshot
+	if _, err := core.PruneSnapshots(service, core.KeepPolicy{Daily: 1}, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Trash(s.File)
+	_ = service.Update()
+	if err := core.Clean(newDb, nil, service, false, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	for _, part := range vDb.VFiles["sub/deeper/c.dat"].Parts {
+		if _, err := service.Files().ByName(part.StorageName); err == nil {
+			t.Errorf("part not removed: %s", part.StorageName)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package core
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/SchnorcherSepp/splitfs/db"
 	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/limit"
+	"github.com/SchnorcherSepp/splitfs/progress"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"io"
@@ -14,23 +18,61 @@ import (
 	"os"
 	"path"
 	"sort"
+	"sync"
 )
 
+// UploadOptions controls an upload (@see UploadWithOptions).
+// The zero value uploads one part after another and stops at the first error.
+type UploadOptions struct {
+	// Workers is the number of parts and bundles that are uploaded at the same time (0 or 1: sequential).
+	Workers int
+	// ContinueOnError uploads all other parts and bundles after a failed upload (default: stop at the first error).
+	ContinueOnError bool
+	// Journal saves the progress, so an interrupted upload can be resumed (optional, @see OpenJournal).
+	// The journal is not removed by the upload (@see Journal.Remove).
+	Journal *Journal
+	// Limits is the bandwidth limit (optional, each part or bundle is a connection).
+	Limits *limit.Limits
+	// Quota caps the uploaded bytes (optional, @see OpenQuota). If the quota is reached, no new parts are started
+	// and an index with the uploaded files is uploaded (@see QuotaError).
+	Quota *Quota
+	// Progress reports the uploaded, skipped and failed parts and bundles (optional, @see progress.StageUpload).
+	Progress *progress.Reporter
+}
+
 // Upload uploads all files that are defined in the database.
 // If bundles are created (in db), they are also uploaded (@see db.BundlePrefix).
 // Finally the database is also uploaded (@see IndexName).
 func Upload(rootPath string, vDB db.Db, dbKey []byte, service interf.Service, debugLvl uint8) error {
+	return UploadWithOptions(rootPath, vDB, dbKey, service, UploadOptions{}, debugLvl)
+}
+
+// UploadWithOptions uploads all files and bundles like Upload, but with a pool of workers (@see UploadOptions).
+// The database is only uploaded if all parts and bundles are uploaded successfully.
+// If the quota is reached, the database is uploaded with the online files only (changed files keep the version of the
+// last index, new files are missing) and a *QuotaError with this database is returned. The next run uploads the remaining files.
+func UploadWithOptions(rootPath string, vDB db.Db, dbKey []byte, service interf.Service, opts UploadOptions, debugLvl uint8) error {
 	// debug (0=off, 1=debug, 2=high)
 	debug := debugLvl >= impl.DebugLow
 
-	// update service list to prevent double uploads
+	// update service list to prevent double uploads (also on resume: partial files and the last index are needed)
 	if err := service.Update(); err != nil {
 		log.Printf("ERROR: %s/Upload: update file list: %v", packageName, err)
 		return err
 	}
+	if debug && opts.Journal != nil && opts.Journal.Resumed() > 0 {
+		log.Printf("DEBUG: %s/Upload: resume upload (%d finished parts)", packageName, opts.Journal.Resumed())
+	}
+	// remove partial files of the previous run
+	if opts.Journal != nil {
+		if err := cleanPartial(opts.Journal, service, debug); err != nil {
+			return err
+		}
+	}
 	// upload part list
 	// saves all uploaded parts to prevent double uploads
-	var uploadedParts = make(map[string]db.VFilePart)
+	uploadedParts := newUploadedParts(opts.Journal, opts.Quota)
+	opts.Quota.begin()
 
 	// upload all vFiles
 	//-------------------------
@@ -43,18 +85,59 @@ func Upload(rootPath string, vDB db.Db, dbKey []byte, service interf.Service, de
 	sort.Slice(list, func(i, j int) bool {
 		return list[i].RelPath < list[j].RelPath
 	})
-	// upload
+	// one task per part (skip folder and zero files)
+	tasks := make([]func() error, 0, len(list))
+	totalBytes := int64(0)
 	for _, vFile := range list {
-		if err := uploadFile(path.Join(rootPath, vFile.RelPath), vFile, service, uploadedParts, debug); err != nil {
-			return err
+		if vFile.IsDir || vFile.FileSize <= 0 {
+			continue
+		}
+		for partNo := range vFile.Parts {
+			vFile, partNo := vFile, partNo
+			totalBytes += vFile.Parts[partNo].StorageSize
+			tasks = append(tasks, func() error {
+				return uploadFile(path.Join(rootPath, vFile.RelPath), vFile, partNo, service, uploadedParts, opts.Limits, opts.Progress, debug)
+			})
 		}
 	}
 
 	// upload bundles (OPTIONAL)
-	if err := uploadBundle(rootPath, vDB, service, uploadedParts, debug); err != nil {
+	bundles := make([]string, 0, len(vDB.Bundles))
+	for k := range vDB.Bundles {
+		bundles = append(bundles, k)
+	}
+	sort.Strings(bundles)
+	for _, k := range bundles {
+		bundle := vDB.Bundles[k]
+		totalBytes += bundle.StorageSize
+		tasks = append(tasks, func() error {
+			return uploadBundle(rootPath, vDB, bundle, service, uploadedParts, opts.Limits, opts.Progress, debug)
+		})
+	}
+
+	// upload parts and bundles (workers)
+	opts.Progress.Start(progress.StageUpload, int64(len(tasks)), totalBytes)
+	err := runUploads(tasks, opts)
+	opts.Progress.Finish()
+	if err != nil {
+		log.Printf("ERROR: %s/Upload: %v", packageName, err)
 		return err
 	}
 
+	// quota reached: only the files that are online (changed files: previous version from the last index)
+	if opts.Quota.Reached() {
+		oldDb, _, err := LoadIndex(service, IndexName, dbKey)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("ERROR: %s/Upload: load index: %v", packageName, err)
+			return err
+		}
+		onlineDB, missing := onlineDb(vDB, oldDb, uploadedParts.skipped)
+		if err := uploadDb(onlineDB, dbKey, service, debug); err != nil {
+			return err
+		}
+		return &QuotaError{Db: onlineDB, Missing: missing, Skipped: len(uploadedParts.skipped)}
+	}
+
 	// upload db file
 	if err := uploadDb(vDB, dbKey, service, debug); err != nil {
 		return err
@@ -66,31 +149,144 @@ func Upload(rootPath string, vDB db.Db, dbKey []byte, service interf.Service, de
 
 // ----------  HELPER  -----------------------------------------------------------------------------------------------//
 
-// exists checks whether a file exists on storage.
-func exists(part db.VFilePart, service interf.Service, uploadedParts map[string]db.VFilePart) bool {
+// _UploadedParts is the list of all parts that are uploaded or exist on storage (thread-safe).
+type _UploadedParts struct {
+	mux     *sync.Mutex
+	parts   map[string]bool // key: @see partKey
+	skipped map[string]bool // not uploaded (quota reached)
+	journal *Journal        // optional
+	quota   *Quota          // optional
+}
+
+// newUploadedParts returns an empty list (the journal and the quota are optional).
+func newUploadedParts(journal *Journal, quota *Quota) *_UploadedParts {
+	return &_UploadedParts{
+		mux:     new(sync.Mutex),
+		parts:   make(map[string]bool),
+		skipped: make(map[string]bool),
+		journal: journal,
+		quota:   quota,
+	}
+}
+
+// claim returns true, if the part must be uploaded by the caller.
+// Parts that exist on storage or are already claimed by another worker return false.
+func (u *_UploadedParts) claim(part db.VFilePart, service interf.Service) bool {
+	u.mux.Lock()
+	defer u.mux.Unlock()
 
 	// check local list
-	for _, up := range uploadedParts {
-		if part.StorageName == up.StorageName && part.StorageSize == up.StorageSize && part.StorageMd5 == up.StorageMd5 {
-			return true // part exist
-		}
+	key := partKey(part)
+	if u.parts[key] {
+		return false // part exist (or upload in progress)
+	}
+	u.parts[key] = true
+
+	// check journal
+	if u.journal != nil && u.journal.isDone(key) {
+		return false // part uploaded by a previous run
 	}
 
 	// check service
 	_, err := service.Files().ByAttr(part.StorageName, part.StorageSize, part.StorageMd5)
-	if err == nil {
-		return true // part exist
-	}
+	return err != nil // err: part not found
+}
 
-	// part not found
+// reserve returns true, if the claimed part can be uploaded (@see Quota).
+// If the quota is reached, the part is skipped and uploaded by the next run.
+func (u *_UploadedParts) reserve(part db.VFilePart) bool {
+	if u.quota.reserve(part.StorageSize) {
+		return true
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.skipped[partKey(part)] = true
 	return false
 }
 
-// seek sets the offset for the next Read on file to the part start
-func seek(fh *os.File, partNo int) (int64, error) {
-	// calc offset
-	offset := int64(partNo) * db.PartSize
+// release returns the reserved quota, if the part was not uploaded (error), and finishes the progress task.
+func (u *_UploadedParts) release(part db.VFilePart, task *progress.Task, uploaded *bool) {
+	if !*uploaded {
+		u.quota.release(part.StorageSize)
+		task.Done(progress.Failed)
+	} else {
+		task.Done(progress.Uploaded)
+	}
+}
+
+// started writes the start of an upload to the journal (if set).
+func (u *_UploadedParts) started(part db.VFilePart) error {
+	if u.journal == nil {
+		return nil
+	}
+	return u.journal.start(partKey(part))
+}
+
+// finished writes a finished upload to the journal and the quota history (if set).
+func (u *_UploadedParts) finished(part db.VFilePart) error {
+	if err := u.quota.commit(part.StorageSize); err != nil {
+		return err
+	}
+	if u.journal == nil {
+		return nil
+	}
+	return u.journal.finish(partKey(part))
+}
+
+// runUploads runs all tasks with a pool of workers (@see UploadOptions).
+// Without ContinueOnError, no new tasks are started after an error and the first error is returned.
+func runUploads(tasks []func() error, opts UploadOptions) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	var (
+		next     = make(chan func() error)
+		mux      sync.Mutex
+		firstErr error
+		failed   int
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range next {
+				if err := task(); err != nil {
+					mux.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					failed++
+					mux.Unlock()
+				}
+			}
+		}()
+	}
+	for _, task := range tasks {
+		mux.Lock()
+		stop := firstErr != nil && !opts.ContinueOnError
+		mux.Unlock()
+		if stop {
+			break
+		}
+		next <- task
+	}
+	close(next)
+	wg.Wait()
+
+	if failed > 1 {
+		return fmt.Errorf("%d uploads failed, first error: %v", failed, firstErr)
+	}
+	return firstErr
+}
 
+// seek sets the offset for the next Read on file to the part start
+func seek(fh *os.File, offset int64) (int64, error) {
 	// seek
 	o, err := fh.Seek(offset, 0)
 	if err != nil {
@@ -106,24 +302,26 @@ func seek(fh *os.File, partNo int) (int64, error) {
 	return offset, nil
 }
 
-// uploadPart uploads a part.
+// uploadPart uploads a part (at most n plain bytes at the offset off, @see db.VirtFile.PartRange).
 // Data are optionally compressed.
 // Data are encrypted.
-func uploadPart(fh *os.File, partNo int, useCompr bool, cryptKey []byte, storageName string, storageSize int64, service interf.Service) error {
+// The upload speed is limited (optional, limits can be nil).
+// The uploaded bytes are reported to the task (optional, task can be nil).
+func uploadPart(fh *os.File, off, n int64, useCompr bool, cryptKey []byte, storageName string, storageSize int64, service interf.Service, limits *limit.Limits, task *progress.Task) error {
 
 	// There is no second part with active compression!
-	if useCompr && partNo > 0 {
+	if useCompr && off > 0 {
 		return errors.New("can't compress second part")
 	}
 
 	// go to: part beginning
-	if _, err := seek(fh, partNo); err != nil {
+	if _, err := seek(fh, off); err != nil {
 		return err
 	}
 
 	// build reader
-	r := io.LimitReader(fh, db.PartSize) // file part (plain) reader
-	if useCompr {                        // compression reader
+	r := io.LimitReader(fh, n) // file part (plain) reader
+	if useCompr {              // compression reader
 		// read all bytes
 		b, err := ioutil.ReadAll(r)
 		if err != nil {
@@ -142,6 +340,8 @@ func uploadPart(fh *os.File, partNo int, useCompr bool, cryptKey []byte, storage
 		r = bytes.NewReader(b)
 	}
 	r = enc.CryptoReader(ioutil.NopCloser(r), 0, cryptKey) // encryption reader: encryption offset is 0 for each part
+	r = limits.Reader(r)                                   // bandwidth limit
+	r = task.Reader(r)                                     // progress
 
 	// upload
 	_, err := service.Save(storageName, r, 0)
@@ -153,14 +353,23 @@ func uploadPart(fh *os.File, partNo int, useCompr bool, cryptKey []byte, storage
 	return nil
 }
 
-// uploadFile uploads a whole file.
+// uploadFile uploads a part of a file.
 // Uses the uploadPart() function.
-// Skip folder and zero files.
-func uploadFile(absPath string, vFile db.VirtFile, service interf.Service, uploadedParts map[string]db.VFilePart, debug bool) error {
-	// skip folder and zero files
-	if vFile.IsDir || vFile.FileSize <= 0 {
-		return nil // do nothing
+// Skip parts that exist on storage or are uploaded by another worker.
+func uploadFile(absPath string, vFile db.VirtFile, partNo int, service interf.Service, uploadedParts *_UploadedParts, limits *limit.Limits, rep *progress.Reporter, debug bool) error {
+	part := vFile.Parts[partNo]
+	task := rep.Task(vFile.RelPath, part.StorageSize)
+	if !uploadedParts.claim(part, service) {
+		task.Done(progress.Skipped)
+		return nil // part exist
+	}
+
+	if !uploadedParts.reserve(part) {
+		task.Done(progress.Skipped)
+		return nil // quota reached (next run)
 	}
+	uploaded := false
+	defer uploadedParts.release(part, task, &uploaded)
 
 	// open file
 	fh, err := os.Open(absPath)
@@ -170,134 +379,194 @@ func uploadFile(absPath string, vFile db.VirtFile, service interf.Service, uploa
 	}
 	defer fh.Close()
 
-	// upload all parts
-	for partNo, part := range vFile.Parts {
-		if !exists(part, service, uploadedParts) {
-			// part not found -> upload
-			if debug {
-				if vFile.UseCompression {
-					sizeInKb := float64(part.StorageSize) / 1024
-					log.Printf("DEBUG: %s/uploadFile: part %d from '%s' (%.2f kB, compressed)", packageName, partNo, vFile.RelPath, sizeInKb)
-				} else {
-					sizeInMb := float64(part.StorageSize) / (1024 * 1024)
-					log.Printf("DEBUG: %s/uploadFile: part %d from '%s' (%.2f MB)", packageName, partNo, vFile.RelPath, sizeInMb)
-				}
-			}
-			// upload
-			if err := uploadPart(fh, partNo, vFile.UseCompression, part.CryptDataKey, part.StorageName, part.StorageSize, service); err != nil {
-				log.Printf("ERROR: %s/uploadFile: part %d from '%s': %v", packageName, partNo, vFile.RelPath, err)
-				return err
-			}
-			// add to uploadedParts
-			uploadedParts[part.StorageName+"|"+part.StorageMd5] = part
+	// part not found -> upload
+	if debug {
+		if vFile.UseCompression {
+			sizeInKb := float64(part.StorageSize) / 1024
+			log.Printf("DEBUG: %s/uploadFile: part %d from '%s' (%.2f kB, compressed)", packageName, partNo, vFile.RelPath, sizeInKb)
+		} else {
+			sizeInMb := float64(part.StorageSize) / (1024 * 1024)
+			log.Printf("DEBUG: %s/uploadFile: part %d from '%s' (%.2f MB)", packageName, partNo, vFile.RelPath, sizeInMb)
 		}
 	}
+	// upload
+	if err := uploadedParts.started(part); err != nil {
+		log.Printf("ERROR: %s/uploadFile: journal: %v", packageName, err)
+		return err
+	}
+	off, n := vFile.PartRange(partNo)
+	if err := uploadPart(fh, off, n, vFile.UseCompression, part.CryptDataKey, part.StorageName, part.StorageSize, service, limits, task); err != nil {
+		log.Printf("ERROR: %s/uploadFile: part %d from '%s': %v", packageName, partNo, vFile.RelPath, err)
+		return err
+	}
+	uploaded = true
+	if err := uploadedParts.finished(part); err != nil {
+		log.Printf("ERROR: %s/uploadFile: journal: %v", packageName, err)
+		return err
+	}
 
 	// success
 	return nil
 }
 
-// uploadDb remove all files with the name IndexName and upload the new db file.
+// uploadDb uploads the new db file and removes all older files with the name IndexName (@see uploadIndex).
 func uploadDb(newDb db.Db, indexKey []byte, service interf.Service, debug bool) error {
-	if debug {
-		log.Printf("DEBUG: %s/uploadDb: new db with %d elements and %d bundles", packageName, len(newDb.VFiles), len(newDb.Bundles))
-	}
+	return uploadIndex(newDb, IndexName, indexKey, service, debug)
+}
 
-	// first: remove all old DBs
-	for _, f := range service.Files().All() {
-		if f.Name() == IndexName {
-			if err := service.Trash(f); err != nil {
-				log.Printf("ERROR: %s/uploadDb: remove old db '%s': %v", packageName, f.Id(), err)
-				return err
-			}
-		}
+// uploadIndex uploads the new db file with the given name and removes all older files with this name.
+// The new file is read back and checked (size and md5) before the old files are removed, so there is always a
+// valid index in the storage, even if the upload fails or the process dies (@see LoadIndex).
+func uploadIndex(newDb db.Db, name string, indexKey []byte, service interf.Service, debug bool) error {
+	if debug {
+		log.Printf("DEBUG: %s/uploadDb: new db '%s' with %d elements and %d bundles", packageName, name, len(newDb.VFiles), len(newDb.Bundles))
 	}
 
-	// secondly: upload new db
+	// first: upload new db
 	buf := bytes.NewBuffer(make([]byte, 0))
 	if err := db.ToWriter(newDb, indexKey, buf); err != nil {
 		log.Printf("ERROR: %s/uploadDb: save db #1: %v", packageName, err)
 		return err
 	}
-	if _, err := service.Save(IndexName, buf, 0); err != nil {
+	size := int64(buf.Len())
+	sum := md5.Sum(buf.Bytes())
+	f, err := service.Save(name, buf, 0)
+	if err != nil {
 		log.Printf("ERROR: %s/uploadDb: save db #2: %v", packageName, err)
 		return err
 	}
 
+	// secondly: verify new db (read back)
+	if err := checkIndex(f, size, hex.EncodeToString(sum[:]), service); err != nil {
+		log.Printf("ERROR: %s/uploadDb: verify db '%s': %v", packageName, f.Id(), err)
+		_ = service.Trash(f) // the old DBs are still valid
+		return err
+	}
+
+	// finally: remove all other DBs (also copies from other clients that are not in the old file list)
+	// the order of copies with the same modification time is not defined by the upload (@see IndexFiles)
+	if err := service.Update(); err != nil {
+		log.Printf("ERROR: %s/uploadDb: update file list: %v", packageName, err)
+		return err
+	}
+	for _, o := range IndexFiles(service, name) {
+		if o.Id() == f.Id() {
+			continue
+		}
+		if err := service.Trash(o); err != nil {
+			// the new db is valid, but an old copy could still be loaded as the newest db
+			log.Printf("ERROR: %s/uploadDb: remove old db '%s': %v", packageName, o.Id(), err)
+			return err
+		}
+	}
+
 	// success
 	return nil
 }
 
-// uploadBundle uploads the bundles from the database. The function is RAM intensive.
-func uploadBundle(rootPath string, vDB db.Db, service interf.Service, uploadedParts map[string]db.VFilePart, debug bool) error {
+// checkIndex reads the uploaded db file and compares size and md5.
+func checkIndex(f interf.File, size int64, md5Str string, service interf.Service) error {
+	if f.Size() != size || f.Md5() != md5Str {
+		return fmt.Errorf("storage file is [%d, %s], not [%d, %s]", f.Size(), f.Md5(), size, md5Str)
+	}
 
-	// upload bundles (only, if set)
-	for _, bundle := range vDB.Bundles {
+	r, err := service.Reader(f, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close() // CLOSE
 
-		// part exist -> skip
-		if exists(bundle.VFilePart, service, uploadedParts) {
-			continue
-		}
+	h := md5.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if n != size || hex.EncodeToString(h.Sum(nil)) != md5Str {
+		return fmt.Errorf("read back [%d, %x], not [%d, %s]", n, h.Sum(nil), size, md5Str)
+	}
+	return nil
+}
 
-		// UPLOAD: build bundle in ram
-		var data = make([]byte, 0)
-		for _, vFileId := range bundle.Content {
-			// small singe file
-			vFile := vDB.VFiles[vFileId]
-			absPath := path.Join(rootPath, vFile.RelPath)
-			// check path
-			if len(vFile.Parts) != 1 {
-				e := errors.New("wrong part count for a bundle element")
-				log.Printf("ERROR: %s/uploadBundle: %v: len=%d, f=%s", packageName, e, len(vFile.Parts), vFile.RelPath)
-				return e
-			}
-			part := vFile.Parts[0]
-			// read all bytes
-			b, err := ioutil.ReadFile(absPath)
+// uploadBundle uploads a bundle from the database. The function is RAM intensive (the whole bundle is built in RAM).
+func uploadBundle(rootPath string, vDB db.Db, bundle db.Bundle, service interf.Service, uploadedParts *_UploadedParts, limits *limit.Limits, rep *progress.Reporter, debug bool) error {
+	task := rep.Task(bundle.StorageName, bundle.StorageSize)
+
+	// part exist -> skip
+	if !uploadedParts.claim(bundle.VFilePart, service) {
+		task.Done(progress.Skipped)
+		return nil
+	}
+	if !uploadedParts.reserve(bundle.VFilePart) {
+		task.Done(progress.Skipped)
+		return nil // quota reached (next run)
+	}
+	uploaded := false
+	defer uploadedParts.release(bundle.VFilePart, task, &uploaded)
+
+	// UPLOAD: build bundle in ram
+	var data = make([]byte, 0)
+	for _, vFileId := range bundle.Content {
+		// small singe file
+		vFile := vDB.VFiles[vFileId]
+		absPath := path.Join(rootPath, vFile.RelPath)
+		// check path
+		if len(vFile.Parts) != 1 {
+			e := errors.New("wrong part count for a bundle element")
+			log.Printf("ERROR: %s/uploadBundle: %v: len=%d, f=%s", packageName, e, len(vFile.Parts), vFile.RelPath)
+			return e
+		}
+		part := vFile.Parts[0]
+		// read all bytes
+		b, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			log.Printf("ERROR: %s/uploadBundle: %v", packageName, err)
+			return err
+		}
+		// use compression (optional)
+		if vFile.UseCompression {
+			b, _, err = enc.Compress(b)
 			if err != nil {
 				log.Printf("ERROR: %s/uploadBundle: %v", packageName, err)
 				return err
 			}
-			// use compression (optional)
-			if vFile.UseCompression {
-				b, _, err = enc.Compress(b)
-				if err != nil {
-					log.Printf("ERROR: %s/uploadBundle: %v", packageName, err)
-					return err
-				}
-			}
-			// check size
-			if int64(len(b)) != part.StorageSize {
-				e := errors.New("part does not have the specified StorageSize")
-				log.Printf("ERROR: %s/uploadBundle: %v: is:%d != db:%d; '%s'", packageName, e, len(b), part.StorageSize, vFile.RelPath)
-				return e
-			}
-			// add crypt data
-			enc.CryptBytes(b, int64(len(data)), bundle.CryptDataKey)
-			data = append(data, b...)
 		}
-
-		// check bundle
-		if int64(len(data)) != bundle.StorageSize {
-			e := errors.New("bundle does not have the specified StorageSize")
-			log.Printf("ERROR: %s/uploadBundle: %v: is=%d, ss=%d", packageName, e, int64(len(data)), bundle.StorageSize)
+		// check size
+		if int64(len(b)) != part.StorageSize {
+			e := errors.New("part does not have the specified StorageSize")
+			log.Printf("ERROR: %s/uploadBundle: %v: is:%d != db:%d; '%s'", packageName, e, len(b), part.StorageSize, vFile.RelPath)
 			return e
 		}
+		// add crypt data
+		enc.CryptBytes(b, int64(len(data)), bundle.CryptDataKey)
+		data = append(data, b...)
+	}
 
-		// debug
-		if debug {
-			sizeInMb := float64(bundle.StorageSize) / (1024 * 1024)
-			log.Printf("DEBUG: %s/uploadBundle: %s ... %d files, %d (%.0f MB)", packageName, bundle.StorageName, len(bundle.Content), bundle.StorageSize, sizeInMb)
-		}
+	// check bundle
+	if int64(len(data)) != bundle.StorageSize {
+		e := errors.New("bundle does not have the specified StorageSize")
+		log.Printf("ERROR: %s/uploadBundle: %v: is=%d, ss=%d", packageName, e, int64(len(data)), bundle.StorageSize)
+		return e
+	}
 
-		// upload
-		_, err := service.Save(bundle.StorageName, bytes.NewReader(data), 0)
-		if err != nil {
-			log.Printf("ERROR: %s/uploadBundle: %v", packageName, err)
-			return err
-		}
-		// add to uploadedParts
-		uploadedParts[bundle.StorageName+"#"+fmt.Sprintf("%d", bundle.StorageSize)] = bundle.VFilePart
+	// debug
+	if debug {
+		sizeInMb := float64(bundle.StorageSize) / (1024 * 1024)
+		log.Printf("DEBUG: %s/uploadBundle: %s ... %d files, %d (%.0f MB)", packageName, bundle.StorageName, len(bundle.Content), bundle.StorageSize, sizeInMb)
+	}
+
+	// upload
+	if err := uploadedParts.started(bundle.VFilePart); err != nil {
+		log.Printf("ERROR: %s/uploadBundle: journal: %v", packageName, err)
+		return err
+	}
+	if _, err := service.Save(bundle.StorageName, task.Reader(limits.Reader(bytes.NewReader(data))), 0); err != nil {
+		log.Printf("ERROR: %s/uploadBundle: %v", packageName, err)
+		return err
+	}
+	uploaded = true
+	if err := uploadedParts.finished(bundle.VFilePart); err != nil {
+		log.Printf("ERROR: %s/uploadBundle: journal: %v", packageName, err)
+		return err
 	}
 
 	// success
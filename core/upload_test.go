@@ -4,17 +4,21 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"github.com/SchnorcherSepp/splitfs/core"
 	"github.com/SchnorcherSepp/splitfs/db"
 	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/progress"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -231,3 +235,94 @@ func TestUpload(t *testing.T) {
 		t.Errorf("wrong storage hash for part 1: %s", sh)
 	}
 }
+
+// countService counts all saved files and fails for one storage name.
+type countService struct {
+	interf.Service
+	fail  string
+	mux   sync.Mutex
+	saved map[string]int
+}
+
+func (s *countService) Save(name string, r io.Reader, max int64) (interf.File, error) {
+	s.mux.Lock()
+	s.saved[name]++
+	s.mux.Unlock()
+	if name == s.fail {
+		return nil, errors.New("test error")
+	}
+	return s.Service.Save(name, r, max)
+}
+
+func TestUploadWithOptions(t *testing.T) {
+	rootPath, vDb, _ := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+	key := testUploadKeyFile.IndexKey()
+
+	// same content in two files (one upload)
+	b, _ := ioutil.ReadFile(path.Join(rootPath, "sub", "deeper", "c.dat"))
+	_ = ioutil.WriteFile(path.Join(rootPath, "dup.dat"), b, 0600)
+	vDb, _, _, err := db.FromScan(rootPath, vDb, impl.DebugOff, testUploadKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vDb.MakeBundles(testUploadKeyFile, impl.DebugOff)
+	service := impl.NewRamService(nil, impl.DebugOff)
+	if err := core.Upload(rootPath, vDb, key, service, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	want := make(map[string]string)
+	for _, f := range service.Files().All() {
+		if f.Name() != core.IndexName {
+			want[f.Name()] = f.Md5()
+		}
+	}
+
+	// TEST: parallel upload (same storage files, each file only once)
+	var last progress.Event
+	rep := progress.New(func(e progress.Event) { last = e })
+	fs := &countService{Service: impl.NewRamService(nil, impl.DebugOff), saved: make(map[string]int)}
+	if err := core.UploadWithOptions(rootPath, vDb, key, fs, core.UploadOptions{Workers: 4, Progress: rep}, impl.DebugOff); err != nil {
+		t.Fatal(err)
+	}
+	if last.Kind != progress.Done || last.Items != last.TotalItems || last.Items-last.Skipped != int64(len(want)) || last.Failed != 0 {
+		t.Errorf("wrong progress: %+v", last)
+	}
+	_ = fs.Update()
+	for _, f := range fs.Files().All() {
+		if f.Name() != core.IndexName && want[f.Name()] != f.Md5() {
+			t.Errorf("wrong file: %s", f.Name())
+		}
+	}
+	if len(fs.Files().All()) != len(want)+1 {
+		t.Errorf("wrong file count: %d != %d", len(fs.Files().All()), len(want)+1)
+	}
+	for name, n := range fs.saved {
+		if n != 1 {
+			t.Errorf("%dx saved: %s", n, name)
+		}
+	}
+
+	// TEST: fail-fast and continue-on-error (no index)
+	for _, opts := range []core.UploadOptions{{Workers: 1}, {Workers: 3, ContinueOnError: true}} {
+		fs := &countService{Service: impl.NewRamService(nil, impl.DebugOff), saved: make(map[string]int)}
+		for name := range want {
+			if fs.fail == "" || name < fs.fail {
+				fs.fail = name
+			}
+		}
+		if err := core.UploadWithOptions(rootPath, vDb, key, fs, opts, impl.DebugOff); err == nil {
+			t.Fatal("no error")
+		}
+		if fs.saved[core.IndexName] != 0 {
+			t.Errorf("index uploaded: %+v", opts)
+		}
+		if opts.ContinueOnError && len(fs.saved) != len(want) {
+			t.Errorf("continue-on-error: %d != %d", len(fs.saved), len(want))
+		}
+		if !opts.ContinueOnError && len(fs.saved) == len(want) {
+			t.Errorf("fail-fast: all files uploaded")
+		}
+	}
+}
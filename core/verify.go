@@ -0,0 +1,283 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+// Problem types of the verify report (@see VerifyProblem.Status).
+const (
+	VerifyMissing = "missing"       // the storage file does not exist
+	VerifySize    = "size-mismatch" // a storage file with this name exists, but with the wrong size
+	VerifyCorrupt = "corrupt"       // the storage file has the wrong md5 or the plain content has the wrong hash
+)
+
+// VerifyReport is the result of Verify(). It can be serialized to JSON.
+type VerifyReport struct {
+	Deep     bool            `json:"deep"`     // content check (download all parts)
+	Parts    int             `json:"parts"`    // number of checked parts
+	Bundles  int             `json:"bundles"`  // number of checked bundles
+	Problems []VerifyProblem `json:"problems"` // all found problems (sorted by StorageName)
+}
+
+// VerifyProblem describes a single storage file (part or bundle) with a problem.
+type VerifyProblem struct {
+	StorageName string   `json:"storageName"`
+	StorageSize int64    `json:"storageSize"`
+	StorageMd5  string   `json:"storageMd5"`
+	Bundle      bool     `json:"bundle"`
+	Status      string   `json:"status"` // @see VerifyMissing, VerifySize and VerifyCorrupt
+	Detail      string   `json:"detail"`
+	Files       []string `json:"files"` // affected files (RelPath)
+}
+
+// Ok returns true if no problems were found.
+func (r *VerifyReport) Ok() bool {
+	return len(r.Problems) == 0
+}
+
+// errContent marks a decompression error. The storage data was read, but the content is invalid.
+var errContent = errors.New("decompression failed")
+
+// verifyItem is a storage file (part or bundle) that is checked by Verify().
+type verifyItem struct {
+	part           db.VFilePart
+	bundle         *db.Bundle
+	useCompression bool
+	files          []string
+}
+
+// Verify audits the storage against the database.
+//
+// The quick check (deep=false) uses the service file list (offline) and checks that all
+// parts and bundles exist with the right size and md5 (@see interf.Files.ByAttr).
+// The deep check (deep=true) also downloads all storage files, decrypts and decompresses the
+// content and compares the plain SHA512 hashes with the database.
+func Verify(vDb db.Db, service interf.Service, deep bool, debugLvl uint8) (VerifyReport, error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+	report := VerifyReport{Deep: deep, Problems: make([]VerifyProblem, 0)}
+
+	// nil check
+	if service == nil {
+		return report, errors.New("service is nil")
+	}
+	if vDb.VFiles == nil {
+		return report, errors.New("db is nil")
+	}
+
+	// CHECK
+	items := verifyItems(vDb)
+	for i, item := range items {
+		if item.bundle != nil {
+			report.Bundles++
+		} else {
+			report.Parts++
+		}
+
+		// debug
+		if debug {
+			sizeInMb := float64(item.part.StorageSize) / (1024 * 1024)
+			log.Printf("DEBUG: %s/Verify: [%d/%d] %s (%.2f MB)", packageName, i+1, len(items), item.part.StorageName, sizeInMb)
+		}
+
+		// check
+		status, detail, files := verifyItemCheck(item, vDb, service, deep)
+		if status != "" {
+			log.Printf("WARNING: %s/Verify: %s: %s: %s", packageName, status, item.part.StorageName, detail)
+			report.Problems = append(report.Problems, VerifyProblem{
+				StorageName: item.part.StorageName,
+				StorageSize: item.part.StorageSize,
+				StorageMd5:  item.part.StorageMd5,
+				Bundle:      item.bundle != nil,
+				Status:      status,
+				Detail:      detail,
+				Files:       files,
+			})
+		}
+	}
+
+	// success
+	return report, nil
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// verifyItems returns all parts (without duplicates) and bundles from the database (sorted by StorageName).
+func verifyItems(vDb db.Db) []verifyItem {
+	itemMap := make(map[string]*verifyItem)
+
+	// get all file parts
+	for _, vFile := range vDb.VFiles {
+		for _, part := range vFile.Parts {
+			key := part.StorageName + "|" + part.StorageMd5
+			item, ok := itemMap[key]
+			if !ok {
+				item = &verifyItem{part: part, useCompression: vFile.UseCompression}
+				itemMap[key] = item
+			}
+			item.files = append(item.files, vFile.RelPath)
+		}
+	}
+
+	// get all bundles
+	for _, bundle := range vDb.Bundles {
+		b := bundle
+		itemMap[b.StorageName+"|"+b.StorageMd5] = &verifyItem{part: b.VFilePart, bundle: &b, files: b.Content}
+	}
+
+	// return sorted list
+	list := make([]verifyItem, 0, len(itemMap))
+	for _, item := range itemMap {
+		sort.Strings(item.files)
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].part.StorageName < list[j].part.StorageName
+	})
+	return list
+}
+
+// verifyItemCheck checks a single part or bundle.
+// It returns an empty status if no problems were found.
+func verifyItemCheck(item verifyItem, vDb db.Db, service interf.Service, deep bool) (status, detail string, files []string) {
+	part := item.part
+
+	// quick check: name, size and md5
+	sf, err := service.Files().ByAttr(part.StorageName, part.StorageSize, part.StorageMd5)
+	if err != nil {
+		status, detail = VerifyMissing, "storage file not found"
+		for _, f := range service.Files().All() {
+			if f.Name() != part.StorageName {
+				continue
+			}
+			if f.Size() != part.StorageSize {
+				status, detail = VerifySize, fmt.Sprintf("size is %d", f.Size())
+			} else {
+				status, detail = VerifyCorrupt, fmt.Sprintf("md5 is %s", f.Md5())
+				break
+			}
+		}
+		return status, detail, item.files
+	}
+	if !deep {
+		return "", "", nil // OK
+	}
+
+	// deep check: content
+	if item.bundle != nil {
+		files, err = checkBundleContent(sf, *item.bundle, vDb, service)
+	} else {
+		err = checkPartContent(sf, part, item.useCompression, service)
+		files = item.files
+	}
+	if err != nil {
+		return VerifyCorrupt, err.Error(), files
+	}
+	return "", "", nil // OK
+}
+
+// checkPartContent downloads and decrypts a part and compares the plain hash.
+func checkPartContent(sf interf.File, part db.VFilePart, useCompression bool, service interf.Service) error {
+	// open reader
+	r, err := service.Reader(sf, 0)
+	if err != nil {
+		return fmt.Errorf("read error: %v", err)
+	}
+	defer r.Close()
+
+	// hash encrypted data (md5) and decrypt
+	md5Hash := md5.New()
+	plain := enc.CryptoReader(ioutil.NopCloser(io.TeeReader(r, md5Hash)), 0, part.CryptDataKey)
+
+	// check
+	h, err := plainHash(plain, part.StorageSize, useCompression)
+	if err != nil {
+		return fmt.Errorf("read error: %v", err)
+	}
+	if part.StorageMd5 != "" && fmt.Sprintf("%x", md5Hash.Sum(nil)) != part.StorageMd5 {
+		return errors.New("md5 mismatch (downloaded data)")
+	}
+	if !bytes.Equal(h, part.PlainSHA512) {
+		return errors.New("plain hash mismatch")
+	}
+	return nil
+}
+
+// checkBundleContent downloads and decrypts a bundle and compares the plain hash of all bundle elements.
+// It returns the files with invalid content.
+func checkBundleContent(sf interf.File, bundle db.Bundle, vDb db.Db, service interf.Service) ([]string, error) {
+	// open reader
+	r, err := service.Reader(sf, 0)
+	if err != nil {
+		return bundle.Content, fmt.Errorf("read error: %v", err)
+	}
+	defer r.Close()
+	plain := enc.CryptoReader(r, 0, bundle.CryptDataKey)
+
+	// check all bundle elements
+	badFiles := make([]string, 0)
+	for _, vFileId := range bundle.Content {
+		vFile, ok := vDb.VFiles[vFileId]
+		if !ok || len(vFile.Parts) != 1 {
+			return bundle.Content, errors.New("bundle content link error")
+		}
+		part := vFile.Parts[0]
+
+		h, err := plainHash(plain, part.StorageSize, vFile.UseCompression)
+		if err != nil && err != errContent {
+			return bundle.Content, fmt.Errorf("read error: %v", err)
+		}
+		if !bytes.Equal(h, part.PlainSHA512) {
+			badFiles = append(badFiles, vFile.RelPath)
+		}
+	}
+
+	// result
+	if len(badFiles) > 0 {
+		sort.Strings(badFiles)
+		return badFiles, fmt.Errorf("plain hash mismatch (%d of %d files)", len(badFiles), len(bundle.Content))
+	}
+	return nil, nil
+}
+
+// plainHash reads n bytes (storage data), decompresses them (optional) and returns the SHA512 hash.
+// If the data can't be decompressed, errContent is returned.
+func plainHash(r io.Reader, n int64, useCompression bool) ([]byte, error) {
+	hh := sha512.New()
+
+	if useCompression {
+		// compressed data are small (@see db.MaxFileSizeForCompression)
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		b, err := enc.Decompress(b)
+		if err != nil {
+			return nil, errContent
+		}
+		hh.Write(b)
+	} else {
+		// stream big parts
+		m, err := io.Copy(hh, io.LimitReader(r, n))
+		if err != nil {
+			return nil, err
+		}
+		if m != n {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+
+	return hh.Sum(nil), nil
+}
@@ -0,0 +1,95 @@
+package core_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	rootPath, vDb, service := initRestoreTest(t)
+	defer os.RemoveAll(rootPath)
+
+	// TEST: nil
+	if _, err := core.Verify(vDb, nil, false, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: quick and deep without problems
+	for _, deep := range []bool{false, true} {
+		report, err := core.Verify(vDb, service, deep, impl.DebugOff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() || report.Parts != 6 || report.Bundles != 1 {
+			t.Fatalf("deep=%v: %#v", deep, report)
+		}
+	}
+
+	// TEST: deep check with wrong plain hash (quick check is ok)
+	vFile := vDb.VFiles["sub/a.dat"]
+	badDb := db.NewDb()
+	badDb.VFiles["sub/a.dat"] = db.VirtFile{
+		RelPath:  vFile.RelPath,
+		FileSize: vFile.FileSize,
+		Parts: []db.VFilePart{{
+			PlainSHA512:  make([]byte, 64),
+			StorageName:  vFile.Parts[0].StorageName,
+			StorageSize:  vFile.Parts[0].StorageSize,
+			StorageMd5:   vFile.Parts[0].StorageMd5,
+			CryptDataKey: vFile.Parts[0].CryptDataKey,
+		}},
+	}
+	report, _ := core.Verify(badDb, service, false, impl.DebugOff)
+	if !report.Ok() {
+		t.Fatalf("%#v", report)
+	}
+	report, _ = core.Verify(badDb, service, true, impl.DebugOff)
+	if len(report.Problems) != 1 || report.Problems[0].Status != core.VerifyCorrupt || report.Problems[0].Files[0] != "sub/a.dat" {
+		t.Fatalf("%#v", report)
+	}
+
+	// TEST: missing, size-mismatch and corrupt parts
+	missing := vDb.VFiles["sub/a.dat"].Parts[0]
+	size := vDb.VFiles["sub/b.dat"].Parts[0]
+	corrupt := vDb.VFiles["text.txt"].Parts[0]
+	for _, f := range service.Files().All() {
+		switch f.Name() {
+		case missing.StorageName:
+			_ = service.Trash(f)
+		case size.StorageName:
+			_ = service.Trash(f)
+			_, _ = service.Save(f.Name(), bytes.NewReader(make([]byte, f.Size()+1)), 0)
+		case corrupt.StorageName:
+			_ = service.Trash(f)
+			_, _ = service.Save(f.Name(), bytes.NewReader(make([]byte, f.Size())), 0)
+		}
+	}
+	_ = service.Update()
+
+	report, _ = core.Verify(vDb, service, false, impl.DebugOff)
+	if len(report.Problems) != 3 {
+		t.Fatalf("%#v", report)
+	}
+	for _, p := range report.Problems {
+		switch p.StorageName {
+		case missing.StorageName:
+			if p.Status != core.VerifyMissing || p.Files[0] != "sub/a.dat" {
+				t.Errorf("%#v", p)
+			}
+		case size.StorageName:
+			if p.Status != core.VerifySize || p.Files[0] != "sub/b.dat" {
+				t.Errorf("%#v", p)
+			}
+		case corrupt.StorageName:
+			if p.Status != core.VerifyCorrupt || p.Files[0] != "text.txt" {
+				t.Errorf("%#v", p)
+			}
+		default:
+			t.Errorf("unknown problem: %#v", p)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"crypto/sha512"
 	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/progress"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	"log"
 	"sort"
@@ -11,6 +12,12 @@ import (
 // MakeBundles set bundles in the DB.
 // This function changes the database!
 func (db *Db) MakeBundles(keyFile *enc.KeyFile, debugLvl uint8) {
+	db.MakeBundlesWithProgress(keyFile, nil, debugLvl)
+}
+
+// MakeBundlesWithProgress set bundles in the DB like MakeBundles and reports each bundle to rep (optional).
+// This function changes the database!
+func (db *Db) MakeBundlesWithProgress(keyFile *enc.KeyFile, rep *progress.Reporter, debugLvl uint8) {
 	// debug (0=off, 1=debug, 2=high)
 	debug := debugLvl >= impl.DebugLow
 
@@ -18,7 +25,18 @@ func (db *Db) MakeBundles(keyFile *enc.KeyFile, debugLvl uint8) {
 	resetOldBundles(db)
 
 	// groups: [][]VirtFile
-	for _, group := range findGroups(db, debug) {
+	groups := findGroups(db, debug)
+	if rep != nil {
+		total := int64(0)
+		for _, group := range groups {
+			for _, vFile := range group {
+				total += vFile.Parts[0].StorageSize
+			}
+		}
+		rep.Start(progress.StageBundle, int64(len(groups)), total)
+		defer rep.Finish()
+	}
+	for _, group := range groups {
 
 		// calc bundle PlainHash  (hash all PlainSHA512)
 		storageSize := int64(0)
@@ -54,6 +72,7 @@ func (db *Db) MakeBundles(keyFile *enc.KeyFile, debugLvl uint8) {
 			tmp.AlsoInBundle = bundle.Id()
 			db.VFiles[relPath] = tmp
 		}
+		rep.Item(progress.Bundled, bundle.StorageName, storageSize)
 	}
 }
 
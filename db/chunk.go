@@ -0,0 +1,172 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// ChunkOptions enables content-defined chunking (FastCDC) for large files (@see ScanOptions.Chunking).
+//
+// With fixed parts (@see PartSize), inserting a single byte at the start of a file shifts all parts and the whole file
+// is uploaded again. With content-defined chunking, the part boundaries depend on the content (rolling gear hash), so
+// only the parts around the changed regions are new. The parts are between Min and Max bytes and Avg bytes on average.
+// The zero value uses fixed parts.
+type ChunkOptions struct {
+	Min int64 // minimal part size (except the last part of a file)
+	Avg int64 // average part size
+	Max int64 // maximal part size (at most PartSize)
+}
+
+// Enabled returns true if content-defined chunking is used.
+func (c ChunkOptions) Enabled() bool {
+	return c != ChunkOptions{}
+}
+
+// Check returns an error for invalid sizes (Min <= Avg <= Max <= PartSize).
+func (c ChunkOptions) Check() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Min < chunkWindow || c.Min > c.Avg || c.Avg > c.Max || c.Max > PartSize {
+		return fmt.Errorf("invalid chunk sizes: min=%d, avg=%d, max=%d (%d <= min <= avg <= max <= %d)", c.Min, c.Avg, c.Max, chunkWindow, PartSize)
+	}
+	return nil
+}
+
+// use returns true if the file is split with content-defined chunking.
+// Small files (at most Max bytes) and compressed files have a single part.
+func (c ChunkOptions) use(fileSize int64, useCompression bool) bool {
+	return c.Enabled() && !useCompression && fileSize > c.Max
+}
+
+// masks returns the gear hash masks before (more bits, harder) and after (fewer bits, easier) the average size.
+// This is the normalized chunking of FastCDC: most parts are close to the average size.
+// The high bits are used, because they depend on the last 64 bytes (the low bits only on the last bytes).
+func (c ChunkOptions) masks() (maskS, maskL uint64) {
+	n := 63 - bits.LeadingZeros64(uint64(c.Avg)) // log2(Avg)
+	maskS = ^uint64(0) << uint(64-(n+1))
+	maskL = ^uint64(0) << uint(64-(n-1))
+	return
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// chunkWindow is the number of bytes that influence the gear hash (64 bit hash shifted by one bit per byte).
+const chunkWindow = 64
+
+// gearTable maps each byte to a random value of the rolling gear hash.
+// ATTENTION: never change the table or the seed, all part boundaries (and storage files) depend on it!
+var gearTable = func() (table [256]uint64) {
+	x := uint64(0x73706c6974667321) // seed: 'splitfs!'
+	for i := range table {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// fixedBounds returns the part offsets of a file with fixed parts (@see PartSize).
+// The list starts with 0 and ends with fileSize (parts+1 entries).
+func fixedBounds(fileSize int64) []int64 {
+	bounds := []int64{0}
+	for off := int64(PartSize); off < fileSize; off += PartSize {
+		bounds = append(bounds, off)
+	}
+	if fileSize > 0 {
+		bounds = append(bounds, fileSize)
+	}
+	return bounds
+}
+
+// chunkFile returns the part offsets of a file with content-defined chunking (@see chunkBounds).
+func chunkFile(absPath string, fileSize int64, c ChunkOptions) ([]int64, error) {
+	fh, err := os.Open(absPath)
+	if err != nil {
+		return nil, err // open error
+	}
+	defer fh.Close() // CLOSE
+
+	return chunkBounds(fh, fileSize, c)
+}
+
+// chunkBounds reads size bytes and returns the part offsets (FastCDC).
+// The list starts with 0 and ends with size (parts+1 entries).
+func chunkBounds(r io.Reader, size int64, c ChunkOptions) ([]int64, error) {
+	maskS, maskL := c.masks()
+	br := bufio.NewReaderSize(r, 1024*1024)
+	bounds := []int64{0}
+
+	for start := int64(0); start < size; {
+		remaining := size - start
+		if remaining <= c.Min {
+			bounds = append(bounds, size) // last part
+			break
+		}
+
+		// the first Min bytes are never a boundary
+		if _, err := br.Discard(int(c.Min)); err != nil {
+			return nil, chunkReadErr(err)
+		}
+		pos := start + c.Min
+		normal := start + minInt64(c.Avg, remaining)
+		end := start + minInt64(c.Max, remaining)
+
+		// find boundary (the buffered bytes are hashed in place)
+		cut := int64(-1)
+		var hash uint64
+		for pos < end && cut < 0 {
+			buf, err := br.Peek(int(minInt64(end-pos, int64(br.Size()))))
+			if err != nil {
+				return nil, chunkReadErr(err)
+			}
+			i := 0
+			for i < len(buf) {
+				hash = (hash << 1) + gearTable[buf[i]]
+				i++
+
+				mask := maskL
+				if pos+int64(i) < normal {
+					mask = maskS
+				}
+				if hash&mask == 0 {
+					cut = pos + int64(i)
+					break
+				}
+			}
+			if _, err := br.Discard(i); err != nil {
+				return nil, chunkReadErr(err)
+			}
+			pos += int64(i)
+		}
+		if cut < 0 {
+			cut = end
+		}
+		bounds = append(bounds, cut)
+		start = cut
+	}
+	return bounds, nil
+}
+
+// chunkReadErr returns a clear error if the file is smaller than at the start of the scan.
+func chunkReadErr(err error) error {
+	if err == io.EOF {
+		return errors.New("file is smaller than at the start of the scan")
+	}
+	return err
+}
+
+// minInt64 returns the smaller value.
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
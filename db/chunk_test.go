@@ -0,0 +1,90 @@
+package db_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestScanChunking(t *testing.T) {
+	keyFile, err := enc.LoadKeyFile(path.Join(os.TempDir(), "testCryptKeyFile.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunking := db.ChunkOptions{Min: 4 * 1024, Avg: 16 * 1024, Max: 64 * 1024}
+
+	// test folder: a large random file and a small file (one part)
+	root, _ := ioutil.TempDir("", "chunkTest")
+	defer os.RemoveAll(root)
+	data := make([]byte, 2*1024*1024)
+	rand.New(rand.NewSource(4711)).Read(data)
+	_ = ioutil.WriteFile(path.Join(root, "big.dat"), data, 0600)
+	_ = ioutil.WriteFile(path.Join(root, "small.dat"), data[:chunking.Max], 0600)
+
+	// TEST: variable parts between min and max
+	opts := db.ScanOptions{Chunking: chunking}
+	vDb, _, _, err := db.FromScanWithOptions(root, db.NewDb(), opts, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := vDb.VFiles["big.dat"]
+	if len(big.Parts) < 16 || len(vDb.VFiles["small.dat"].Parts) != 1 {
+		t.Fatalf("wrong part count: %d, %d", len(big.Parts), len(vDb.VFiles["small.dat"].Parts))
+	}
+	sizes := make(map[int64]bool)
+	for i, part := range big.Parts {
+		off, n := big.PartRange(i)
+		if n < chunking.Min && i < len(big.Parts)-1 || n > chunking.Max {
+			t.Errorf("wrong part size: %d", n)
+		}
+		if i == len(big.Parts)-1 && off+n != big.FileSize {
+			t.Errorf("wrong file size: %d != %d", off+n, big.FileSize)
+		}
+		if part.StorageName != keyFile.CryptName(part.PlainSHA512) {
+			t.Errorf("wrong storage name")
+		}
+		sizes[n] = true
+	}
+	if len(sizes) < len(big.Parts)/2 {
+		t.Errorf("parts have fixed sizes")
+	}
+
+	// TEST: same result with workers
+	opts.Workers = 4
+	parDb, _, _, err := db.FromScanWithOptions(root, db.NewDb(), opts, impl.DebugOff, keyFile)
+	if err != nil || !reflect.DeepEqual(vDb.VFiles, parDb.VFiles) {
+		t.Errorf("result is not the same as the sequential scan: %v", err)
+	}
+
+	// TEST: insert a byte at the start, only the first parts change
+	_ = ioutil.WriteFile(path.Join(root, "big.dat"), append([]byte{42}, data...), 0600)
+	newDb, _, _, err := db.FromScanWithOptions(root, db.NewDb(), opts, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := make(map[string]bool)
+	for _, part := range big.Parts {
+		old[part.StorageName] = true
+	}
+	newParts := 0
+	for _, part := range newDb.VFiles["big.dat"].Parts {
+		if !old[part.StorageName] {
+			newParts++
+		}
+	}
+	if newParts == 0 || newParts > 2 {
+		t.Errorf("wrong number of new parts: %d", newParts)
+	}
+
+	// TEST: invalid sizes
+	opts.Chunking = db.ChunkOptions{Min: 1024, Avg: 512, Max: 2048}
+	if _, _, _, err := db.FromScanWithOptions(root, db.NewDb(), opts, impl.DebugOff, keyFile); err == nil {
+		t.Error("no error for invalid chunk sizes")
+	}
+}
@@ -17,3 +17,7 @@ const MaxFileSizeToBundle = 12 * 1024 * 1024 // 12 MB
 
 // BundlePrefix is placed in front of each bundle storage filename.
 const BundlePrefix = "B_"
+
+// IgnoreFile is the name of the files with gitignore-style exclude patterns (@see ScanOptions).
+// The patterns are relative to the folder of the file and apply to all sub elements.
+const IgnoreFile = ".splitfsignore"
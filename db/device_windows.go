@@ -0,0 +1,10 @@
+package db
+
+import (
+	"os"
+)
+
+// deviceId is not supported on windows (@see ScanOptions.OneFileSystem).
+func deviceId(_ os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/SchnorcherSepp/splitfs/progress"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ScanOptions controls a scan (@see FromScanWithOptions).
+// The zero value scans everything except folders with an exclude marker (@see ExcludeMarkers) with a single worker.
+type ScanOptions struct {
+	// Exclude are gitignore-style patterns relative to the root folder (like a '.splitfsignore' file in the root folder).
+	Exclude []string
+	// MaxSize skips all files larger than MaxSize bytes (0=off).
+	MaxSize int64
+	// OneFileSystem skips all folders on other file systems (mount points below the root folder).
+	OneFileSystem bool
+	// Workers is the number of files or file parts (@see PartSize) that are scanned at the same time (0 or 1: sequential).
+	// The resulting db does not depend on the number of workers, but each worker needs its own compression buffers (RAM).
+	Workers int
+	// Progress reports the new and changed files and the hashed bytes (optional, @see progress.StageScan).
+	Progress *progress.Reporter
+	// Chunking splits new and changed large files at content-defined boundaries instead of fixed parts (zero value=off).
+	// Unchanged files keep their parts, so the options can be changed at any time.
+	Chunking ChunkOptions
+}
+
+// ExcludeMarkers are files that exclude the folder (with all sub elements) from the scan.
+// A 'CACHEDIR.TAG' must start with the signature of the Cache Directory Tagging Specification (@see cacheDirSignature).
+var ExcludeMarkers = []string{"CACHEDIR.TAG", ".nobackup"}
+
+// cacheDirSignature is the header of a valid 'CACHEDIR.TAG' (https://bford.info/cachedir/).
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// _Excluder decides which elements are skipped by a scan.
+type _Excluder struct {
+	rootPath string
+	opts     ScanOptions
+	rootDev  uint64
+	hasDev   bool
+	rules    map[string][]_Rule // rules by folder (relPath), @see IgnoreFile
+}
+
+// _Rule is a single gitignore-style pattern.
+type _Rule struct {
+	re      *regexp.Regexp
+	negate  bool // '!pattern'
+	dirOnly bool // 'pattern/'
+}
+
+// newExcluder returns the excluder for a scan of rootPath.
+// The root patterns are checked here, the '.splitfsignore' files are loaded with the first access.
+func newExcluder(rootPath string, opts ScanOptions) (*_Excluder, error) {
+	rootRules, err := parseRules(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	x := &_Excluder{
+		rootPath: rootPath,
+		opts:     opts,
+		rules:    make(map[string][]_Rule),
+	}
+	if opts.OneFileSystem {
+		info, err := os.Stat(rootPath)
+		if err != nil {
+			return nil, err
+		}
+		x.rootDev, x.hasDev = deviceId(info)
+	}
+
+	// root rules: CLI/config patterns first, the '.splitfsignore' file of the root folder overrides them
+	fileRules, err := x.loadRules(".")
+	if err != nil {
+		return nil, err
+	}
+	x.rules["."] = append(rootRules, fileRules...)
+	return x, nil
+}
+
+// excluded returns true, if the element (not the sub elements) is skipped.
+// The parent folders are not checked (@see excludedPath). The root folder is never skipped.
+func (x *_Excluder) excluded(absPath, relPath string, info os.FileInfo) (bool, error) {
+	if relPath == "." {
+		return false, nil
+	}
+	isDir := info.IsDir()
+
+	// max size
+	if !isDir && x.opts.MaxSize > 0 && info.Size() > x.opts.MaxSize {
+		return true, nil
+	}
+
+	// one file system
+	if isDir && x.hasDev {
+		if dev, ok := deviceId(info); ok && dev != x.rootDev {
+			return true, nil
+		}
+	}
+
+	// markers
+	if isDir && hasExcludeMarker(absPath) {
+		return true, nil
+	}
+
+	// patterns: from the root to the parent folder, the last matching rule wins
+	dirs := []string{"."}
+	for p := path.Dir(relPath); p != "."; p = path.Dir(p) {
+		dirs = append([]string{dirs[0], p}, dirs[1:]...)
+	}
+	exclude := false
+	for _, dir := range dirs {
+		rules, ok := x.rules[dir]
+		if !ok {
+			var err error
+			if rules, err = x.loadRules(dir); err != nil {
+				return false, err
+			}
+			x.rules[dir] = rules
+		}
+		sub := relPath
+		if dir != "." {
+			sub = strings.TrimPrefix(relPath, dir+"/")
+		}
+		for _, r := range rules {
+			if (!r.dirOnly || isDir) && r.re.MatchString(sub) {
+				exclude = !r.negate
+			}
+		}
+	}
+	return exclude, nil
+}
+
+// excludedPath returns true, if the element or one of its parent folders is skipped.
+func (x *_Excluder) excludedPath(relPath string) (bool, error) {
+	for p := relPath; p != "."; p = path.Dir(p) {
+		absPath := filepath.Join(x.rootPath, filepath.FromSlash(p))
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return false, err
+		}
+		if ex, err := x.excluded(absPath, p, info); ex || err != nil {
+			return ex, err
+		}
+	}
+	return false, nil
+}
+
+// loadRules reads the '.splitfsignore' file of the folder (no file: no rules).
+func (x *_Excluder) loadRules(dir string) ([]_Rule, error) {
+	b, err := ioutil.ReadFile(filepath.Join(x.rootPath, filepath.FromSlash(dir), IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	rules, err := parseRules(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path.Join(dir, IgnoreFile), err)
+	}
+	return rules, nil
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// parseRules parses gitignore-style patterns:
+//   - empty lines and lines starting with '#' are ignored
+//   - '!' negates the pattern (the element is scanned again)
+//   - a trailing '/' matches only folders
+//   - a pattern with a '/' at the beginning or in the middle is relative to the folder of the rules,
+//     otherwise the pattern matches the name at any level
+//   - '*' and '?' match everything except '/', '**' matches any number of folders
+func parseRules(patterns []string) ([]_Rule, error) {
+	rules := make([]_Rule, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimRight(strings.TrimSuffix(p, "\r"), " ")
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+
+		r := _Rule{}
+		if strings.HasPrefix(p, "!") {
+			r.negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\`) {
+			p = p[1:] // '\#' or '\!'
+		}
+		if strings.HasSuffix(p, "/") {
+			r.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if p == "" {
+			continue
+		}
+		if strings.Contains(p, "/") {
+			p = strings.TrimPrefix(p, "/") // anchored
+		} else {
+			p = "**/" + p // any level
+		}
+
+		re, err := regexp.Compile("^" + globToRegexp(p) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", p, err)
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// globToRegexp converts a gitignore glob into a regular expression.
+func globToRegexp(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "/**") && i+3 == len(p):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// hasExcludeMarker returns true, if the folder has an exclude marker (@see ExcludeMarkers).
+func hasExcludeMarker(absPath string) bool {
+	for _, name := range ExcludeMarkers {
+		p := filepath.Join(absPath, name)
+		if name != "CACHEDIR.TAG" {
+			if _, err := os.Lstat(p); err == nil {
+				return true
+			}
+			continue
+		}
+		fh, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		header := make([]byte, len(cacheDirSignature))
+		n, _ := fh.Read(header)
+		_ = fh.Close()
+		if string(header[:n]) == cacheDirSignature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package db_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFromScanWithOptions(t *testing.T) {
+	keyFile, err := enc.LoadKeyFile(path.Join(os.TempDir(), "testCryptKeyFile.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test folder
+	root, _ := ioutil.TempDir("", "excludeTest")
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		db.IgnoreFile:             "# comment\n*.log\n/build/\n!keep.log\n",
+		"a.log":                   "excluded (pattern)",
+		"keep.log":                "included (negation)",
+		"big.dat":                 strings.Repeat("x", 100),
+		"build/x.o":               "excluded (anchored folder)",
+		"sub/" + db.IgnoreFile:    "!b.log\nc.txt\n",
+		"sub/b.log":               "included (negation in sub folder)",
+		"sub/c.txt":               "excluded (pattern in sub folder)",
+		"sub/d.txt":               "included",
+		"sub/build/y.o":           "included (the root pattern is anchored)",
+		"sub/tmp/z.txt":           "excluded (option)",
+		"cache/CACHEDIR.TAG":      "Signature: 8a477f597d28d172789f06886806bc55\n",
+		"cache/data":              "excluded (marker)",
+		"nb/.nobackup":            "",
+		"nb/data":                 "excluded (marker)",
+		"badtag/CACHEDIR.TAG":     "no signature",
+		"other/[x].txt":           "included",
+		"other/deep/er/file.tmp2": "excluded (option with **)",
+	}
+	for name, data := range files {
+		p := path.Join(root, name)
+		_ = os.MkdirAll(path.Dir(p), 0700)
+		if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := db.ScanOptions{Exclude: []string{"tmp/", "other/**/*.tmp2"}, MaxSize: 50, OneFileSystem: true}
+
+	// TEST: full scan
+	vDb, _, summary, err := db.FromScanWithOptions(root, db.NewDb(), opts, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", db.IgnoreFile, "badtag", "badtag/CACHEDIR.TAG", "keep.log", "other", "other/[x].txt", "other/deep", "other/deep/er",
+		"sub", "sub/" + db.IgnoreFile, "sub/b.log", "sub/build", "sub/build/y.o", "sub/d.txt"}
+	if got := keys(vDb); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong elements:\n%v\n%v", got, want)
+	}
+	if !strings.Contains(summary, "skipped=8") {
+		t.Errorf("wrong summary: %s", summary)
+	}
+
+	// TEST: folder content without excluded elements
+	for relPath, vFile := range vDb.VFiles {
+		for _, fc := range vFile.FolderContent {
+			if _, ok := vDb.VFiles[path.Join(relPath, fc.RelPath)]; !ok {
+				t.Errorf("excluded element in folder content: %s/%s", relPath, fc.RelPath)
+			}
+		}
+	}
+
+	// TEST: without options (only the ignore files and markers)
+	vDb2, _, _, err := db.FromScan(root, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big.dat", "sub/tmp/z.txt", "other/deep/er/file.tmp2"} {
+		if _, ok := vDb2.VFiles[name]; !ok {
+			t.Errorf("not found: %s", name)
+		}
+	}
+
+	// TEST: partial scan (excluded path)
+	_ = ioutil.WriteFile(path.Join(root, "sub", "new.log"), []byte("new"), 0600)
+	vDb3, _, _, err := db.FromPathsWithOptions(root, vDb, []string{"sub/new.log", "cache/data"}, opts, impl.DebugOff, keyFile)
+	if err != nil || !reflect.DeepEqual(keys(vDb), keys(vDb3)) {
+		t.Fatalf("err=%v, %v", err, keys(vDb3))
+	}
+	if !reflect.DeepEqual(vDb.VFiles["sub"].FolderContent, vDb3.VFiles["sub"].FolderContent) {
+		t.Errorf("excluded element in folder content: %v", vDb3.VFiles["sub"].FolderContent)
+	}
+
+	// TEST: partial scan (changed ignore file)
+	_ = ioutil.WriteFile(path.Join(root, "sub", db.IgnoreFile), []byte("*\n"), 0600)
+	vDb4, changed, _, err := db.FromPathsWithOptions(root, vDb, []string{"sub/" + db.IgnoreFile}, opts, impl.DebugOff, keyFile)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v, err=%v", changed, err)
+	}
+	full, _, _, err := db.FromScanWithOptions(root, vDb, opts, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vDb4.VFiles, full.VFiles) {
+		t.Errorf("partial scan != full scan\n%v\n%v", keys(vDb4), keys(full))
+	}
+	if got := keys(full); len(got) != 10 || got[len(got)-1] != "sub" {
+		t.Errorf("wrong elements: %v", got)
+	}
+
+	// TEST: invalid pattern
+	if _, _, _, err := db.FromScanWithOptions(root, db.NewDb(), db.ScanOptions{Exclude: []string{"[z-a]"}}, impl.DebugOff, keyFile); err == nil {
+		t.Error("no error")
+	}
+}
+
+// keys returns the sorted element list.
+func keys(vDb db.Db) []string {
+	list := make([]string, 0, len(vDb.VFiles))
+	for k := range vDb.VFiles {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
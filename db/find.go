@@ -0,0 +1,88 @@
+package db
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Filter defines the search criteria for Find().
+// Zero values are ignored.
+type Filter struct {
+
+	// Pattern is a glob pattern (@see path.Match).
+	// Without '/' the pattern is compared with the name of the element, otherwise with the RelPath.
+	// Example: *.jpg
+	Pattern string
+
+	// MinSize and MaxSize limit the FileSize (bytes).
+	MinSize int64
+	MaxSize int64
+
+	// After and Before limit the MTime (unix time; seconds).
+	After  int64
+	Before int64
+
+	// FilesOnly ignores all folders.
+	FilesOnly bool
+}
+
+// Find returns all elements (files and folders) that match the filter, sorted by RelPath.
+// An invalid glob pattern returns path.ErrBadPattern.
+func (db *Db) Find(filter Filter) ([]VirtFile, error) {
+	// check pattern
+	if _, err := path.Match(filter.Pattern, ""); err != nil {
+		return nil, err
+	}
+
+	// find
+	list := make([]VirtFile, 0)
+	for _, vFile := range db.VFiles {
+		if filter.match(vFile) {
+			list = append(list, vFile)
+		}
+	}
+
+	// sort & return
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RelPath < list[j].RelPath
+	})
+	return list, nil
+}
+
+// match checks a single element.
+func (f *Filter) match(vFile VirtFile) bool {
+	// type
+	if f.FilesOnly && vFile.IsDir {
+		return false
+	}
+
+	// size
+	if f.MinSize > 0 && vFile.FileSize < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && vFile.FileSize > f.MaxSize {
+		return false
+	}
+
+	// mtime
+	if f.After > 0 && vFile.MTime < f.After {
+		return false
+	}
+	if f.Before > 0 && vFile.MTime > f.Before {
+		return false
+	}
+
+	// pattern
+	if f.Pattern != "" {
+		target := vFile.Name()
+		if strings.Contains(f.Pattern, "/") {
+			target = vFile.RelPath
+		}
+		if ok, _ := path.Match(f.Pattern, target); !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	"path"
+	"testing"
+)
+
+func TestDb_Find(t *testing.T) {
+	vDb := db.NewDb()
+	for _, vf := range []db.VirtFile{
+		{RelPath: ".", IsDir: true, MTime: 100},
+		{RelPath: "photos", IsDir: true, MTime: 200},
+		{RelPath: "photos/a.jpg", FileSize: 1000, MTime: 300},
+		{RelPath: "photos/b.JPG", FileSize: 2000, MTime: 400},
+		{RelPath: "photos/c.png", FileSize: 3000, MTime: 500},
+		{RelPath: "doc.jpg", FileSize: 10, MTime: 600},
+	} {
+		vDb.VFiles[vf.RelPath] = vf
+	}
+
+	// helper
+	find := func(filter db.Filter) []string {
+		list, err := vDb.Find(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]string, 0, len(list))
+		for _, vf := range list {
+			ret = append(ret, vf.RelPath)
+		}
+		return ret
+	}
+	check := func(is []string, su ...string) {
+		if len(is) != len(su) {
+			t.Fatalf("is=%v, su=%v", is, su)
+		}
+		for i := range is {
+			if is[i] != su[i] {
+				t.Fatalf("is=%v, su=%v", is, su)
+			}
+		}
+	}
+
+	// all
+	check(find(db.Filter{}), ".", "doc.jpg", "photos", "photos/a.jpg", "photos/b.JPG", "photos/c.png")
+	check(find(db.Filter{FilesOnly: true}), "doc.jpg", "photos/a.jpg", "photos/b.JPG", "photos/c.png")
+
+	// pattern (name and path)
+	check(find(db.Filter{Pattern: "*.jpg"}), "doc.jpg", "photos/a.jpg")
+	check(find(db.Filter{Pattern: "photos/*.[jJ][pP][gG]"}), "photos/a.jpg", "photos/b.JPG")
+
+	// size
+	check(find(db.Filter{MinSize: 1500}), "photos/b.JPG", "photos/c.png")
+	check(find(db.Filter{MinSize: 1, MaxSize: 1000}), "doc.jpg", "photos/a.jpg")
+
+	// mtime
+	check(find(db.Filter{After: 300, Before: 500, FilesOnly: true}), "photos/a.jpg", "photos/b.JPG", "photos/c.png")
+	check(find(db.Filter{After: 550}), "doc.jpg")
+
+	// invalid pattern
+	if _, err := vDb.Find(db.Filter{Pattern: "["}); err != path.ErrBadPattern {
+		t.Errorf("wrong error: %v", err)
+	}
+}
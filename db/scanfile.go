@@ -40,12 +40,9 @@ func ScanFile(absPath, relPath string, keyFile *enc.KeyFile) (VirtFile, error) {
 	// PART LOOP
 	partList := make([]VFilePart, 0)
 	for partNo := 0; true; partNo++ {
-
-		// calc file part plain hash
-		// the plain hash is the starting point for other calculations
-		plainSHA512, partSize, err := plainSHA512(fh, partNo)
+		part, partSize, err := scanPart(fh, int64(partNo)*PartSize, PartSize, useCompression, comprSize, keyFile)
 		if err != nil {
-			return errorFile, err // hash error
+			return errorFile, err // hash or partSize error
 		}
 
 		// EXIT LOOP (part len = 0)
@@ -53,30 +50,6 @@ func ScanFile(absPath, relPath string, keyFile *enc.KeyFile) (VirtFile, error) {
 		if partSize == 0 {
 			break
 		}
-
-		// calc storage file stuff
-		dataKey := keyFile.DataKey(plainSHA512)
-		storageName := keyFile.CryptName(plainSHA512)
-
-		storageSize := partSize // DEFAULT (withOUT compression): storageSize == partSize
-		if useCompression {
-			storageSize = comprSize // with compression: storageSize == comprSize
-		}
-
-		// md5 file hash of the encrypted content
-		storageMd5, err := cryptMD5(fh, partNo, useCompression, storageSize, dataKey)
-		if err != nil {
-			return errorFile, err // hash or partSize error
-		}
-
-		// build & add file part struct to list
-		part := VFilePart{
-			PlainSHA512:  plainSHA512,
-			StorageName:  storageName,
-			StorageSize:  storageSize,
-			StorageMd5:   storageMd5,
-			CryptDataKey: dataKey,
-		}
 		partList = append(partList, part)
 	}
 
@@ -95,6 +68,42 @@ func ScanFile(absPath, relPath string, keyFile *enc.KeyFile) (VirtFile, error) {
 
 // ----------  HELPER  -----------------------------------------------------------------------------------------------//
 
+// scanPart calculates the part struct of the part with at most n bytes at the offset off (@see ScanFile).
+// If partSize is 0, the part is behind the end of the file (part is empty).
+func scanPart(fh *os.File, off, n int64, useCompression bool, comprSize int64, keyFile *enc.KeyFile) (part VFilePart, partSize int64, err error) {
+	// calc file part plain hash
+	// the plain hash is the starting point for other calculations
+	plainSHA512, partSize, err := plainSHA512(fh, off, n)
+	if err != nil || partSize == 0 {
+		return // hash error or empty part
+	}
+
+	// calc storage file stuff
+	dataKey := keyFile.DataKey(plainSHA512)
+	storageName := keyFile.CryptName(plainSHA512)
+
+	storageSize := partSize // DEFAULT (withOUT compression): storageSize == partSize
+	if useCompression {
+		storageSize = comprSize // with compression: storageSize == comprSize
+	}
+
+	// md5 file hash of the encrypted content
+	storageMd5, err := cryptMD5(fh, off, n, useCompression, storageSize, dataKey)
+	if err != nil {
+		return // hash or partSize error
+	}
+
+	// build file part struct
+	part = VFilePart{
+		PlainSHA512:  plainSHA512,
+		StorageName:  storageName,
+		StorageSize:  storageSize,
+		StorageMd5:   storageMd5,
+		CryptDataKey: dataKey,
+	}
+	return
+}
+
 // getFileStat read the basic file attributes
 // return error if file not exist
 func getFileStat(absPath string) (fileSize, modTime int64, err error) {
@@ -151,10 +160,7 @@ func tryCompression(absPath string, fileSize int64) (useCompression bool, comprS
 }
 
 // seek sets the offset for the next Read on file to the part start
-func seek(fh *os.File, partNo int) (int64, error) {
-	// calc offset
-	offset := int64(partNo) * PartSize
-
+func seek(fh *os.File, offset int64) (int64, error) {
 	// seek
 	o, err := fh.Seek(offset, 0)
 	if err != nil {
@@ -170,41 +176,41 @@ func seek(fh *os.File, partNo int) (int64, error) {
 	return offset, nil
 }
 
-// plainSHA512 calc the plain part hash
-func plainSHA512(fh *os.File, partNo int) (plainSHA512 []byte, partSize int64, err error) {
+// plainSHA512 calc the plain part hash (at most n bytes at the offset off)
+func plainSHA512(fh *os.File, off, n int64) (plainSHA512 []byte, partSize int64, err error) {
 	// go to: part beginning
-	_, err = seek(fh, partNo)
+	_, err = seek(fh, off)
 	if err != nil {
 		return // seek error
 	}
 
 	// hashing
 	hh := sha512.New()
-	partSize, err = io.Copy(hh, io.LimitReader(fh, PartSize)) // read part
-	plainSHA512 = hh.Sum(nil)                                 // calc hash
+	partSize, err = io.Copy(hh, io.LimitReader(fh, n)) // read part
+	plainSHA512 = hh.Sum(nil)                          // calc hash
 
 	// return plainSHA512, partSize AND error
 	return
 }
 
-// cryptMD5 calc the storage file hash (= crypt content)
+// cryptMD5 calc the storage file hash (= crypt content) of the part with at most size bytes at the offset off
 // compression is only for the first part [0] possible
-func cryptMD5(fh *os.File, partNo int, useCompr bool, storageSize int64, cryptKey []byte) (cryptMD5 string, err error) {
+func cryptMD5(fh *os.File, off, size int64, useCompr bool, storageSize int64, cryptKey []byte) (cryptMD5 string, err error) {
 	// There is no second part with active compression!
-	if useCompr && partNo > 0 {
+	if useCompr && off > 0 {
 		err = errors.New("can't compress second part")
 		return // check error
 	}
 
 	// go to: part beginning
-	_, err = seek(fh, partNo)
+	_, err = seek(fh, off)
 	if err != nil {
 		return // seek error
 	}
 
 	// build reader
-	r := io.LimitReader(fh, PartSize) // file part (plain) reader
-	if useCompr {                     // compression reader
+	r := io.LimitReader(fh, size) // file part (plain) reader
+	if useCompr {                 // compression reader
 		// read all bytes
 		var b []byte
 		b, err = ioutil.ReadAll(r)
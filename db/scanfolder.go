@@ -1,24 +1,51 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/progress"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	"golang.org/x/text/unicode/norm"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 )
 
 // FromScan scan a root folder and return a new db.
 // Bundles and links are removed.
 func FromScan(rootPath string, oldDB Db, debugLvl uint8, keyFile *enc.KeyFile) (newDB Db, changed bool, summary string, retErr error) {
+	return FromScanWithOptions(rootPath, oldDB, ScanOptions{}, debugLvl, keyFile)
+}
+
+// FromScanWithOptions scan a root folder like FromScan, but skips the elements excluded by the options
+// and the '.splitfsignore' files (@see ScanOptions and IgnoreFile). Skipped elements are not in the folder content.
+func FromScanWithOptions(rootPath string, oldDB Db, opts ScanOptions, debugLvl uint8, keyFile *enc.KeyFile) (newDB Db, changed bool, summary string, retErr error) {
 	// debug (0=off, 1=debug, 2=high)
 	debug := debugLvl >= impl.DebugLow
 
+	// a reader key can't derive storage names and data keys
+	if keyFile == nil || keyFile.IsReader() {
+		retErr = errors.New("scan requires the master key file (reader key or nil)")
+		log.Printf("ERROR: %s/FromScan: %v", packageName, retErr)
+		return
+	}
+
+	// exclude rules
+	x, err := newExcluder(rootPath, opts)
+	if err != nil {
+		retErr = err
+		log.Printf("ERROR: %s/FromScan: %v", packageName, retErr)
+		return
+	}
+
+	// progress (optional)
+	opts.Progress.Start(progress.StageScan, 0, 0)
+	defer opts.Progress.Finish()
+
 	// replace oldDB with clone (first level)
 	clone := NewDb()
 	if oldDB.VFiles != nil {
@@ -33,6 +60,8 @@ func FromScan(rootPath string, oldDB Db, debugLvl uint8, keyFile *enc.KeyFile) (
 
 	// init
 	countNewOrUpdate := 0
+	countSkipped := 0
+	jobs := make([]*_ScanJob, 0)
 	newDB = NewDb()
 
 	// Walk
@@ -43,86 +72,39 @@ func FromScan(rootPath string, oldDB Db, debugLvl uint8, keyFile *enc.KeyFile) (
 		}
 
 		// relative path
-		relPath, err := filepath.Rel(rootPath, absPath)
+		relPath, err := toRelPath(rootPath, absPath)
 		if err != nil {
 			return err
 		}
 
-		// UTF8 FIX: Text normalization
-		// https://blog.golang.org/normalization
-		relPath = norm.NFC.String(relPath)
-		// WINDOWS/LINUX FIX: path separator = '/'
-		relPath = strings.ReplaceAll(relPath, "\\", "/")
-
-		// get element attributes
-		isDir := info.IsDir()
-		mtime := info.ModTime().Unix()
-		size := info.Size()
-
-		// WINDOWS/LINUX FIX: set folder size to 0
-		if isDir {
-			size = 0
-		}
-
-		// if folder: get folder content
-		var dirEntries []FolderEl
-		if isDir {
-			dirEntries, err = getDirEntries(absPath)
-			if err != nil {
-				return err
+		// skip excluded elements (folders with all sub elements)
+		if ex, err := x.excluded(absPath, relPath, info); err != nil {
+			return err
+		} else if ex {
+			countSkipped++
+			if debug {
+				log.Printf("DEBUG: %s/ScanFolder: skip: '%s'", packageName, relPath)
 			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		// find element in old DB
-		e, ok := oldDB.VFiles[relPath]
-
-		// element not found (new) OR element changed
-		if !ok || e.FileSize != size || e.IsDir != isDir || e.MTime != mtime {
+		// scan element (if new or changed)
+		e, job, newOrUpdate, err := scanElement(absPath, relPath, info, oldDB, x, debug)
+		if err != nil {
+			return err
+		}
+		if job != nil {
+			jobs = append(jobs, job)
+			opts.Progress.Found(relPath, info.Size())
+		}
+		if newOrUpdate {
 			countNewOrUpdate++
 			changed = true
-
-			detail := ""
-			if !isDir {
-				start := time.Now()
-				// is file -> scan
-				vf, err := ScanFile(absPath, relPath, keyFile)
-				if err != nil {
-					return err
-				}
-				e = vf
-				// write detail
-				var sinceInSec = float64(time.Since(start)) / float64(time.Second)
-				if sinceInSec < 0.001 {
-					sinceInSec = 0.001
-				}
-				var sizeInMb = float64(e.FileSize) / (1024 * 1024)
-				detail = fmt.Sprintf("\t[%.2f MB/s]", sizeInMb/sinceInSec)
-
-			} else {
-				// is folder -> create
-				e = VirtFile{ // override db element (dir)
-					RelPath:       relPath,
-					FileSize:      0,
-					MTime:         mtime,
-					IsDir:         isDir,
-					FolderContent: dirEntries,
-				}
-			}
-
-			if debug {
-				if !ok {
-					log.Printf("DEBUG: %s/ScanFolder: new: '%s'%s", packageName, relPath, detail)
-				} else {
-					log.Printf("DEBUG: %s/ScanFolder: changed: '%s'%s", packageName, relPath, detail)
-				}
-			}
 		}
 
-		// FIX: Always update the folder content. If no file changes have been made,
-		// the database will not be updated. If the database is updated, then the
-		// folder content will also be up to date.
-		e.FolderContent = dirEntries
-
 		// Delete exist elements from old db. At the end we can detect old item no longer available.
 		// If there is anything left, something has changed!
 		delete(oldDB.VFiles, relPath)
@@ -132,13 +114,21 @@ func FromScan(rootPath string, oldDB Db, debugLvl uint8, keyFile *enc.KeyFile) (
 		return nil
 	})
 
+	// scan new and changed files (workers)
+	if retErr == nil {
+		retErr = scanFiles(jobs, opts, keyFile, debug)
+		for _, job := range jobs {
+			newDB.VFiles[job.relPath] = job.file
+		}
+	}
+
 	// finale changed?
 	if len(oldDB.VFiles) > 0 {
 		changed = true
 	}
 
 	// statistic
-	summary = fmt.Sprintf("SCAN: error=%v, sum=%d, changed=%v, newOrUpdate=%d, removed=%d", retErr, len(newDB.VFiles), changed, countNewOrUpdate, len(oldDB.VFiles))
+	summary = fmt.Sprintf("SCAN: error=%v, sum=%d, changed=%v, newOrUpdate=%d, removed=%d, skipped=%d", retErr, len(newDB.VFiles), changed, countNewOrUpdate, len(oldDB.VFiles), countSkipped)
 	if debug && changed {
 		log.Printf("DEBUG: %s/ScanFolder: %s", packageName, summary)
 	}
@@ -147,8 +137,89 @@ func FromScan(rootPath string, oldDB Db, debugLvl uint8, keyFile *enc.KeyFile) (
 
 // ----------  HELPER  -----------------------------------------------------------------------------------------------//
 
-// getDirEntries return folder content
-func getDirEntries(dir string) ([]FolderEl, error) {
+// toRelPath returns the normalized relative path (db key) of an element below the root folder.
+func toRelPath(rootPath, absPath string) (string, error) {
+	relPath, err := filepath.Rel(rootPath, absPath)
+	if err != nil {
+		return "", err
+	}
+
+	// UTF8 FIX: Text normalization
+	// https://blog.golang.org/normalization
+	relPath = norm.NFC.String(relPath)
+	// WINDOWS/LINUX FIX: path separator = '/'
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	return relPath, nil
+}
+
+// scanElement returns the db element of a file or folder.
+// The element from refDB is reused if it has not changed (newOrUpdate=false). A new or changed file is not scanned here,
+// the element is only a placeholder and the returned job must be scanned (@see scanFiles).
+// Excluded elements are not in the folder content.
+func scanElement(absPath, relPath string, info os.FileInfo, refDB Db, x *_Excluder, debug bool) (e VirtFile, job *_ScanJob, newOrUpdate bool, err error) {
+	// get element attributes
+	isDir := info.IsDir()
+	mtime := info.ModTime().Unix()
+	size := info.Size()
+
+	// WINDOWS/LINUX FIX: set folder size to 0
+	if isDir {
+		size = 0
+	}
+
+	// if folder: get folder content
+	var dirEntries []FolderEl
+	if isDir {
+		dirEntries, err = getDirEntries(absPath, relPath, x)
+		if err != nil {
+			return
+		}
+	}
+
+	// find element in ref DB
+	e, ok := refDB.VFiles[relPath]
+
+	// element not found (new) OR element changed
+	if !ok || e.FileSize != size || e.IsDir != isDir || e.MTime != mtime {
+		newOrUpdate = true
+
+		if !isDir {
+			// is file -> scan later (placeholder)
+			job = &_ScanJob{absPath: absPath, relPath: relPath, known: ok}
+			e = VirtFile{
+				RelPath:  relPath,
+				FileSize: size,
+				MTime:    mtime,
+			}
+			return e, job, newOrUpdate, nil
+		}
+
+		// is folder -> create
+		e = VirtFile{ // override db element (dir)
+			RelPath:       relPath,
+			FileSize:      0,
+			MTime:         mtime,
+			IsDir:         isDir,
+			FolderContent: dirEntries,
+		}
+		if debug {
+			if !ok {
+				log.Printf("DEBUG: %s/ScanFolder: new: '%s'", packageName, relPath)
+			} else {
+				log.Printf("DEBUG: %s/ScanFolder: changed: '%s'", packageName, relPath)
+			}
+		}
+	}
+
+	// FIX: Always update the folder content. If no file changes have been made,
+	// the database will not be updated. If the database is updated, then the
+	// folder content will also be up to date.
+	e.FolderContent = dirEntries
+	return e, nil, newOrUpdate, nil
+}
+
+// getDirEntries return folder content (without excluded elements)
+func getDirEntries(dir, relDir string, x *_Excluder) ([]FolderEl, error) {
 	// open folder
 	f, err := os.Open(dir)
 	if err != nil {
@@ -183,6 +254,13 @@ func getDirEntries(dir string) ([]FolderEl, error) {
 		// WINDOWS/LINUX FIX: path separator = '/'
 		name = strings.ReplaceAll(name, "\\", "/")
 
+		// skip excluded elements
+		if ex, err := x.excluded(absPath, path.Join(relDir, name), info); err != nil {
+			return nil, err
+		} else if ex {
+			continue
+		}
+
 		// append
 		retList = append(retList, FolderEl{
 			RelPath: name,
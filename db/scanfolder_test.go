@@ -6,6 +6,7 @@ import (
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 )
 
@@ -61,3 +62,53 @@ func TestScanFolder(t *testing.T) {
 		}
 	}
 }
+
+func TestScanFolder_readerKey(t *testing.T) {
+	readerPath := path.Join(os.TempDir(), "testReaderKeyFile.dat")
+	_ = os.Remove(readerPath)
+	defer os.Remove(readerPath)
+	readerKey, err := enc.ExportReaderKey(path.Join(os.TempDir(), "testCryptKeyFile.dat"), nil, readerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// scan with reader key
+	if _, _, _, err := db.FromScan("../encoding", db.NewDb(), impl.DebugOff, readerKey); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestScanFolder_workers(t *testing.T) {
+	keyFile, err := enc.LoadKeyFile(path.Join(os.TempDir(), "testCryptKeyFile.dat"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// sequential scan
+	seqDb, _, _, err := db.FromScanWithOptions("../", db.NewDb(), db.ScanOptions{}, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// parallel scan: same result
+	for _, workers := range []int{2, 8} {
+		parDb, _, _, err := db.FromScanWithOptions("../", db.NewDb(), db.ScanOptions{Workers: workers}, impl.DebugLow, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(seqDb.VFiles, parDb.VFiles) {
+			t.Errorf("workers=%d: result is not the same as the sequential scan", workers)
+		}
+	}
+
+	// partial scan
+	parDb, changed, _, err := db.FromPathsWithOptions("../", db.NewDb(), []string{"db", "core"}, db.ScanOptions{Workers: 4}, impl.DebugOff, keyFile)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v, err=%v", changed, err)
+	}
+	for _, k := range []string{"db/scanfolder.go", "core/upload.go"} {
+		if !reflect.DeepEqual(seqDb.VFiles[k], parDb.VFiles[k]) {
+			t.Errorf("wrong element: %s", k)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/progress"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// _ScanJob is a new or changed file found by a scan (@see scanFiles).
+type _ScanJob struct {
+	absPath string
+	relPath string
+	known   bool // file is in the old db (changed)
+
+	// set by scanFiles
+	file      VirtFile
+	comprSize int64
+	bounds    []int64 // part offsets (parts+1 entries, @see fixedBounds and chunkBounds)
+	partCount int
+	pending   int32 // parts not scanned yet
+	start     time.Time
+	task      *progress.Task // hashed bytes (optional)
+}
+
+// scanFiles scans all files of the jobs with a pool of workers and sets job.file (@see ScanFile).
+// The parts of a file (@see PartSize and ChunkOptions) are scanned in parallel, too. The result does not depend on the
+// number of workers (opts.Workers).
+// If a file fails, no new files or parts are started and the error of the first failed file (job order) is returned.
+// The hashed bytes and files are reported to opts.Progress (optional).
+func scanFiles(jobs []*_ScanJob, opts ScanOptions, keyFile *enc.KeyFile, debug bool) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	workers, rep := opts.Workers, opts.Progress
+	if err := opts.Chunking.Check(); err != nil {
+		return err
+	}
+
+	// first: stat, compression and part boundaries (per file)
+	err := forEach(len(jobs), workers, func(i int) error {
+		job := jobs[i]
+		job.start = time.Now()
+		fileSize, modTime, err := getFileStat(job.absPath)
+		if err != nil {
+			return err // stat error (file not found)
+		}
+		useCompression, comprSize, err := tryCompression(job.absPath, fileSize)
+		if err != nil {
+			return err // compression error
+		}
+		job.comprSize = comprSize
+		job.bounds = fixedBounds(fileSize)
+		if opts.Chunking.use(fileSize, useCompression) {
+			if job.bounds, err = chunkFile(job.absPath, fileSize, opts.Chunking); err != nil {
+				return fmt.Errorf("'%s': %v", job.relPath, err) // read error
+			}
+		}
+		job.task = rep.Task(job.relPath, fileSize)
+		job.partCount = len(job.bounds) - 1
+		job.pending = int32(job.partCount)
+		job.file = VirtFile{
+			RelPath:        job.relPath,
+			FileSize:       fileSize,
+			MTime:          modTime,
+			Parts:          make([]VFilePart, job.partCount),
+			UseCompression: useCompression,
+		}
+		if job.partCount == 0 {
+			job.task.Done(progress.Hashed)
+			logScanned(job, debug)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// secondly: all parts of all files
+	type task struct {
+		job    *_ScanJob
+		partNo int
+	}
+	tasks := make([]task, 0, len(jobs))
+	for _, job := range jobs {
+		for partNo := 0; partNo < job.partCount; partNo++ {
+			tasks = append(tasks, task{job: job, partNo: partNo})
+		}
+	}
+	return forEach(len(tasks), workers, func(i int) error {
+		t := tasks[i]
+		job := t.job
+
+		// open file handler (one per part)
+		fh, err := os.Open(job.absPath)
+		if err != nil {
+			return err // open error
+		}
+		defer fh.Close() // CLOSE
+
+		off, n := job.bounds[t.partNo], job.bounds[t.partNo+1]-job.bounds[t.partNo]
+		part, partSize, err := scanPart(fh, off, n, job.file.UseCompression, job.comprSize, keyFile)
+		if err != nil {
+			return err // hash or partSize error
+		}
+		if partSize != n {
+			return fmt.Errorf("file is smaller than at the start of the scan: '%s'", job.relPath) // file changed
+		}
+		job.file.Parts[t.partNo] = part
+		job.task.Add(partSize)
+
+		// file finished
+		if atomic.AddInt32(&job.pending, -1) == 0 {
+			job.task.Done(progress.Hashed)
+			logScanned(job, debug)
+		}
+		return nil
+	})
+}
+
+// forEach calls fn for all indexes (0 to n-1) with a pool of workers. The indexes are started in order.
+// After an error, no new indexes are started. The error with the lowest index is returned, this is the same error as in
+// a sequential loop (all lower indexes have been started).
+func forEach(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		next     int64 = -1
+		failed   int32
+		mux      sync.Mutex
+		firstIdx = n
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				if err := fn(i); err != nil {
+					atomic.StoreInt32(&failed, 1)
+					mux.Lock()
+					if i < firstIdx {
+						firstIdx, firstErr = i, err
+					}
+					mux.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// logScanned writes the debug line of a scanned file.
+func logScanned(job *_ScanJob, debug bool) {
+	if !debug {
+		return
+	}
+	var sinceInSec = float64(time.Since(job.start)) / float64(time.Second)
+	if sinceInSec < 0.001 {
+		sinceInSec = 0.001
+	}
+	var sizeInMb = float64(job.file.FileSize) / (1024 * 1024)
+	detail := fmt.Sprintf("\t[%.2f MB/s]", sizeInMb/sinceInSec)
+	if !job.known {
+		log.Printf("DEBUG: %s/ScanFolder: new: '%s'%s", packageName, job.relPath, detail)
+	} else {
+		log.Printf("DEBUG: %s/ScanFolder: changed: '%s'%s", packageName, job.relPath, detail)
+	}
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/progress"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"golang.org/x/text/unicode/norm"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FromPaths rescans only the given paths (relative to the root folder, e.g. from file system events) and returns a new db.
+// Folders are scanned with all sub elements, deleted paths are removed with all sub elements
+// and the folder content of the parent folders is updated. Bundles and links are removed (like FromScan).
+func FromPaths(rootPath string, oldDB Db, relPaths []string, debugLvl uint8, keyFile *enc.KeyFile) (newDB Db, changed bool, summary string, retErr error) {
+	return FromPathsWithOptions(rootPath, oldDB, relPaths, ScanOptions{}, debugLvl, keyFile)
+}
+
+// FromPathsWithOptions rescans only the given paths like FromPaths, but skips the excluded elements (@see FromScanWithOptions).
+// Paths that are excluded now are removed. A changed '.splitfsignore' file rescans its folder.
+func FromPathsWithOptions(rootPath string, oldDB Db, relPaths []string, opts ScanOptions, debugLvl uint8, keyFile *enc.KeyFile) (newDB Db, changed bool, summary string, retErr error) {
+	// debug (0=off, 1=debug, 2=high)
+	debug := debugLvl >= impl.DebugLow
+
+	// a reader key can't derive storage names and data keys
+	if keyFile == nil || keyFile.IsReader() {
+		retErr = errors.New("scan requires the master key file (reader key or nil)")
+		log.Printf("ERROR: %s/FromPaths: %v", packageName, retErr)
+		return
+	}
+
+	// exclude rules
+	x, err := newExcluder(rootPath, opts)
+	if err != nil {
+		retErr = err
+		log.Printf("ERROR: %s/FromPaths: %v", packageName, retErr)
+		return
+	}
+
+	// progress (optional)
+	opts.Progress.Start(progress.StageScan, 0, 0)
+	defer opts.Progress.Finish()
+
+	// new db is a clone of the old db (first level)
+	// The new db is also the reference for unchanged elements, so paths below an already scanned folder are not scanned twice.
+	newDB = NewDb()
+	for k, v := range oldDB.VFiles {
+		newDB.VFiles[k] = v
+	}
+	resetOldBundles(&newDB)
+	newDB.Bundles = nil
+
+	// init
+	countNewOrUpdate := 0
+	countRemoved := 0
+	countSkipped := 0
+	jobs := make([]*_ScanJob, 0)
+	parents := make(map[string]bool)
+
+	// changed rules: rescan the folder of the ignore file
+	scanPaths := make([]string, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		if path.Base(relPath) == IgnoreFile {
+			relPath = path.Dir(relPath)
+		}
+		scanPaths = append(scanPaths, relPath)
+	}
+
+	// scan all paths
+	for _, relPath := range cleanRelPaths(scanPaths) {
+		absPath := filepath.Join(rootPath, filepath.FromSlash(relPath))
+
+		// deleted or excluded: remove element and all sub elements
+		_, statErr := os.Lstat(absPath)
+		excluded := false
+		if statErr == nil {
+			if excluded, retErr = x.excludedPath(relPath); retErr != nil {
+				log.Printf("ERROR: %s/FromPaths: '%s': %v", packageName, relPath, retErr)
+				return
+			}
+			if excluded {
+				countSkipped++
+			}
+		}
+		if (os.IsNotExist(statErr) || excluded) && relPath != "." {
+			n := removeTree(&newDB, relPath, nil)
+			if n > 0 {
+				countRemoved += n
+				changed = true
+				if debug {
+					log.Printf("DEBUG: %s/FromPaths: removed: '%s' (%d elements)", packageName, relPath, n)
+				}
+			}
+			parents[path.Dir(relPath)] = true
+			continue
+		}
+
+		// walk file or folder
+		seen := make(map[string]bool)
+		retErr = filepath.Walk(absPath, func(absPath string, info os.FileInfo, err error) error {
+			// WalkFunc errors
+			if err != nil {
+				return err
+			}
+
+			// relative path
+			relPath, err := toRelPath(rootPath, absPath)
+			if err != nil {
+				return err
+			}
+
+			// skip excluded sub elements
+			if ex, err := x.excluded(absPath, relPath, info); err != nil {
+				return err
+			} else if ex {
+				countSkipped++
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// scan element (if new or changed)
+			e, job, newOrUpdate, err := scanElement(absPath, relPath, info, newDB, x, debug)
+			if err != nil {
+				return err
+			}
+			if job != nil {
+				jobs = append(jobs, job)
+				opts.Progress.Found(relPath, info.Size())
+			}
+			if newOrUpdate {
+				countNewOrUpdate++
+				changed = true
+			}
+			seen[relPath] = true
+			newDB.VFiles[relPath] = e
+			return nil
+		})
+		if retErr != nil {
+			log.Printf("ERROR: %s/FromPaths: '%s': %v", packageName, relPath, retErr)
+			return
+		}
+
+		// sub elements no longer available
+		if n := removeTree(&newDB, relPath, seen); n > 0 {
+			countRemoved += n
+			changed = true
+		}
+		if relPath != "." {
+			parents[path.Dir(relPath)] = true
+		}
+	}
+
+	// update parent folders (folder content and new folders up to the root)
+	for len(parents) > 0 {
+		// next parent
+		list := make([]string, 0, len(parents))
+		for p := range parents {
+			list = append(list, p)
+		}
+		sort.Strings(list)
+		relPath := list[len(list)-1]
+		delete(parents, relPath)
+
+		absPath := filepath.Join(rootPath, filepath.FromSlash(relPath))
+		info, err := os.Lstat(absPath)
+		if os.IsNotExist(err) {
+			continue // parent folder was also removed
+		}
+		if err != nil {
+			retErr = err
+			log.Printf("ERROR: %s/FromPaths: '%s': %v", packageName, relPath, retErr)
+			return
+		}
+
+		// excluded parent folder (e.g. an event below a cache folder)
+		if excluded, err := x.excludedPath(relPath); err != nil {
+			retErr = err
+			log.Printf("ERROR: %s/FromPaths: '%s': %v", packageName, relPath, retErr)
+			return
+		} else if excluded {
+			if n := removeTree(&newDB, relPath, nil); n > 0 {
+				countRemoved += n
+				changed = true
+				parents[path.Dir(relPath)] = true
+			}
+			continue
+		}
+
+		_, exists := newDB.VFiles[relPath]
+		e, job, newOrUpdate, err := scanElement(absPath, relPath, info, newDB, x, debug)
+		if err != nil {
+			retErr = err
+			log.Printf("ERROR: %s/FromPaths: '%s': %v", packageName, relPath, retErr)
+			return
+		}
+		if job != nil {
+			jobs = append(jobs, job)
+			opts.Progress.Found(relPath, info.Size())
+		}
+		if newOrUpdate {
+			countNewOrUpdate++
+			changed = true
+		}
+		newDB.VFiles[relPath] = e
+
+		// new folder: the parent folder must also be updated
+		if !exists && relPath != "." {
+			parents[path.Dir(relPath)] = true
+		}
+	}
+
+	// scan new and changed files (workers)
+	if retErr = scanFiles(jobs, opts, keyFile, debug); retErr != nil {
+		log.Printf("ERROR: %s/FromPaths: %v", packageName, retErr)
+		return
+	}
+	for _, job := range jobs {
+		newDB.VFiles[job.relPath] = job.file
+	}
+
+	// statistic
+	summary = fmt.Sprintf("SCAN: error=%v, sum=%d, changed=%v, newOrUpdate=%d, removed=%d, skipped=%d", retErr, len(newDB.VFiles), changed, countNewOrUpdate, countRemoved, countSkipped)
+	if debug && changed {
+		log.Printf("DEBUG: %s/FromPaths: %s", packageName, summary)
+	}
+	return
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// cleanRelPaths returns the sorted and cleaned paths without duplicates and paths outside the root folder.
+func cleanRelPaths(relPaths []string) []string {
+	set := make(map[string]bool)
+	for _, p := range relPaths {
+		p = path.Clean(norm.NFC.String(strings.ReplaceAll(p, "\\", "/")))
+		if p == ".." || strings.HasPrefix(p, "../") || strings.HasPrefix(p, "/") {
+			continue
+		}
+		set[p] = true
+	}
+	list := make([]string, 0, len(set))
+	for p := range set {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// removeTree removes the element and all sub elements that are not in the keep list (nil: remove all).
+// Returns the number of removed elements.
+func removeTree(db *Db, relPath string, keep map[string]bool) int {
+	prefix := relPath + "/"
+	if relPath == "." {
+		prefix = ""
+	}
+	n := 0
+	for k := range db.VFiles {
+		if (k == relPath || strings.HasPrefix(k, prefix)) && !keep[k] {
+			delete(db.VFiles, k)
+			n++
+		}
+	}
+	return n
+}
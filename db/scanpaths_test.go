@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFromPaths(t *testing.T) {
+	keyFile, err := enc.LoadKeyFile(path.Join(os.TempDir(), "testCryptKeyFile.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test folder
+	root, _ := ioutil.TempDir("", "fromPathsTest")
+	defer os.RemoveAll(root)
+	_ = os.MkdirAll(path.Join(root, "a", "b"), 0700)
+	_ = os.MkdirAll(path.Join(root, "c"), 0700)
+	_ = ioutil.WriteFile(path.Join(root, "a", "1.txt"), []byte("one"), 0600)
+	_ = ioutil.WriteFile(path.Join(root, "a", "b", "2.txt"), []byte("two"), 0600)
+	_ = ioutil.WriteFile(path.Join(root, "c", "3.txt"), []byte("three"), 0600)
+
+	// full scan
+	db1, _, _, err := db.FromScan(root, db.NewDb(), impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: no change
+	db2, changed, _, err := db.FromPaths(root, db1, []string{"a/1.txt", "c"}, impl.DebugOff, keyFile)
+	if err != nil || changed || !reflect.DeepEqual(db1.VFiles, db2.VFiles) {
+		t.Fatalf("changed=%v, err=%v", changed, err)
+	}
+
+	// changes: new file in a new folder, changed file, removed folder
+	time.Sleep(1100 * time.Millisecond) // new mtime
+	_ = os.MkdirAll(path.Join(root, "c", "d", "e"), 0700)
+	_ = ioutil.WriteFile(path.Join(root, "c", "d", "e", "4.txt"), []byte("four"), 0600)
+	_ = ioutil.WriteFile(path.Join(root, "a", "1.txt"), []byte("one!"), 0600)
+	_ = os.RemoveAll(path.Join(root, "a", "b"))
+
+	// TEST: partial scan == full scan
+	db3, changed, _, err := db.FromPaths(root, db1, []string{"c/d/e/4.txt", "a/1.txt", "a/b", "a/b/2.txt", "../x"}, impl.DebugHigh, keyFile)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v, err=%v", changed, err)
+	}
+	full, _, _, err := db.FromScan(root, db1, impl.DebugOff, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(db3.VFiles, full.VFiles) {
+		t.Errorf("partial scan != full scan\n%v\n%v", db3.VFiles, full.VFiles)
+	}
+	if _, ok := db3.VFiles["a/b/2.txt"]; ok {
+		t.Error("removed file found")
+	}
+	if db3.VFiles["c/d/e/4.txt"].FileSize != 4 || db3.VFiles["a/1.txt"].FileSize != 4 {
+		t.Error("wrong file size")
+	}
+
+	// TEST: reader key
+	if _, _, _, err := db.FromPaths(root, db1, []string{"a"}, impl.DebugOff, nil); err == nil {
+		t.Error("no error")
+	}
+}
@@ -113,16 +113,36 @@ func ToWriter(db Db, key []byte, w io.Writer) error {
 }
 
 // ToFile serializes, compresses, encrypts and writes a database to a file.
+// The db is written to a temp file first and then renamed, so an interruption never leaves a half-written db file.
 func ToFile(db Db, key []byte, path string) error {
-	// overwrite db file
-	fh, err := os.Create(path)
+	tmpPath := path + ".tmp"
+	fh, err := os.Create(tmpPath)
 	if err != nil {
 		log.Printf("ERROR: %s/ToFile: %v", packageName, err)
 		return err
 	}
-	defer fh.Close()
 
-	return ToWriter(db, key, fh)
+	// write temp file
+	err = ToWriter(db, key, fh)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		log.Printf("ERROR: %s/ToFile: %v", packageName, err)
+		return err
+	}
+
+	// replace db file
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		log.Printf("ERROR: %s/ToFile: %v", packageName, err)
+		return err
+	}
+	return nil
 }
 
 // ------------------------------------------------------------------------------------------------------------------ //
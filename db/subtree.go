@@ -0,0 +1,96 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Subtree returns a standalone database with all elements below relPrefix (e.g. for sharing a folder).
+// The folder relPrefix becomes the root folder '.' of the new database, so all paths are shortened.
+// A bundle is only taken over if all bundle files are in the subtree, because the bundle key decrypts
+// the whole bundle. Otherwise the bundle link is removed and the files are read from their parts.
+// There are NO db changes!
+func (db *Db) Subtree(relPrefix string) (Db, error) {
+	// prefix
+	prefix := strings.Trim(relPrefix, "/")
+	if prefix == "" {
+		prefix = "."
+	}
+	root, ok := db.VFiles[prefix]
+	if !ok {
+		return NewDb(), fmt.Errorf("path not found: '%s'", prefix)
+	}
+	if !root.IsDir {
+		return NewDb(), errors.New("path is not a folder")
+	}
+
+	// new path (ok=false: outside of the subtree)
+	newPath := func(relPath string) (string, bool) {
+		switch {
+		case prefix == ".":
+			return relPath, true
+		case relPath == prefix:
+			return ".", true
+		case strings.HasPrefix(relPath, prefix+"/"):
+			return relPath[len(prefix)+1:], true
+		default:
+			return "", false
+		}
+	}
+
+	// copy elements
+	sub := NewDb()
+	for relPath, vFile := range db.VFiles {
+		p, ok := newPath(relPath)
+		if !ok {
+			continue
+		}
+		vFile.RelPath = p
+		if vFile.IsDir {
+			content := make([]FolderEl, 0, len(vFile.FolderContent))
+			for _, el := range vFile.FolderContent {
+				if elPath, ok := newPath(el.RelPath); ok {
+					content = append(content, FolderEl{RelPath: elPath, IsDir: el.IsDir})
+				}
+			}
+			vFile.FolderContent = content
+		}
+		sub.VFiles[p] = vFile
+	}
+
+	// copy bundles (only complete bundles)
+	for relPath, vFile := range sub.VFiles {
+		if vFile.AlsoInBundle == "" {
+			continue
+		}
+		if _, ok := sub.Bundles[vFile.AlsoInBundle]; ok {
+			continue // bundle already copied
+		}
+
+		// check bundle content
+		bundle, ok := db.Bundles[vFile.AlsoInBundle]
+		content := make([]string, 0, len(bundle.Content))
+		for _, id := range bundle.Content {
+			if p, inside := newPath(id); inside {
+				content = append(content, p)
+			} else {
+				ok = false
+				break
+			}
+		}
+
+		// copy bundle or remove link
+		if ok {
+			if sub.Bundles == nil {
+				sub.Bundles = make(map[string]Bundle)
+			}
+			sub.Bundles[bundle.Id()] = Bundle{VFilePart: bundle.VFilePart, Content: content}
+		} else {
+			vFile.AlsoInBundle = ""
+			sub.VFiles[relPath] = vFile
+		}
+	}
+
+	return sub, nil
+}
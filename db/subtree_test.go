@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"github.com/SchnorcherSepp/splitfs/db"
+	"testing"
+)
+
+func TestDb_Subtree(t *testing.T) {
+	part := func(name string) []db.VFilePart {
+		return []db.VFilePart{{StorageName: name, StorageSize: 10}}
+	}
+	vDb := db.NewDb()
+	vDb.VFiles["."] = db.VirtFile{RelPath: ".", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "a", IsDir: true}, {RelPath: "b", IsDir: true}}}
+	vDb.VFiles["a"] = db.VirtFile{RelPath: "a", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "a/1.txt"}, {RelPath: "a/2.txt"}, {RelPath: "a/sub", IsDir: true}}}
+	vDb.VFiles["a/1.txt"] = db.VirtFile{RelPath: "a/1.txt", FileSize: 10, Parts: part("p1"), AlsoInBundle: "B_1"}
+	vDb.VFiles["a/2.txt"] = db.VirtFile{RelPath: "a/2.txt", FileSize: 10, Parts: part("p2"), AlsoInBundle: "B_1"}
+	vDb.VFiles["a/sub"] = db.VirtFile{RelPath: "a/sub", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "a/sub/3.txt"}}}
+	vDb.VFiles["a/sub/3.txt"] = db.VirtFile{RelPath: "a/sub/3.txt", FileSize: 10, Parts: part("p3"), AlsoInBundle: "B_2"}
+	vDb.VFiles["b"] = db.VirtFile{RelPath: "b", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "b/4.txt"}}}
+	vDb.VFiles["b/4.txt"] = db.VirtFile{RelPath: "b/4.txt", FileSize: 10, Parts: part("p4"), AlsoInBundle: "B_2"}
+	vDb.Bundles = map[string]db.Bundle{
+		"B_1": {VFilePart: db.VFilePart{StorageName: "B_1", StorageSize: 20}, Content: []string{"a/1.txt", "a/2.txt"}},
+		"B_2": {VFilePart: db.VFilePart{StorageName: "B_2", StorageSize: 20}, Content: []string{"a/sub/3.txt", "b/4.txt"}},
+	}
+
+	// TEST: errors
+	if _, err := vDb.Subtree("x"); err == nil {
+		t.Error("no error")
+	}
+	if _, err := vDb.Subtree("a/1.txt"); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: subtree 'a'
+	sub, err := vDb.Subtree("/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.VFiles) != 5 || len(sub.Bundles) != 1 {
+		t.Fatalf("wrong len: %d, %d", len(sub.VFiles), len(sub.Bundles))
+	}
+	root := sub.VFiles["."]
+	if !root.IsDir || root.RelPath != "." || len(root.FolderContent) != 3 || root.FolderContent[2].RelPath != "sub" {
+		t.Errorf("wrong root: %#v", root)
+	}
+	if f := sub.VFiles["sub/3.txt"]; f.RelPath != "sub/3.txt" || f.AlsoInBundle != "" || f.Parts[0].StorageName != "p3" {
+		t.Errorf("wrong file: %#v", f)
+	}
+	if b := sub.Bundles["B_1"]; b.Content[0] != "1.txt" || b.Content[1] != "2.txt" || sub.VFiles["1.txt"].AlsoInBundle != "B_1" {
+		t.Errorf("wrong bundle: %#v", b)
+	}
+
+	// TEST: no db changes
+	if vDb.VFiles["a/sub/3.txt"].AlsoInBundle != "B_2" || vDb.VFiles["a"].FolderContent[0].RelPath != "a/1.txt" || len(vDb.Bundles) != 2 {
+		t.Error("db changed")
+	}
+
+	// TEST: whole db
+	if sub, _ := vDb.Subtree("/"); len(sub.VFiles) != len(vDb.VFiles) || len(sub.Bundles) != 2 {
+		t.Error("wrong subtree")
+	}
+}
@@ -36,6 +36,8 @@ type VirtFile struct {
 
 	// Parts (IF FILE) is the list of parts that make up the virtual file.
 	// If the file size is 0, there are no parts.
+	// The parts have the size PartSize (except the last part) or variable sizes with content-defined chunking
+	// (@see ChunkOptions). The plain offset of a part is the sum of the previous sizes (@see PartRange).
 	Parts []VFilePart
 
 	// UseCompression (IF FILE) determines whether the data is compressed or not.
@@ -61,6 +63,18 @@ func (vf *VirtFile) Name() string {
 	return path.Base(vf.RelPath)
 }
 
+// PartRange returns the plain offset and size of the part partNo in the file.
+// Without compression the plain size is the StorageSize. A compressed file has only one part (the whole file).
+func (vf *VirtFile) PartRange(partNo int) (off, n int64) {
+	if vf.UseCompression {
+		return 0, vf.FileSize
+	}
+	for _, part := range vf.Parts[:partNo] {
+		off += part.StorageSize
+	}
+	return off, vf.Parts[partNo].StorageSize
+}
+
 // --------- FolderContentEl description -----------------------------------------
 
 // FolderEl is the list of folder sub elements.
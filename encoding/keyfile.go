@@ -12,7 +12,8 @@ import (
 	"os"
 )
 
-// KeyFile manages the secret keys
+// KeyFile manages the secret keys.
+// A reader key file contains only the indexSecret (@see ExportReaderKey).
 type KeyFile struct {
 	cryptSecret []byte // for data encryption
 	hashSecret  []byte // for filename encryption
@@ -20,14 +21,38 @@ type KeyFile struct {
 }
 
 // LoadKeyFile read the 128 bytes key file and generate the secrets.
+// Passphrase protected key files can't be loaded with this function (@see LoadKeyFileWithPassphrase).
 func LoadKeyFile(path string) (*KeyFile, error) {
+	return LoadKeyFileWithPassphrase(path, nil)
+}
 
+// LoadKeyFileWithPassphrase read a key file and generate the secrets.
+// The key file is either the legacy 128 bytes file, a passphrase protected key file (@see CreateProtectedKeyFile)
+// or a reader key file (@see ExportReaderKey).
+// The function passphrase is only called for protected key files.
+func LoadKeyFileWithPassphrase(path string, passphrase PassphraseFunc) (*KeyFile, error) {
 	// read key file
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// reader key file
+	if IsReaderKey(b) {
+		return parseReaderKey(b)
+	}
+
+	// master key file
+	secret, err := secretFromBytes(b, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyFile(secret)
+}
+
+// newKeyFile generate the secrets from the 128 bytes key.
+func newKeyFile(b []byte) (*KeyFile, error) {
+
 	// file size == 128 bytes
 	if len(b) != 128 {
 		return nil, errors.New("key file must be exactly 128 bytes long")
@@ -44,6 +69,41 @@ func LoadKeyFile(path string) (*KeyFile, error) {
 	return k, nil
 }
 
+// readSecret reads the 128 bytes key from a legacy or a protected key file.
+func readSecret(path string, passphrase PassphraseFunc) ([]byte, error) {
+
+	// read key file
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// reader key file
+	if IsReaderKey(b) {
+		return nil, errors.New("reader key file: the master key file is required")
+	}
+	return secretFromBytes(b, passphrase)
+}
+
+// secretFromBytes returns the 128 bytes key from the content of a legacy or a protected key file.
+func secretFromBytes(b []byte, passphrase PassphraseFunc) ([]byte, error) {
+
+	// legacy key file (raw bytes)
+	if !IsProtected(b) {
+		return b, nil
+	}
+
+	// protected key file
+	if passphrase == nil {
+		return nil, errors.New("key file is passphrase protected")
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return unprotect(b, pass)
+}
+
 // DataKey calculates the key for data.
 // The key is derived from the unencrypted original data (plain SHA512).
 // return 32 bytes (AES 256 key)
@@ -65,11 +125,63 @@ func (k *KeyFile) CryptName(plainHash []byte) string {
 	return fmt.Sprintf("%x", key)
 }
 
+// Fingerprint identifies the key file without revealing it (SHA256 of the IndexKey, first 8 bytes).
+// Example: 1a2b-3c4d-5e6f-7a8b
+func (k *KeyFile) Fingerprint() string {
+	h := sha256.Sum256(k.IndexKey())
+	return fmt.Sprintf("%x-%x-%x-%x", h[0:2], h[2:4], h[4:6], h[6:8])
+}
+
 //--------------------------------------------------------------------------------------------------------------------//
 
 // CreateKeyFile creates a new key file that contains exactly 128 random bytes.
 // Existing files are NOT overwritten.
 func CreateKeyFile(path string) error {
+	return createKeyFile(path, nil)
+}
+
+// CreateProtectedKeyFile creates a new key file with 128 random bytes, encrypted with the passphrase.
+// Existing files are NOT overwritten.
+func CreateProtectedKeyFile(path string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+	return createKeyFile(path, passphrase)
+}
+
+// ChangePassphrase encrypts the key file with a new passphrase.
+// A legacy key file (raw bytes) becomes a protected key file.
+// The file is replaced atomically.
+func ChangePassphrase(path string, oldPassphrase PassphraseFunc, newPassphrase []byte) error {
+	if len(newPassphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+
+	// read old key
+	secret, err := readSecret(path, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := newKeyFile(secret); err != nil {
+		return err
+	}
+
+	// encrypt with the new passphrase
+	b, err := protect(secret, newPassphrase)
+	if err != nil {
+		return err
+	}
+
+	// write temp file and replace the key file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// createKeyFile creates a new key file with 128 random bytes (passphrase=nil: legacy key file).
+func createKeyFile(path string, passphrase []byte) error {
 	// random key
 	randKey := make([]byte, 128)
 	n, err := io.ReadFull(rand.Reader, randKey)
@@ -80,24 +192,45 @@ func CreateKeyFile(path string) error {
 		return errors.New("can't create 128 byte key")
 	}
 
+	// write key file
+	_, err = writeKeyFile(path, randKey, passphrase)
+	return err
+}
+
+// writeKeyFile writes the 128 bytes key to a new key file (passphrase=nil: legacy key file).
+// Existing files are NOT overwritten. The key file is loaded again as a read test.
+func writeKeyFile(path string, secret, passphrase []byte) (*KeyFile, error) {
+	if len(secret) != 128 {
+		return nil, errors.New("key must be exactly 128 bytes long")
+	}
+
 	// don't overwrite files
 	if _, err := os.Stat(path); err == nil {
-		return errors.New("file already exists")
+		return nil, errors.New("file already exists")
+	}
+
+	// protect key (optional)
+	b := secret
+	if passphrase != nil {
+		var err error
+		b, err = protect(secret, passphrase)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// write key file
-	err = ioutil.WriteFile(path, randKey, 0600)
-	if err != nil {
-		return err
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return nil, err
 	}
 
 	// read test
-	k, err := LoadKeyFile(path)
+	k, err := LoadKeyFileWithPassphrase(path, func() ([]byte, error) { return passphrase, nil })
 	if err != nil {
-		return err
+		return nil, err
 	}
 	k.IndexKey() // get key
 
 	// success
-	return nil
+	return k, nil
 }
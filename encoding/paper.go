@@ -0,0 +1,225 @@
+package enc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Paper key format (version 1):
+//
+//   SPLITFS PAPER KEY v1
+//   01: XXXX XXXX XXXX XXXX XXXX XXXX XX  CCCC
+//   ...
+//   08: XXXX XXXX XXXX XXXX XXXX XXXX XX  CCCC
+//   FINGERPRINT: 1a2b-3c4d-5e6f-7a8b
+//
+// Each line contains 16 bytes of the key as base32 (26 chars) and a checksum (4 chars) over
+// the line number and the data chars. The fingerprint is the IndexKey fingerprint (@see KeyFile.Fingerprint).
+const (
+	paperHeader      = "SPLITFS PAPER KEY v1"
+	paperFingerprint = "FINGERPRINT:"
+	paperLines       = 8
+	paperLineBytes   = 16
+	paperDataChars   = 26
+	paperCheckChars  = 4
+)
+
+// paperEncoding is base32 without padding (A-Z, 2-7)
+var paperEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ExportPaper returns the key file as printable text with checksums (@see ImportPaper).
+func ExportPaper(path string, passphrase PassphraseFunc) (string, error) {
+	secret, err := readSecret(path, passphrase)
+	if err != nil {
+		return "", err
+	}
+	k, err := newKeyFile(secret)
+	if err != nil {
+		return "", err
+	}
+	return encodePaper(secret, k.Fingerprint()), nil
+}
+
+// ImportPaper decodes the paper key text and writes a new key file (passphrase=nil: legacy key file).
+// Typos are located by the line checksums. The fingerprint on the paper must match the imported key.
+// Existing files are NOT overwritten.
+func ImportPaper(text, path string, passphrase []byte) (*KeyFile, error) {
+	secret, fingerprint, err := decodePaper(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// compare fingerprints
+	k, err := newKeyFile(secret)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint != "" && fingerprint != k.Fingerprint() {
+		return nil, fmt.Errorf("fingerprint mismatch: paper=%s, key=%s", fingerprint, k.Fingerprint())
+	}
+
+	// write key file and compare again
+	k2, err := writeKeyFile(path, secret, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if k2.Fingerprint() != k.Fingerprint() {
+		return nil, errors.New("fingerprint mismatch after import")
+	}
+	return k2, nil
+}
+
+// encodePaper encodes the 128 bytes key as paper key text.
+func encodePaper(secret []byte, fingerprint string) string {
+	sb := new(strings.Builder)
+	sb.WriteString(paperHeader + "\n")
+	for i := 0; i < paperLines; i++ {
+		data := paperEncoding.EncodeToString(secret[i*paperLineBytes : (i+1)*paperLineBytes])
+		fmt.Fprintf(sb, "%02d: ", i+1)
+		for j := 0; j < len(data); j += 4 {
+			end := j + 4
+			if end > len(data) {
+				end = len(data)
+			}
+			sb.WriteString(data[j:end])
+			if end < len(data) {
+				sb.WriteString(" ")
+			}
+		}
+		fmt.Fprintf(sb, "  %s\n", paperChecksum(i+1, data))
+	}
+	fmt.Fprintf(sb, "%s %s\n", paperFingerprint, fingerprint)
+	return sb.String()
+}
+
+// decodePaper decodes the paper key text and returns the 128 bytes key and the fingerprint (optional).
+func decodePaper(text string) ([]byte, string, error) {
+	lines := make(map[int]string)
+	fingerprint := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		upper := strings.ToUpper(line)
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(upper, "SPLITFS PAPER KEY"):
+			if upper != strings.ToUpper(paperHeader) {
+				return nil, "", fmt.Errorf("unsupported paper key version: '%s'", line)
+			}
+		case strings.HasPrefix(upper, paperFingerprint):
+			fingerprint = strings.ToLower(strings.TrimSpace(line[len(paperFingerprint):]))
+		default:
+			// line number
+			pos := strings.Index(line, ":")
+			if pos < 0 {
+				return nil, "", fmt.Errorf("invalid line (no line number): '%s'", line)
+			}
+			no, err := strconv.Atoi(strings.TrimSpace(line[:pos]))
+			if err != nil || no < 1 || no > paperLines {
+				return nil, "", fmt.Errorf("invalid line number: '%s'", line)
+			}
+			if _, ok := lines[no]; ok {
+				return nil, "", fmt.Errorf("line %02d: duplicate line", no)
+			}
+			lines[no] = normalizePaper(line[pos+1:])
+		}
+	}
+
+	// decode lines
+	secret := make([]byte, 0, paperLines*paperLineBytes)
+	for no := 1; no <= paperLines; no++ {
+		chars, ok := lines[no]
+		if !ok {
+			return nil, "", fmt.Errorf("line %02d: missing", no)
+		}
+		b, err := decodePaperLine(no, chars)
+		if err != nil {
+			return nil, "", fmt.Errorf("line %02d: %v", no, err)
+		}
+		secret = append(secret, b...)
+	}
+	return secret, fingerprint, nil
+}
+
+// decodePaperLine checks the line checksum and decodes the data.
+func decodePaperLine(no int, chars string) ([]byte, error) {
+	if len(chars) != paperDataChars+paperCheckChars {
+		return nil, fmt.Errorf("wrong length: %d chars instead of %d", len(chars), paperDataChars+paperCheckChars)
+	}
+	for i, c := range chars {
+		if !strings.ContainsRune(paperAlphabet, c) {
+			return nil, fmt.Errorf("invalid char '%c' at %s", c, paperPos(i))
+		}
+	}
+	data, check := chars[:paperDataChars], chars[paperDataChars:]
+
+	// checksum
+	if paperChecksum(no, data) != check {
+		return nil, paperTypo(no, data, check)
+	}
+
+	// decode
+	b, err := paperEncoding.DecodeString(data)
+	if err != nil || len(b) != paperLineBytes {
+		return nil, fmt.Errorf("decode error: %v", err)
+	}
+	return b, nil
+}
+
+// paperAlphabet is the base32 alphabet
+const paperAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// paperChecksum calculates the checksum (4 chars) of a line.
+func paperChecksum(no int, data string) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%02d|%s", paperHeader, no, data)))
+	return paperEncoding.EncodeToString(h[:3])[:paperCheckChars]
+}
+
+// paperTypo tries to locate a single typo in a line with a wrong checksum.
+func paperTypo(no int, data, check string) error {
+	found := make([]string, 0)
+	chars := data + check
+	for i := range chars {
+		for _, c := range paperAlphabet {
+			if byte(c) == chars[i] {
+				continue
+			}
+			fixed := chars[:i] + string(c) + chars[i+1:]
+			if paperChecksum(no, fixed[:paperDataChars]) == fixed[paperDataChars:] {
+				found = append(found, fmt.Sprintf("'%c' instead of '%c' at %s", c, chars[i], paperPos(i)))
+			}
+		}
+	}
+	if len(found) == 1 {
+		return fmt.Errorf("checksum mismatch: probably %s", found[0])
+	}
+	return errors.New("checksum mismatch: more than one typo")
+}
+
+// paperPos returns the position of a char in the line (group and char, 1-based).
+func paperPos(i int) string {
+	if i >= paperDataChars {
+		return fmt.Sprintf("checksum char %d", i-paperDataChars+1)
+	}
+	return fmt.Sprintf("group %d char %d", i/4+1, i%4+1)
+}
+
+// normalizePaper removes spaces and fixes common typos (lower case, 0->O, 1->I, 8->B).
+func normalizePaper(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer("0", "O", "1", "I", "8", "B").Replace(s)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '-' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,74 @@
+package enc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPaper(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "paperTest")
+	defer os.RemoveAll(dir)
+
+	// export
+	text, err := ExportPaper(testCryptKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) != 10 || lines[0] != paperHeader || !strings.HasPrefix(lines[9], paperFingerprint) {
+		t.Fatalf("wrong format:\n%s", text)
+	}
+
+	// TEST: import (lower case, without header)
+	p := path.Join(dir, "key.dat")
+	k, err := ImportPaper(strings.ToLower(strings.Join(lines[1:], "\n")), p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.indexSecret, testCryptIndexSecret) {
+		t.Error("wrong key")
+	}
+	b1, _ := ioutil.ReadFile(testCryptKeyFile)
+	b2, _ := ioutil.ReadFile(p)
+	if !bytes.Equal(b1, b2) {
+		t.Error("wrong key file")
+	}
+
+	// TEST: file exists
+	if _, err := ImportPaper(text, p, nil); err == nil {
+		t.Error("file overwritten")
+	}
+
+	// TEST: import protected
+	if _, err := ImportPaper(text, path.Join(dir, "protected.dat"), []byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	if text2, err := ExportPaper(path.Join(dir, "protected.dat"), staticPassphrase("pass")); err != nil || text2 != text {
+		t.Errorf("err=%v, text=%s", err, text2)
+	}
+
+	// TEST: single typo is located
+	typo := []byte(text)
+	pos := strings.Index(text, "03: ") + 4 + 5 // group 2 char 1
+	if typo[pos] == 'A' {
+		typo[pos] = 'B'
+	} else {
+		typo[pos] = 'A'
+	}
+	_, err = ImportPaper(string(typo), path.Join(dir, "typo.dat"), nil)
+	if err == nil || !strings.Contains(err.Error(), "line 03") || !strings.Contains(err.Error(), "group 2 char 1") {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// TEST: missing line, wrong fingerprint
+	if _, err := ImportPaper(strings.Replace(text, lines[5], "", 1), path.Join(dir, "x.dat"), nil); err == nil || !strings.Contains(err.Error(), "line 05: missing") {
+		t.Errorf("wrong error: %v", err)
+	}
+	if _, err := ImportPaper(strings.Replace(text, lines[9], paperFingerprint+" 0000-0000-0000-0000", 1), path.Join(dir, "x.dat"), nil); err == nil {
+		t.Error("no error")
+	}
+}
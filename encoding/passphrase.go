@@ -0,0 +1,104 @@
+package enc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PassphraseFunc returns the passphrase for a protected key file.
+// The function is only called if the passphrase is needed.
+type PassphraseFunc func() ([]byte, error)
+
+// PassphraseFromEnv reads the passphrase from the environment variable.
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func() ([]byte, error) {
+		pass, ok := os.LookupEnv(name)
+		if !ok || pass == "" {
+			return nil, fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return []byte(pass), nil
+	}
+}
+
+// PassphraseFromFd reads the passphrase (first line) from the file descriptor (e.g. a pipe in a container).
+// The file descriptor is read only once, further calls return the same passphrase.
+func PassphraseFromFd(fd uintptr) PassphraseFunc {
+	var once sync.Once
+	var pass []byte
+	var err error
+	return func() ([]byte, error) {
+		once.Do(func() {
+			f := os.NewFile(fd, fmt.Sprintf("fd%d", fd))
+			if f == nil {
+				err = fmt.Errorf("invalid file descriptor %d", fd)
+				return
+			}
+			defer f.Close()
+			var line string
+			line, err = bufio.NewReader(f).ReadString('\n')
+			if err != nil && line == "" {
+				err = fmt.Errorf("read passphrase from fd %d: %v", fd, err)
+				return
+			}
+			err = nil
+			pass = []byte(strings.TrimRight(line, "\r\n"))
+			if len(pass) == 0 {
+				err = fmt.Errorf("empty passphrase from fd %d", fd)
+			}
+		})
+		return pass, err
+	}
+}
+
+// PassphraseFromTerminal prompts for the passphrase on the terminal (stdin) without echo.
+// The prompt is written to stderr. If confirm is true, the passphrase must be entered twice.
+func PassphraseFromTerminal(prompt string, confirm bool) PassphraseFunc {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errors.New("no terminal for the passphrase prompt")
+		}
+
+		// read passphrase
+		fmt.Fprint(os.Stderr, prompt)
+		pass, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if len(pass) == 0 {
+			return nil, errors.New("empty passphrase")
+		}
+
+		// confirm
+		if confirm {
+			fmt.Fprint(os.Stderr, "Repeat: ")
+			pass2, err := term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+			if string(pass) != string(pass2) {
+				return nil, errors.New("passphrases do not match")
+			}
+		}
+		return pass, nil
+	}
+}
+
+// PassphraseSource selects the passphrase source in this order:
+// the file descriptor (fd >= 0), the environment variable (if set) and finally the terminal prompt.
+func PassphraseSource(envName string, fd int, prompt string, confirm bool) PassphraseFunc {
+	if fd >= 0 {
+		return PassphraseFromFd(uintptr(fd))
+	}
+	if envName != "" && os.Getenv(envName) != "" {
+		return PassphraseFromEnv(envName)
+	}
+	return PassphraseFromTerminal(prompt, confirm)
+}
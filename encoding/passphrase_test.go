@@ -0,0 +1,59 @@
+package enc
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPassphraseFromEnv(t *testing.T) {
+	_ = os.Setenv("SPLITFS_TEST_PASSPHRASE", "secret")
+	defer os.Unsetenv("SPLITFS_TEST_PASSPHRASE")
+
+	if p, err := PassphraseFromEnv("SPLITFS_TEST_PASSPHRASE")(); err != nil || string(p) != "secret" {
+		t.Errorf("err=%v, p=%s", err, p)
+	}
+	if _, err := PassphraseFromEnv("SPLITFS_TEST_NOT_SET")(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestPassphraseFromFd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.WriteString("secret\r\nnext line")
+	_ = w.Close()
+
+	f := PassphraseFromFd(r.Fd())
+	for i := 0; i < 2; i++ { // read only once
+		if p, err := f(); err != nil || string(p) != "secret" {
+			t.Errorf("err=%v, p=%s", err, p)
+		}
+	}
+
+	// TEST: empty
+	r, w, _ = os.Pipe()
+	_ = w.Close()
+	if _, err := PassphraseFromFd(r.Fd())(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestPassphraseSource(t *testing.T) {
+	_ = os.Setenv("SPLITFS_TEST_PASSPHRASE", "secret")
+	defer os.Unsetenv("SPLITFS_TEST_PASSPHRASE")
+
+	// env
+	if p, err := PassphraseSource("SPLITFS_TEST_PASSPHRASE", -1, "", false)(); err != nil || string(p) != "secret" {
+		t.Errorf("err=%v, p=%s", err, p)
+	}
+
+	// fd before env
+	r, w, _ := os.Pipe()
+	_, _ = w.WriteString("fd secret\n")
+	_ = w.Close()
+	if p, err := PassphraseSource("SPLITFS_TEST_PASSPHRASE", int(r.Fd()), "", false)(); err != nil || string(p) != "fd secret" {
+		t.Errorf("err=%v, p=%s", err, p)
+	}
+}
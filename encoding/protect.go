@@ -0,0 +1,120 @@
+package enc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"io"
+)
+
+// Protected key file format (version 1):
+//
+//   magic   8 bytes  "SPLITFS\x00"
+//   version 1 byte   0x01
+//   time    4 bytes  argon2id iterations (big endian)
+//   memory  4 bytes  argon2id memory in KiB (big endian)
+//   threads 1 byte   argon2id parallelism
+//   salt    16 bytes random
+//   nonce   12 bytes random
+//   data    144 bytes AES-256-GCM encrypted 128 bytes key (the header is the additional data)
+const (
+	protectMagic   = "SPLITFS\x00"
+	protectVersion = 1
+	protectHeader  = 8 + 1 + 4 + 4 + 1 + 16 + 12
+	protectSize    = protectHeader + 128 + 16
+)
+
+// argon2id parameters for new key files (the parameters are stored in the key file)
+var (
+	protectTime    uint32 = 3
+	protectMemory  uint32 = 64 * 1024 // 64 MiB
+	protectThreads uint8  = 4
+)
+
+// maxProtectMemory limits the memory for loading key files (4 GiB).
+const maxProtectMemory = 4 * 1024 * 1024
+
+// IsProtected returns true, if the key file content is a passphrase protected key file.
+func IsProtected(b []byte) bool {
+	return len(b) >= len(protectMagic) && string(b[:len(protectMagic)]) == protectMagic
+}
+
+// protect encrypts the 128 bytes key with a passphrase.
+func protect(secret, passphrase []byte) ([]byte, error) {
+	if len(secret) != 128 {
+		return nil, errors.New("key must be exactly 128 bytes long")
+	}
+
+	// header
+	header := bytes.NewBuffer(make([]byte, 0, protectSize))
+	header.WriteString(protectMagic)
+	header.WriteByte(protectVersion)
+	_ = binary.Write(header, binary.BigEndian, protectTime)
+	_ = binary.Write(header, binary.BigEndian, protectMemory)
+	header.WriteByte(protectThreads)
+	random := make([]byte, 16+12) // salt and nonce
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	header.Write(random)
+
+	// encrypt
+	h := header.Bytes()
+	gcm, err := protectCipher(h, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(h, h[protectHeader-12:], secret, h), nil
+}
+
+// unprotect decrypts the 128 bytes key with a passphrase.
+func unprotect(b, passphrase []byte) ([]byte, error) {
+	if !IsProtected(b) {
+		return nil, errors.New("not a protected key file")
+	}
+	if len(b) < protectHeader || b[len(protectMagic)] != protectVersion {
+		return nil, fmt.Errorf("unsupported key file version")
+	}
+	if len(b) != protectSize {
+		return nil, errors.New("protected key file has the wrong size")
+	}
+
+	// decrypt
+	h := b[:protectHeader]
+	gcm, err := protectCipher(h, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := gcm.Open(nil, h[protectHeader-12:], b[protectHeader:], h)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupt key file")
+	}
+	return secret, nil
+}
+
+// protectCipher derives the AES-256-GCM cipher from the passphrase and the header parameters.
+func protectCipher(header, passphrase []byte) (cipher.AEAD, error) {
+	p := header[len(protectMagic)+1:]
+	t := binary.BigEndian.Uint32(p[0:4])
+	m := binary.BigEndian.Uint32(p[4:8])
+	threads := p[8]
+	salt := p[9 : 9+16]
+
+	// check parameters
+	if t == 0 || m < 8*uint32(threads) || m > maxProtectMemory || threads == 0 {
+		return nil, errors.New("invalid key file parameters")
+	}
+
+	// AES Galois Counter Mode
+	key := argon2.IDKey(passphrase, salt, t, m, threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,133 @@
+package enc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func init() {
+	// fast argon2id parameters for tests
+	protectTime = 1
+	protectMemory = 1024
+	protectThreads = 1
+}
+
+func staticPassphrase(s string) PassphraseFunc {
+	return func() ([]byte, error) { return []byte(s), nil }
+}
+
+func TestProtect(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 128)
+
+	// TEST: wrong secret size
+	if _, err := protect(secret[:127], []byte("pass")); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: protect and unprotect
+	b, err := protect(secret, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != protectSize || !IsProtected(b) || IsProtected(secret) {
+		t.Fatalf("wrong format: %d", len(b))
+	}
+	is, err := unprotect(b, []byte("pass"))
+	if err != nil || !bytes.Equal(is, secret) {
+		t.Fatalf("err=%v, %x", err, is)
+	}
+
+	// TEST: wrong passphrase
+	if _, err := unprotect(b, []byte("wrong")); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: header is authenticated (time parameter changed)
+	b2 := append([]byte{}, b...)
+	b2[12]++
+	if _, err := unprotect(b2, []byte("pass")); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: unknown version and wrong size
+	b2 = append([]byte{}, b...)
+	b2[8] = 2
+	if _, err := unprotect(b2, []byte("pass")); err == nil {
+		t.Error("no error")
+	}
+	if _, err := unprotect(b[:len(b)-1], []byte("pass")); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestCreateProtectedKeyFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "protectTest")
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "key.dat")
+
+	// TEST: empty passphrase
+	if err := CreateProtectedKeyFile(p, nil); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: create and load
+	if err := CreateProtectedKeyFile(p, []byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateProtectedKeyFile(p, []byte("pass")); err == nil {
+		t.Error("file overwritten")
+	}
+	if _, err := LoadKeyFile(p); err == nil {
+		t.Error("no error")
+	}
+	k1, err := LoadKeyFileWithPassphrase(p, staticPassphrase("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFileWithPassphrase(p, staticPassphrase("wrong")); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: change passphrase
+	if err := ChangePassphrase(p, staticPassphrase("wrong"), []byte("new")); err == nil {
+		t.Error("no error")
+	}
+	if err := ChangePassphrase(p, staticPassphrase("pass"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	k2, err := LoadKeyFileWithPassphrase(p, staticPassphrase("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k1.IndexKey(), k2.IndexKey()) {
+		t.Error("different keys")
+	}
+}
+
+func TestChangePassphrase_legacy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "protectTest")
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "key.dat")
+	b, _ := ioutil.ReadFile(testCryptKeyFile)
+	_ = ioutil.WriteFile(p, b, 0600)
+
+	// TEST: legacy files are loaded without passphrase
+	if _, err := LoadKeyFileWithPassphrase(p, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: protect legacy file
+	if err := ChangePassphrase(p, nil, []byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyFileWithPassphrase(p, staticPassphrase("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.indexSecret, testCryptIndexSecret) {
+		t.Error("wrong key")
+	}
+}
@@ -0,0 +1,103 @@
+package enc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Reader key file format (version 1):
+//
+//   magic       8 bytes  "SFSREADR"
+//   version     1 byte   0x01
+//   indexSecret 64 bytes
+//   checksum    8 bytes  first 8 bytes of SHA256 over all previous bytes
+//
+// A reader key file can only decrypt the index (@see KeyFile.IndexKey). All data keys are stored in the index,
+// so the reader key is enough to read all files, but it can't derive storage names and data keys for uploads.
+const (
+	readerMagic   = "SFSREADR"
+	readerVersion = 1
+	readerSize    = 8 + 1 + 64 + 8
+)
+
+// IsReaderKey returns true, if the key file content is a reader key file.
+func IsReaderKey(b []byte) bool {
+	return len(b) >= len(readerMagic) && string(b[:len(readerMagic)]) == readerMagic
+}
+
+// IsReader returns true, if the key was loaded from a reader key file.
+// A reader key has only the IndexKey, DataKey and CryptName must not be used.
+func (k *KeyFile) IsReader() bool {
+	return k.cryptSecret == nil || k.hashSecret == nil
+}
+
+// ExportReaderKey writes a reader key file with only the index secret (@see IsReaderKey).
+// Existing files are NOT overwritten.
+func ExportReaderKey(path string, passphrase PassphraseFunc, readerPath string) (*KeyFile, error) {
+	k, err := LoadKeyFileWithPassphrase(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return writeReaderKey(readerPath, k.indexSecret)
+}
+
+// CreateReaderKeyFile creates a reader key file with a new random index secret (e.g. for a partial index).
+// Existing files are NOT overwritten.
+func CreateReaderKeyFile(path string) (*KeyFile, error) {
+	indexSecret := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, indexSecret); err != nil {
+		return nil, err
+	}
+	return writeReaderKey(path, indexSecret)
+}
+
+// writeReaderKey writes a reader key file and loads it again as a read test.
+func writeReaderKey(path string, indexSecret []byte) (*KeyFile, error) {
+	// build reader key file
+	b := bytes.NewBuffer(make([]byte, 0, readerSize))
+	b.WriteString(readerMagic)
+	b.WriteByte(readerVersion)
+	b.Write(indexSecret)
+	h := sha256.Sum256(b.Bytes())
+	b.Write(h[:8])
+
+	// don't overwrite files
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.New("file already exists")
+	}
+
+	// write and read test
+	if err := ioutil.WriteFile(path, b.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+	r, err := LoadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(r.indexSecret, indexSecret) {
+		return nil, errors.New("reader key check fail")
+	}
+	return r, nil
+}
+
+// parseReaderKey loads a reader key file.
+func parseReaderKey(b []byte) (*KeyFile, error) {
+	if len(b) != readerSize {
+		return nil, errors.New("reader key file has the wrong size")
+	}
+	if b[len(readerMagic)] != readerVersion {
+		return nil, errors.New("unsupported reader key file version")
+	}
+	if h := sha256.Sum256(b[:readerSize-8]); !bytes.Equal(h[:8], b[readerSize-8:]) {
+		return nil, errors.New("reader key file checksum mismatch")
+	}
+
+	k := new(KeyFile)
+	k.indexSecret = append([]byte{}, b[len(readerMagic)+1:readerSize-8]...)
+	return k, nil
+}
@@ -0,0 +1,81 @@
+package enc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExportReaderKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "readerTest")
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "reader.dat")
+
+	// TEST: export
+	master, _ := LoadKeyFile(testCryptKeyFile)
+	r, err := ExportReaderKey(testCryptKeyFile, nil, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsReader() || master.IsReader() {
+		t.Error("wrong key type")
+	}
+	if _, err := ExportReaderKey(testCryptKeyFile, nil, p); err == nil {
+		t.Error("file overwritten")
+	}
+
+	// TEST: load (same IndexKey, no master secrets)
+	r, err = LoadKeyFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.IndexKey(), master.IndexKey()) || r.Fingerprint() != master.Fingerprint() {
+		t.Error("wrong IndexKey")
+	}
+	b, _ := ioutil.ReadFile(p)
+	if bytes.Contains(b, master.cryptSecret[:16]) || bytes.Contains(b, master.hashSecret[:16]) || len(b) != readerSize {
+		t.Error("master secret in reader key")
+	}
+
+	// TEST: the master key can't be exported from a reader key
+	if _, err := ExportPaper(p, nil); err == nil {
+		t.Error("no error")
+	}
+	if _, err := SplitKeyFile(p, nil, 3, 2); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: export from reader key
+	if _, err := ExportReaderKey(p, nil, path.Join(dir, "reader2.dat")); err != nil {
+		t.Error(err)
+	}
+
+	// TEST: corrupt reader key
+	b[20]++
+	_ = ioutil.WriteFile(p, b, 0600)
+	if _, err := LoadKeyFile(p); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestCreateReaderKeyFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "readerTest")
+	defer os.RemoveAll(dir)
+
+	k1, err := CreateReaderKeyFile(path.Join(dir, "a.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := CreateReaderKeyFile(path.Join(dir, "b.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k1.IsReader() || bytes.Equal(k1.IndexKey(), k2.IndexKey()) {
+		t.Error("wrong key")
+	}
+	if _, err := CreateReaderKeyFile(path.Join(dir, "a.dat")); err == nil {
+		t.Error("file overwritten")
+	}
+}
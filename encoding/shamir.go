@@ -0,0 +1,210 @@
+package enc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Key share format (version 1):
+//
+//   magic       8 bytes   "SFSSHARE"
+//   version     1 byte    0x01
+//   index       1 byte    share index (x coordinate, 1-255)
+//   threshold   1 byte    number of shares to recover the key (k)
+//   count       1 byte    number of created shares (n)
+//   fingerprint 8 bytes   first 8 bytes of the key fingerprint (@see KeyFile.Fingerprint)
+//   data        128 bytes share of the 128 bytes key (Shamir's secret sharing over GF(256))
+//   checksum    8 bytes   first 8 bytes of SHA256 over all previous bytes
+const (
+	shareMagic   = "SFSSHARE"
+	shareVersion = 1
+	shareSize    = 8 + 1 + 1 + 1 + 1 + 8 + 128 + 8
+)
+
+// SplitKeyFile splits the key file into n shares. Any k shares can recover the key file (@see CombineShares).
+// Returns the content of the share files.
+func SplitKeyFile(path string, passphrase PassphraseFunc, n, k int) ([][]byte, error) {
+	if k < 2 || n < k || n > 255 {
+		return nil, fmt.Errorf("invalid share parameters: 2 <= k <= n <= 255 (k=%d, n=%d)", k, n)
+	}
+
+	// read key
+	secret, err := readSecret(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := newKeyFile(secret)
+	if err != nil {
+		return nil, err
+	}
+	fp := fingerprintBytes(keyFile)
+
+	// split
+	ys, err := shamirSplit(secret, n, k)
+	if err != nil {
+		return nil, err
+	}
+
+	// build share files
+	shares := make([][]byte, 0, n)
+	for i, y := range ys {
+		b := bytes.NewBuffer(make([]byte, 0, shareSize))
+		b.WriteString(shareMagic)
+		b.Write([]byte{shareVersion, byte(i + 1), byte(k), byte(n)})
+		b.Write(fp)
+		b.Write(y)
+		h := sha256.Sum256(b.Bytes())
+		b.Write(h[:8])
+		shares = append(shares, b.Bytes())
+	}
+	return shares, nil
+}
+
+// CombineShares recovers the key from at least k shares and writes a new key file (passphrase=nil: legacy key file).
+// The fingerprint of the recovered key must match the fingerprint in the shares.
+// Existing files are NOT overwritten.
+func CombineShares(shares [][]byte, path string, passphrase []byte) (*KeyFile, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+
+	// parse shares
+	xs := make([]byte, 0, len(shares))
+	ys := make([][]byte, 0, len(shares))
+	var threshold byte
+	var fp []byte
+	for i, b := range shares {
+		if len(b) != shareSize || string(b[:8]) != shareMagic {
+			return nil, fmt.Errorf("share %d: not a key share", i+1)
+		}
+		if b[8] != shareVersion {
+			return nil, fmt.Errorf("share %d: unsupported version %d", i+1, b[8])
+		}
+		if h := sha256.Sum256(b[:shareSize-8]); !bytes.Equal(h[:8], b[shareSize-8:]) {
+			return nil, fmt.Errorf("share %d: checksum mismatch", i+1)
+		}
+		index, k := b[9], b[10]
+		if i == 0 {
+			threshold, fp = k, b[12:20]
+		}
+		if k != threshold || !bytes.Equal(b[12:20], fp) {
+			return nil, fmt.Errorf("share %d: belongs to another key or split", i+1)
+		}
+		for _, x := range xs {
+			if x == index {
+				return nil, fmt.Errorf("share %d: duplicate share index %d", i+1, index)
+			}
+		}
+		xs = append(xs, index)
+		ys = append(ys, b[20:20+128])
+	}
+	if len(xs) < int(threshold) {
+		return nil, fmt.Errorf("not enough shares: %d of %d", len(xs), threshold)
+	}
+
+	// combine and compare fingerprints
+	secret := shamirCombine(xs[:threshold], ys[:threshold])
+	keyFile, err := newKeyFile(secret)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fingerprintBytes(keyFile), fp) {
+		return nil, errors.New("fingerprint mismatch: the shares are corrupt")
+	}
+
+	// write key file
+	return writeKeyFile(path, secret, passphrase)
+}
+
+// fingerprintBytes returns the first 8 bytes of the key fingerprint.
+func fingerprintBytes(k *KeyFile) []byte {
+	h := sha256.Sum256(k.IndexKey())
+	return h[:8]
+}
+
+// ----------  SHAMIR  -----------------------------------------------------------------------------------------------//
+
+// shamirSplit splits the secret into n shares (x=1..n) with threshold k.
+// Each byte of the secret is the constant term of a random polynomial of degree k-1 over GF(256).
+func shamirSplit(secret []byte, n, k int) ([][]byte, error) {
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coef := make([]byte, k)
+	for pos, s := range secret {
+		// random polynomial
+		coef[0] = s
+		if _, err := io.ReadFull(rand.Reader, coef[1:]); err != nil {
+			return nil, err
+		}
+		// evaluate (horner)
+		for i := 0; i < n; i++ {
+			x := byte(i + 1)
+			var y byte
+			for j := k - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coef[j]
+			}
+			shares[i][pos] = y
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine recovers the secret with lagrange interpolation at x=0.
+func shamirCombine(xs []byte, ys [][]byte) []byte {
+	secret := make([]byte, len(ys[0]))
+	for i, xi := range xs {
+		// lagrange basis polynomial at 0
+		var num, den byte = 1, 1
+		for j, xj := range xs {
+			if i != j {
+				num = gfMul(num, xj)
+				den = gfMul(den, xi^xj)
+			}
+		}
+		l := gfDiv(num, den)
+		for pos := range secret {
+			secret[pos] ^= gfMul(ys[i][pos], l)
+		}
+	}
+	return secret
+}
+
+// GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1 (generator 3)
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// x = x * 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
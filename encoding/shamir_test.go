@@ -0,0 +1,92 @@
+package enc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestGf(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if gfDiv(gfMul(byte(a), byte(b)), byte(b)) != byte(a) {
+				t.Fatalf("a=%d, b=%d", a, b)
+			}
+		}
+	}
+	if gfMul(0x57, 0x83) != 0xc1 { // FIPS-197 example
+		t.Errorf("wrong mul: %x", gfMul(0x57, 0x83))
+	}
+}
+
+func TestSplitKeyFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "shamirTest")
+	defer os.RemoveAll(dir)
+
+	// TEST: invalid parameters
+	for _, nk := range [][2]int{{3, 1}, {2, 3}, {256, 3}} {
+		if _, err := SplitKeyFile(testCryptKeyFile, nil, nk[0], nk[1]); err == nil {
+			t.Errorf("no error: %v", nk)
+		}
+	}
+
+	// split 3 of 5
+	shares, err := SplitKeyFile(testCryptKeyFile, nil, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 || len(shares[0]) != shareSize {
+		t.Fatalf("wrong shares: %d", len(shares))
+	}
+	orig, _ := ioutil.ReadFile(testCryptKeyFile)
+	for _, s := range shares {
+		if bytes.Contains(s, orig[:16]) {
+			t.Error("share contains the key")
+		}
+	}
+
+	// TEST: any 3 shares
+	for i, set := range [][][]byte{
+		{shares[0], shares[1], shares[2]},
+		{shares[4], shares[2], shares[0]},
+		{shares[1], shares[3], shares[4], shares[0]},
+	} {
+		p := path.Join(dir, "key"+string(rune('0'+i))+".dat")
+		k, err := CombineShares(set, p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(p)
+		if !bytes.Equal(b, orig) || !bytes.Equal(k.indexSecret, testCryptIndexSecret) {
+			t.Errorf("wrong key: set %d", i)
+		}
+	}
+
+	// TEST: not enough shares
+	if _, err := CombineShares([][]byte{shares[0], shares[1]}, path.Join(dir, "x.dat"), nil); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: duplicate share
+	if _, err := CombineShares([][]byte{shares[0], shares[1], shares[1]}, path.Join(dir, "x.dat"), nil); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: corrupt share (checksum)
+	bad := append([]byte{}, shares[2]...)
+	bad[30]++
+	if _, err := CombineShares([][]byte{shares[0], shares[1], bad}, path.Join(dir, "x.dat"), nil); err == nil {
+		t.Error("no error")
+	}
+
+	// TEST: share from another split
+	other, _ := SplitKeyFile(testCryptKeyFile, nil, 5, 2)
+	if _, err := CombineShares([][]byte{shares[0], shares[1], other[2]}, path.Join(dir, "x.dat"), nil); err == nil {
+		t.Error("no error")
+	}
+	if _, err := os.Stat(path.Join(dir, "x.dat")); err == nil {
+		t.Error("file written")
+	}
+}
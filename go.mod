@@ -5,9 +5,13 @@ go 1.16
 require (
 	github.com/SchnorcherSepp/storage v1.3.6
 	github.com/alecthomas/kong v0.2.17
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/klauspost/compress v1.13.1
 	github.com/mackerelio/go-osstat v0.2.0
+	github.com/pkg/sftp v1.13.1
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	golang.org/x/text v0.3.6
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,9 @@
+package limit
+
+import "time"
+
+// packageName is used for debug and error messages
+const packageName = "limit"
+
+// maxSleep is the longest sleep of a waiting reader, so that changed limits are used quickly.
+const maxSleep = 500 * time.Millisecond
@@ -0,0 +1,6 @@
+/*
+Package limit provides bandwidth limits with time windows (e.g. '2M@08:00-18:00,0') for uploads and downloads.
+The limits can be changed at runtime (@see Limits.Set and WatchFile).
+
+*/
+package limit
@@ -0,0 +1,89 @@
+package limit
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileKeys are the keys of a limit file (@see LoadFile).
+var FileKeys = []string{"upload", "upload-conn", "download", "download-conn"}
+
+// LoadFile reads a limit file with 'key = schedule' lines (@see FileKeys and ParseSchedule),
+// e.g. 'upload = 2M@08:00-18:00, unlimited' or 'download-conn = 500K'. Empty lines and lines starting with '#' are ignored. Only the keys in the file are returned.
+func LoadFile(path string) (map[string]Schedule, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	ret := make(map[string]Schedule)
+	s := bufio.NewScanner(fh)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) != 2 || !validKey(key) {
+			return nil, fmt.Errorf("%s:%d: invalid line (use: %s = <schedule>)", path, lineNo, strings.Join(FileKeys, "|"))
+		}
+		sch, err := ParseSchedule(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		ret[key] = sch
+	}
+	return ret, s.Err()
+}
+
+// WatchFile reads the limit file now and after each change (checked every interval) and calls apply.
+// A broken or deleted file is logged and the current limits are kept. The returned function stops the watcher.
+func WatchFile(path string, interval time.Duration, apply func(map[string]Schedule)) (stop func()) {
+	var modTime time.Time
+	check := func() {
+		st, err := os.Stat(path)
+		if err != nil || st.ModTime().Equal(modTime) {
+			return // no file or no change
+		}
+		modTime = st.ModTime()
+		limits, err := LoadFile(path)
+		if err != nil {
+			log.Printf("ERROR: %s/WatchFile: %v (limits not changed)", packageName, err)
+			return
+		}
+		log.Printf("INFO: %s/WatchFile: limits loaded from '%s'", packageName, path)
+		apply(limits)
+	}
+	check()
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// validKey returns true, if the key is in FileKeys.
+func validKey(key string) bool {
+	for _, k := range FileKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
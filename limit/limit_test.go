@@ -0,0 +1,166 @@
+package limit_test
+
+import (
+	"bytes"
+	"github.com/SchnorcherSepp/splitfs/limit"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	day := func(h, m int) time.Time {
+		return time.Date(2020, 1, 1, h, m, 0, 0, time.Local)
+	}
+
+	sch, err := limit.ParseSchedule("2M@08:00-18:00, 100K 22:00-06:00, unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[time.Time]int64{
+		day(7, 59):  0,
+		day(8, 0):   2 * 1024 * 1024,
+		day(17, 59): 2 * 1024 * 1024,
+		day(18, 0):  0,
+		day(23, 0):  100 * 1024,
+		day(5, 59):  100 * 1024,
+		day(6, 0):   0,
+	}
+	for tm, want := range tests {
+		if got := sch.Rate(tm); got != want {
+			t.Errorf("%s: %d != %d", tm.Format("15:04"), got, want)
+		}
+	}
+	if s := sch.String(); s != "2M@08:00-18:00,100K@22:00-06:00,0" {
+		t.Errorf("wrong string: %s", s)
+	}
+
+	// all day and empty
+	if sch, _ := limit.ParseSchedule("1.5K"); sch.Rate(day(12, 0)) != 1536 {
+		t.Errorf("wrong rate: %v", sch)
+	}
+	if sch, err := limit.ParseSchedule(""); err != nil || len(sch) != 0 || sch.Rate(day(12, 0)) != 0 {
+		t.Errorf("wrong schedule: %v, %v", sch, err)
+	}
+
+	// invalid
+	for _, s := range []string{"2X", "-1", "2M@08:00", "2M@25:00-26:00", "2M@08:60-09:00", "M"} {
+		if _, err := limit.ParseSchedule(s); err == nil {
+			t.Errorf("no error: %s", s)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"":          0,
+		"0":         0,
+		"unlimited": 0,
+		"1000":      1000,
+		"500M":      500 * 1024 * 1024,
+		"700g":      700 * 1024 * 1024 * 1024,
+	}
+	for s, want := range tests {
+		if got, err := limit.ParseSize(s); err != nil || got != want {
+			t.Errorf("'%s': %d != %d (%v)", s, got, want, err)
+		}
+	}
+	if _, err := limit.ParseSize("7T"); err == nil {
+		t.Errorf("no error")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	data := make([]byte, 64*1024)
+
+	// TEST: unlimited (nil)
+	var nilLimits *limit.Limits
+	if r := nilLimits.Reader(bytes.NewReader(data)); r == nil {
+		t.Fatal("nil reader")
+	}
+
+	// TEST: connection limit (256 KB/s)
+	conn, _ := limit.ParseSchedule("256K")
+	l := limit.NewLimits(nil, conn)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, l.Reader(bytes.NewReader(data)))
+	if err != nil || n != int64(len(data)) {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond || d > 2*time.Second {
+		t.Errorf("wrong duration: %v", d)
+	}
+
+	// TEST: global limit (shared by two connections)
+	l = limit.NewLimits(conn, nil)
+	start = time.Now()
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, l.Reader(bytes.NewReader(data)))
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+	if d := time.Since(start); d < 450*time.Millisecond {
+		t.Errorf("wrong duration: %v", d)
+	}
+
+	// TEST: change at runtime (1 KB/s -> unlimited)
+	slow, _ := limit.ParseSchedule("1K")
+	l = limit.NewLimits(slow, nil)
+	start = time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		l.Set(nil, nil)
+	}()
+	_, _ = io.Copy(ioutil.Discard, l.Reader(bytes.NewReader(data)))
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("limit not changed: %v", d)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	p := path.Join(os.TempDir(), "limitTestFile.txt")
+	defer os.Remove(p)
+	_ = ioutil.WriteFile(p, []byte("# test\nupload = 2M@08:00-18:00, 0\n\ndownload-conn=500K\n"), 0600)
+
+	// TEST: load
+	m, err := limit.LoadFile(p)
+	if err != nil || len(m) != 2 || m["upload"].String() != "2M@08:00-18:00,0" || m["download-conn"].String() != "500K" {
+		t.Fatalf("wrong limits: %v, %v", m, err)
+	}
+
+	// TEST: reload after change
+	loaded := make(chan map[string]limit.Schedule, 10)
+	stop := limit.WatchFile(p, 10*time.Millisecond, func(m map[string]limit.Schedule) {
+		loaded <- m
+	})
+	defer stop()
+	<-loaded
+	_ = ioutil.WriteFile(p, []byte("upload = 1M\n"), 0600)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(p, future, future)
+	select {
+	case m := <-loaded:
+		if len(m) != 1 || m["upload"].String() != "1M" {
+			t.Errorf("wrong limits: %v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("not reloaded")
+	}
+
+	// TEST: invalid file
+	_ = ioutil.WriteFile(p, []byte("upload = 1X\n"), 0600)
+	if _, err := limit.LoadFile(p); err == nil {
+		t.Error("no error")
+	}
+	_ = ioutil.WriteFile(p, []byte("other = 1M\n"), 0600)
+	if _, err := limit.LoadFile(p); err == nil {
+		t.Error("no error")
+	}
+}
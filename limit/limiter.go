@@ -0,0 +1,182 @@
+package limit
+
+import (
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket with a schedule (thread-safe).
+// All readers of a limiter share the rate (e.g. a global limit for all uploads).
+type Limiter struct {
+	mux      *sync.Mutex
+	schedule Schedule
+	parent   *Limiter // per connection: the schedule of the parent is used
+	tokens   float64  // negative: bytes that must be paid off
+	last     time.Time
+}
+
+// NewLimiter returns a new limiter with the schedule.
+func NewLimiter(schedule Schedule) *Limiter {
+	return &Limiter{
+		mux:      new(sync.Mutex),
+		schedule: schedule,
+		last:     time.Now(),
+	}
+}
+
+// SetSchedule changes the schedule (also for waiting readers).
+func (l *Limiter) SetSchedule(schedule Schedule) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.schedule = schedule
+}
+
+// Schedule returns the current schedule.
+func (l *Limiter) Schedule() Schedule {
+	if l.parent != nil {
+		return l.parent.Schedule()
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.schedule
+}
+
+// Wait blocks until n bytes may be transferred.
+func (l *Limiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	// take the bytes (the limiter may go into debt)
+	l.mux.Lock()
+	if l.refill() <= 0 {
+		l.mux.Unlock()
+		return // unlimited
+	}
+	l.tokens -= float64(n)
+	l.mux.Unlock()
+
+	// wait until the debt is paid off
+	for {
+		l.mux.Lock()
+		rate := l.refill()
+		if rate <= 0 || l.tokens >= 0 {
+			l.mux.Unlock()
+			return
+		}
+		d := time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+		l.mux.Unlock()
+
+		if d > maxSleep {
+			d = maxSleep // check changed limits
+		}
+		time.Sleep(d)
+	}
+}
+
+// refill adds the tokens since the last call and returns the current rate (0=unlimited).
+// The bucket holds at most the tokens of one second. The caller must hold the lock.
+func (l *Limiter) refill() int64 {
+	now := time.Now()
+	schedule := l.schedule
+	if l.parent != nil {
+		schedule = l.parent.Schedule()
+	}
+	rate := schedule.Rate(now)
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	if rate <= 0 {
+		l.tokens = 0 // unlimited: no debts
+		return 0
+	}
+	l.tokens += elapsed * float64(rate)
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	return rate
+}
+
+// ----------  LIMITS  -----------------------------------------------------------------------------------------------//
+
+// Limits are the limits of one direction (upload or download):
+// a global limit for all connections and a limit for each connection (e.g. an uploaded part or a webdav file).
+// A nil *Limits is unlimited.
+type Limits struct {
+	global *Limiter
+	conn   *Limiter // schedule for the connections (parent)
+}
+
+// NewLimits returns the limits with the global and the per connection schedule.
+func NewLimits(global, conn Schedule) *Limits {
+	return &Limits{
+		global: NewLimiter(global),
+		conn:   NewLimiter(conn),
+	}
+}
+
+// Set changes the global and the per connection schedule (also for open connections).
+func (l *Limits) Set(global, conn Schedule) {
+	l.global.SetSchedule(global)
+	l.conn.SetSchedule(conn)
+}
+
+// Get returns the global and the per connection schedule.
+func (l *Limits) Get() (global, conn Schedule) {
+	return l.global.Schedule(), l.conn.Schedule()
+}
+
+// Reader returns a limited reader (new connection).
+func (l *Limits) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &_Reader{r: r, conn: l.newConn(), global: l.global}
+}
+
+// ReaderAt returns a limited ReaderAt (new connection).
+func (l *Limits) ReaderAt(r interf.ReaderAt) interf.ReaderAt {
+	if l == nil {
+		return r
+	}
+	return &_ReaderAt{ReaderAt: r, conn: l.newConn(), global: l.global}
+}
+
+// newConn returns the limiter of a new connection.
+func (l *Limits) newConn() *Limiter {
+	c := NewLimiter(nil)
+	c.parent = l.conn
+	return c
+}
+
+// _Reader limits the read speed of a reader.
+type _Reader struct {
+	r      io.Reader
+	conn   *Limiter
+	global *Limiter
+}
+
+// Read @see io.Reader
+func (r *_Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.conn.Wait(n)
+	r.global.Wait(n)
+	return n, err
+}
+
+// _ReaderAt limits the read speed of a ReaderAt.
+type _ReaderAt struct {
+	interf.ReaderAt
+	conn   *Limiter
+	global *Limiter
+}
+
+// ReadAt @see io.ReaderAt
+func (r *_ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.conn.Wait(n)
+	r.global.Wait(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package limit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a list of rules. The first rule with a matching time window defines the rate.
+// A schedule without a matching rule is unlimited.
+type Schedule []Rule
+
+// Rule is a rate (bytes per second) for a daily time window (local time).
+type Rule struct {
+	// Rate is the limit in bytes per second (0=unlimited).
+	Rate int64
+	// From and To are the minutes after midnight [From, To). The window can wrap midnight (e.g. 22:00-06:00).
+	// If From and To are equal, the rule matches all day.
+	From, To int
+}
+
+// ParseSchedule parses a comma separated list of rules 'RATE[@HH:MM-HH:MM]', e.g. '2M@08:00-18:00,0'.
+// RATE is in bytes per second with an optional suffix K, M or G (1024 based), 0 or 'unlimited' is no limit.
+// A rule without a time window matches all day (use it as last rule). An empty string is unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	sch := make(Schedule, 0)
+	for _, str := range strings.Split(s, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+
+		// time window
+		r := Rule{}
+		rateStr := str
+		if i := strings.IndexAny(str, "@ "); i >= 0 {
+			rateStr = str[:i]
+			window := strings.SplitN(strings.Trim(str[i+1:], "@ "), "-", 2)
+			if len(window) != 2 {
+				return nil, fmt.Errorf("invalid time window: '%s'", str)
+			}
+			var err error
+			if r.From, err = parseClock(window[0]); err != nil {
+				return nil, err
+			}
+			if r.To, err = parseClock(window[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		// rate
+		rate, err := parseRate(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		r.Rate = rate
+		sch = append(sch, r)
+	}
+	return sch, nil
+}
+
+// Rate returns the rate (bytes per second) at the time t (0=unlimited).
+func (sch Schedule) Rate(t time.Time) int64 {
+	min := t.Hour()*60 + t.Minute()
+	for _, r := range sch {
+		if r.matches(min) {
+			return r.Rate
+		}
+	}
+	return 0
+}
+
+// String returns the schedule in the format of ParseSchedule.
+func (sch Schedule) String() string {
+	list := make([]string, 0, len(sch))
+	for _, r := range sch {
+		s := formatRate(r.Rate)
+		if r.From != r.To {
+			s += fmt.Sprintf("@%02d:%02d-%02d:%02d", r.From/60, r.From%60, r.To/60, r.To%60)
+		}
+		list = append(list, s)
+	}
+	return strings.Join(list, ",")
+}
+
+// matches returns true, if the minute after midnight is in the time window.
+func (r Rule) matches(min int) bool {
+	switch {
+	case r.From == r.To:
+		return true // all day
+	case r.From < r.To:
+		return min >= r.From && min < r.To
+	default:
+		return min >= r.From || min < r.To // wraps midnight
+	}
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// ParseSize parses a byte count with an optional suffix K, M or G (1024 based), e.g. '700G'.
+// 0, 'unlimited' or an empty string is no limit.
+func ParseSize(s string) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	n, err := parseRate(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: '%s' (e.g. 500M, 700G or 0)", s)
+	}
+	return n, nil
+}
+
+// parseClock parses 'HH:MM' (00:00 to 24:00) and returns the minutes after midnight.
+func parseClock(s string) (int, error) {
+	t := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(t) == 2 {
+		h, errH := strconv.Atoi(t[0])
+		m, errM := strconv.Atoi(t[1])
+		if errH == nil && errM == nil && h >= 0 && m >= 0 && m < 60 && h*60+m <= 24*60 {
+			return (h*60 + m) % (24 * 60), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time: '%s' (use HH:MM)", s)
+}
+
+// parseRate parses a rate with an optional suffix K, M or G.
+func parseRate(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if s == "UNLIMITED" || s == "OFF" {
+		return 0, nil
+	}
+	mul := float64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mul = 1024
+	case strings.HasSuffix(s, "M"):
+		mul = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		mul = 1024 * 1024 * 1024
+	}
+	if mul > 1 {
+		s = s[:len(s)-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate: '%s' (e.g. 500K, 2M or 0)", s)
+	}
+	return int64(f * mul), nil
+}
+
+// formatRate returns the rate with the largest possible suffix.
+func formatRate(rate int64) string {
+	switch {
+	case rate <= 0:
+		return "0"
+	case rate%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dG", rate/(1024*1024*1024))
+	case rate%(1024*1024) == 0:
+		return fmt.Sprintf("%dM", rate/(1024*1024))
+	case rate%1024 == 0:
+		return fmt.Sprintf("%dK", rate/1024)
+	default:
+		return fmt.Sprintf("%d", rate)
+	}
+}
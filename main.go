@@ -1,32 +1,150 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/SchnorcherSepp/splitfs/backend/local"
+	"github.com/SchnorcherSepp/splitfs/backend/replica"
+	"github.com/SchnorcherSepp/splitfs/backend/s3"
+	"github.com/SchnorcherSepp/splitfs/backend/sftp"
+	"github.com/SchnorcherSepp/splitfs/config"
 	"github.com/SchnorcherSepp/splitfs/core"
 	"github.com/SchnorcherSepp/splitfs/db"
 	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/limit"
+	"github.com/SchnorcherSepp/splitfs/progress"
+	"github.com/SchnorcherSepp/splitfs/watch"
 	"github.com/SchnorcherSepp/splitfs/webdav"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	"github.com/SchnorcherSepp/storage/gdrive"
 	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"github.com/alecthomas/kong"
 	"github.com/mackerelio/go-osstat/memory"
+	pkgsftp "github.com/pkg/sftp"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 )
 
 // version is set by `go build`
 var version = "<version>"
 
+// StorageFlags select and configure the storage backend (embedded in all commands with online access).
+type StorageFlags struct {
+	Backend    []string `short:"b" enum:"gdrive,local,s3,sftp" default:"gdrive" help:"The storage backend (gdrive, local, s3, sftp). Multiple backends are replicas (e.g. gdrive,local)."`
+	ClientFile string   `short:"c" type:"path" default:"client.json" help:"[gdrive] The identifier for a app, to use the google api."`
+	TokenFile  string   `short:"t" type:"path" default:"token.json"  help:"[gdrive] Token for access to your gdrive."`
+	CacheFile  string   `short:"a" type:"path" default:"cache.dat"   help:"[gdrive] The online index file to speed up the program start."`
+	FolderID   string   `short:"i" default:"root"                    help:"[gdrive] The google drive FolderID with the storage files."`
+	StorageDir string   `short:"r" type:"path" default:"storage"     help:"[local] Path to the folder with the storage files (e.g. NAS mount or USB disk)."`
+	S3Endpoint string   `name:"s3-endpoint" default:"http://127.0.0.1:9000" help:"[s3] The server url with scheme (path-style requests are used)."`
+	S3Region   string   `name:"s3-region"   default:"us-east-1"             help:"[s3] The region for the request signature."`
+	S3Bucket   string   `name:"s3-bucket"   default:"splitfs"               help:"[s3] The bucket with the storage files (must exist)."`
+	S3Prefix   string   `name:"s3-prefix"                                   help:"[s3] Optional key prefix for all storage files."`
+	S3Access   string   `name:"s3-access-key" env:"AWS_ACCESS_KEY_ID"       help:"[s3] The access key."`
+	S3Secret   string   `name:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY"   help:"[s3] The secret key."`
+	SftpAddr   string   `name:"sftp-addr" default:"localhost:22" help:"[sftp] The SSH server address (host:port)."`
+	SftpUser   string   `name:"sftp-user" env:"USER" help:"[sftp] The SSH username."`
+	SftpKey    string   `name:"sftp-key" type:"path" help:"[sftp] Path to the private key file (optional)."`
+	SftpPass   string   `name:"sftp-password" env:"SFTP_PASSWORD" help:"[sftp] The password (optional)."`
+	SftpHosts  string   `name:"sftp-known-hosts" type:"path" default:"~/.ssh/known_hosts" help:"[sftp] Path to the known_hosts file (host key check)."`
+	SftpDir    string   `name:"sftp-dir" default:"splitfs" help:"[sftp] The remote folder with the storage files."`
+}
+
+// ScanFlags skip files and folders during the scan and set the scan workers (embedded in scan, upload and watch).
+// The '.splitfsignore' files (gitignore-style) and the folder markers 'CACHEDIR.TAG' and '.nobackup' are always used.
+type ScanFlags struct {
+	Exclude       []string `short:"e" help:"Gitignore-style exclude pattern relative to the root folder (repeatable, e.g. -e '*.tmp' -e '/build/')."`
+	MaxSize       int64    `name:"max-size" help:"Skips files larger than n bytes (0=off)."`
+	OneFileSystem bool     `name:"one-file-system" help:"Skips folders on other file systems (mount points)."`
+	Workers       int      `short:"j" name:"scan-workers" default:"1" help:"Number of files or 1 GB file parts that are scanned at the same time."`
+	Chunking      string   `name:"chunking" help:"Content-defined chunking for large files with 'min,avg,max' part sizes, e.g. '16M,64M,256M' (default: fixed 1 GB parts)."`
+}
+
+// options returns the scan options.
+func (e ScanFlags) options() (db.ScanOptions, error) {
+	opts := db.ScanOptions{Exclude: e.Exclude, MaxSize: e.MaxSize, OneFileSystem: e.OneFileSystem, Workers: e.Workers}
+	if strings.TrimSpace(e.Chunking) == "" {
+		return opts, nil
+	}
+	sizes := strings.Split(e.Chunking, ",")
+	if len(sizes) != 3 {
+		return opts, fmt.Errorf("--chunking: need 'min,avg,max' sizes: '%s'", e.Chunking)
+	}
+	var err error
+	c := &opts.Chunking
+	for i, n := range []*int64{&c.Min, &c.Avg, &c.Max} {
+		if *n, err = limit.ParseSize(sizes[i]); err != nil {
+			return opts, fmt.Errorf("--chunking: %v", err)
+		}
+	}
+	if err := c.Check(); err != nil {
+		return opts, fmt.Errorf("--chunking: %v", err)
+	}
+	return opts, nil
+}
+
+// UploadFlags set the upload workers, the error policy, the bandwidth limits and the upload caps (embedded in upload and watch).
+type UploadFlags struct {
+	Workers         int    `short:"u" name:"upload-workers" default:"1" help:"Number of parts and bundles that are uploaded at the same time."`
+	ContinueOnError bool   `name:"continue-on-error" help:"Uploads all other parts and bundles after a failed upload (the index is not uploaded)."`
+	Limit           string `name:"upload-limit" help:"Global upload limit in bytes/s with optional time windows, e.g. '2M@08:00-18:00,0' (0=unlimited)."`
+	ConnLimit       string `name:"upload-conn-limit" help:"Upload limit for each part or bundle (same format as --upload-limit)."`
+	LimitFile       string `name:"limit-file" type:"path" help:"File with 'upload = <limit>' and 'upload-conn = <limit>' lines (overrides the flags, reloaded after changes)."`
+	MaxUpload       string `name:"max-upload" help:"Uploads at most n bytes per run, e.g. '500G' (the next run continues the upload)."`
+	MaxUploadDay    string `name:"max-upload-day" help:"Uploads at most n bytes per rolling 24 hours, e.g. '700G' for the Google Drive limit of 750 GB."`
+}
+
+// options returns the upload options.
+// The uploads of the last 24 hours are saved next to the db file (dbStr + '.quota').
+func (u UploadFlags) options(dbStr string) (core.UploadOptions, error) {
+	limits, err := newLimits("upload", u.Limit, u.ConnLimit, u.LimitFile)
+	if err != nil {
+		return core.UploadOptions{}, err
+	}
+	perRun, err := limit.ParseSize(u.MaxUpload)
+	if err != nil {
+		return core.UploadOptions{}, fmt.Errorf("--max-upload: %v", err)
+	}
+	perDay, err := limit.ParseSize(u.MaxUploadDay)
+	if err != nil {
+		return core.UploadOptions{}, fmt.Errorf("--max-upload-day: %v", err)
+	}
+	var quota *core.Quota
+	if perRun > 0 || perDay > 0 {
+		if quota, err = core.OpenQuota(dbStr+".quota", perRun, perDay); err != nil {
+			return core.UploadOptions{}, err
+		}
+	}
+	return core.UploadOptions{Workers: u.Workers, ContinueOnError: u.ContinueOnError, Limits: limits, Quota: quota}, nil
+}
+
 // CLI commands (see https://github.com/alecthomas/kong)
 var CLI struct {
-	Debug int `short:"v" type:"counter" help:"Enable debug mode (-v for DebugLow, -vv for DebugHigh)."`
+	Debug    int    `short:"v" type:"counter" help:"Enable debug mode (-v for DebugLow, -vv for DebugHigh)."`
+	Progress string `name:"progress" enum:"off,bar,json" default:"off" help:"Shows the progress of scan, bundle, upload and clean: 'bar' (terminal, stderr) or 'json' (JSON lines, stdout)."`
+
+	// config file with named repositories (CLI flags override config values)
+	Config string `name:"config" type:"path" env:"SPLITFS_CONFIG" help:"Path to the config file (YAML) with named repositories."`
+	Repo   string `name:"repo"               env:"SPLITFS_REPO"   help:"Name of the repository in the config file (default: 'default' or the only repo)."`
+
+	// passphrase for protected key files (default: terminal prompt)
+	PassEnv    string `name:"pass-env"     default:"SPLITFS_PASSPHRASE"     help:"Environment variable with the key file passphrase."`
+	PassFd     int    `name:"pass-fd"      default:"-1"                     help:"Reads the key file passphrase from this file descriptor (first line)."`
+	NewPassEnv string `name:"new-pass-env" default:"SPLITFS_NEW_PASSPHRASE" help:"Environment variable with the new key file passphrase (keyfile passwd, rekey)."`
+	NewPassFd  int    `name:"new-pass-fd"  default:"-1"                     help:"Reads the new key file passphrase from this file descriptor (keyfile passwd, rekey)."`
 
 	Version struct {
 	} `cmd help:"Show the program version."`
@@ -40,8 +158,49 @@ var CLI struct {
 
 	Keygen struct {
 		KeyFile string `short:"k" type:"path" default:"key.dat" help:"Path to the key file (must not exist)."`
+		// optional
+		Protect bool `short:"p" help:"Protects the key file with a passphrase (prompt, --pass-env or --pass-fd)."`
 	} `cmd help:"Creates a new key file (used for file encryption)."`
 
+	Keyfile struct {
+		Passwd struct {
+			KeyFile string `short:"k" type:"path" default:"key.dat" help:"Path to the key file."`
+		} `cmd help:"Sets or changes the passphrase of a key file (old: --pass-*, new: --new-pass-*)."`
+
+		ExportPaper struct {
+			KeyFile string `short:"k" type:"path" default:"key.dat" help:"Path to the key file."`
+			// optional
+			Output string `short:"o" type:"path" help:"Writes the paper key to this file instead of stdout."`
+		} `cmd help:"Prints the key file as text with checksums for a paper backup."`
+
+		ImportPaper struct {
+			KeyFile string `short:"k" type:"path" default:"key.dat" help:"Path to the new key file (must not exist)."`
+			// optional
+			Input   string `short:"i" type:"path" help:"Reads the paper key from this file instead of stdin."`
+			Protect bool   `short:"p" help:"Protects the key file with a passphrase (prompt, --pass-env or --pass-fd)."`
+		} `cmd help:"Creates a key file from a paper backup (typos are detected and located)."`
+
+		Split struct {
+			KeyFile   string `short:"k" type:"path" default:"key.dat" help:"Path to the key file."`
+			Shares    int    `short:"n" default:"5"                   help:"Number of shares."`
+			Threshold int    `short:"t" default:"3"                   help:"Number of shares to recover the key file."`
+			// optional
+			Output string `short:"o" type:"path" default:"key.share" help:"Prefix of the share files (<prefix>.<index>.dat)."`
+		} `cmd help:"Splits the key file into n shares, any k of them recover the key file (Shamir's secret sharing)."`
+
+		Combine struct {
+			Shares []string `arg type:"path" help:"Share files (at least the threshold)."`
+			// optional
+			KeyFile string `short:"k" type:"path" default:"key.dat" help:"Path to the new key file (must not exist)."`
+			Protect bool   `short:"p" help:"Protects the key file with a passphrase (prompt, --pass-env or --pass-fd)."`
+		} `cmd help:"Recovers the key file from shares."`
+
+		ExportReader struct {
+			KeyFile string `short:"k" type:"path" default:"key.dat"    help:"Path to the key file."`
+			Output  string `short:"o" type:"path" default:"reader.dat" help:"Path to the new reader key file (must not exist)."`
+		} `cmd help:"Writes a reader key file with only the index key (for webdav, restore, verify, ls, ...; not for scan and upload)."`
+	} `cmd help:"Manages key files."`
+
 	Scan struct {
 		RootDir string `short:"o" type:"path" default:"/data"     help:"Path to the folder with the plain text files (becomes the root directory)"`
 		DbFile  string `short:"d" type:"path" default:"index.db2" help:"Path to the db file."`
@@ -49,40 +208,158 @@ var CLI struct {
 		// optional
 		Force    bool `short:"f" help:"Forces a scan even if the content has not changed."`
 		NoBundle bool `short:"n" help:"Bundles small files into large files for faster read access."`
+
+		Scan ScanFlags `embed`
 	} `cmd help:"Scan a folder and create/update an encrypted database file."`
 
 	Upload struct {
-		RootDir    string `short:"o" type:"path" default:"/data"       help:"Path to the folder with the plain text files (becomes the root directory)"`
-		DbFile     string `short:"d" type:"path" default:"index.db2"   help:"Path to the db file."`
-		KeyFile    string `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
-		ClientFile string `short:"c" type:"path" default:"client.json" help:"The identifier for a app, to use the google api."`
-		TokenFile  string `short:"t" type:"path" default:"token.json"  help:"Token for access to your gdrive."`
-		CacheFile  string `short:"a" type:"path" default:"cache.dat"   help:"The online index file to speed up the program start."`
+		RootDir string       `short:"o" type:"path" default:"/data"       help:"Path to the folder with the plain text files (becomes the root directory)"`
+		DbFile  string       `short:"d" type:"path" default:"index.db2"   help:"Path to the db file."`
+		KeyFile string       `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
+		Storage StorageFlags `embed`
 		// optional
-		Force        bool   `short:"f" help:"Forces a scan/upload even if the content has not changed."`
-		NoBundle     bool   `short:"n" help:"Bundles small files into large files for faster read access."`
-		SkipFullInit bool   `short:"s" help:"Accelerates the program start with many files. (Experimental!)"`
-		Cleanup      bool   `short:"l" help:"Deletes files that are no longer needed online after the upload. (WARNING: Do not use this mode regularly!)"`
-		TryCleanup   bool   `short:"y" help:"Switches the -c cleanup mode to 'log only' and does not delete any files."`
-		FolderID     string `short:"i" default:"root" help:"The google drive FolderID with the storage files."`
+		Force        bool `short:"f" help:"Forces a scan/upload even if the content has not changed."`
+		NoBundle     bool `short:"n" help:"Bundles small files into large files for faster read access."`
+		SkipFullInit bool `short:"s" help:"Accelerates the program start with many files. (Experimental!)"`
+		Cleanup      bool `short:"l" help:"Deletes files that are no longer needed online after the upload. (WARNING: Do not use this mode regularly!)"`
+		TryCleanup   bool `short:"y" help:"Switches the -c cleanup mode to 'log only' and does not delete any files."`
+		Snapshot     bool `help:"Keeps a timestamped copy of the uploaded index (@see snapshots)."`
+		Resume       bool `help:"Resumes an interrupted upload (uploaded parts are not checked or uploaded again)."`
+		Restart      bool `help:"Discards the progress of an interrupted upload and starts again."`
+
+		Scan    ScanFlags   `embed`
+		Uploads UploadFlags `embed`
 	} `cmd help:"Saves the local files encrypted in the online folder."`
 
+	Watch struct {
+		RootDir string       `short:"o" type:"path" default:"/data"       help:"Path to the folder with the plain text files (becomes the root directory)"`
+		DbFile  string       `short:"d" type:"path" default:"index.db2"   help:"Path to the db file."`
+		KeyFile string       `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
+		Storage StorageFlags `embed`
+		// optional
+		NoBundle       bool `short:"n" help:"Bundles small files into large files for faster read access."`
+		SkipFullInit   bool `short:"s" help:"Accelerates the program start with many files. (Experimental!)"`
+		Debounce       int  `short:"w" default:"5"    help:"Changed files are scanned after n seconds without new events."`
+		UploadInterval int  `short:"x" default:"300"  help:"Changes are uploaded at most every n seconds."`
+		RescanInterval int  `short:"z" default:"3600" help:"Full rescan every n seconds to find missed events (0=off)."`
+		Snapshot       bool `help:"Keeps a timestamped copy of each uploaded index (@see snapshots)."`
+
+		Scan    ScanFlags   `embed`
+		Uploads UploadFlags `embed`
+	} `cmd help:"Watches the local files and uploads changes continuously (daemon, stops on SIGTERM or SIGINT)."`
+
 	Webdav struct {
-		UserFile   string `short:"u" type:"path" default:"webdav.users" help:"Path to the file with usernames and password hashes."`
-		KeyFile    string `short:"k" type:"path" default:"key.dat"      help:"Path to the key file."`
-		ClientFile string `short:"c" type:"path" default:"client.json"  help:"The identifier for a app, to use the google api."`
-		TokenFile  string `short:"t" type:"path" default:"token.json"   help:"Token for access to your gdrive."`
-		CacheFile  string `short:"a" type:"path" default:"cache.dat"    help:"The online index file to speed up the program start."`
+		UserFile string       `short:"u" type:"path" default:"webdav.users" help:"Path to the file with usernames and password hashes."`
+		KeyFile  string       `short:"k" type:"path" default:"key.dat"      help:"Path to the key file."`
+		Storage  StorageFlags `embed`
 		// optional
 		CacheSizeMB    int    `short:"m" default:"500"           help:"The buffer in RAM enables high-performance random read access. (Don't use all of your memory!)"`
 		LocalAddr      string `short:"l" default:":8080"         help:"The local server address like '1.2.3.4:8080' or '[::1]:443'."`
-		FolderID       string `short:"i" default:"root"          help:"The google drive FolderID with the storage files."`
 		UseTLS         bool   `short:"e"                         help:"Encrypt connection with TLS."`
 		Cert           string `short:"q" default:"fullchain.pem" help:"Path to the server certificate."`
 		CertKey        string `short:"p" default:"privkey.pem"   help:"Path to the server certificate key."`
 		UpdateInterval int    `short:"x" default:"300"           help:"The database is checked for changes every n seconds."`
+		Share          string `short:"s"                         help:"Serves only the share with this name (use the share key file as key file)."`
+		Limit          string `name:"download-limit"             help:"Global download limit in bytes/s with optional time windows, e.g. '2M@08:00-18:00,0' (0=unlimited)."`
+		ConnLimit      string `name:"download-conn-limit"        help:"Download limit for each opened file (same format as --download-limit)."`
+		LimitFile      string `name:"limit-file" type:"path"     help:"File with 'download = <limit>' and 'download-conn = <limit>' lines (overrides the flags, reloaded after changes)."`
 	} `cmd help:"Starts a WebDav server to access the files online."`
 
+	Restore struct {
+		TargetDir string       `short:"o" type:"path" default:"/restore"    help:"Path to the folder in which the files are restored."`
+		KeyFile   string       `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
+		Storage   StorageFlags `embed`
+		// optional
+		Path string `short:"p" default:"/" help:"Only files and folders below this path are restored."`
+	} `cmd help:"Downloads and decrypts the online files to a local folder."`
+
+	Verify struct {
+		KeyFile string       `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
+		Storage StorageFlags `embed`
+		// optional
+		Deep bool `short:"e" help:"Downloads and decrypts all parts to check the content (slow)."`
+	} `cmd help:"Checks the online files against the database and prints a JSON report."`
+
+	Share struct {
+		Path string `arg help:"Folder in the db (becomes the root folder of the share)."`
+		Name string `short:"n" required help:"Name of the share (a-z, A-Z, 0-9, '-', '_'). The partial index is saved as 'index-<name>.db2'."`
+		// optional
+		KeyFile  string       `short:"k" type:"path" default:"key.dat"   help:"Path to the key file."`
+		ShareKey string       `short:"s" type:"path" default:"share.dat" help:"Path to the share key file (created if it does not exist, otherwise reused)."`
+		Storage  StorageFlags `embed`
+		Remove   bool         `short:"x" help:"Removes the share from the storage."`
+	} `cmd help:"Shares a folder: uploads a partial index, readable only with a separate share key file (webdav --share)."`
+
+	Rekey struct {
+		KeyFile    string       `short:"k" type:"path" default:"key.dat"     help:"Path to the old key file."`
+		NewKeyFile string       `short:"n" type:"path" default:"key.new.dat" help:"Path to the new key file (create it with keygen)."`
+		DbFile     string       `short:"d" type:"path" default:"index.db2"   help:"Path to the local db file (rewritten with the new key)."`
+		Storage    StorageFlags `embed`
+		// optional
+		Full       bool `short:"f" help:"Re-encrypts all parts and bundles with new names and data keys (slow, resumable). Without this flag, only the index is re-encrypted."`
+		Cleanup    bool `short:"l" help:"Deletes the old parts and bundles after a full rekey."`
+		TryCleanup bool `short:"y" help:"Switches the -l cleanup mode to 'log only' and does not delete any files."`
+	} `cmd help:"Replaces the key: re-encrypts the online index (and optionally all data) with a new key file."`
+
+	Snapshots struct {
+		List struct {
+			Storage StorageFlags `embed`
+		} `cmd help:"Lists all index snapshots (timestamp and size)."`
+
+		Prune struct {
+			KeyFile string       `short:"k" type:"path" default:"key.dat" help:"Path to the key file."`
+			Storage StorageFlags `embed`
+			// optional
+			KeepLast    int  `short:"j" help:"Keeps the last n snapshots."`
+			KeepDaily   int  `short:"e" help:"Keeps the newest snapshot of the last n days."`
+			KeepWeekly  int  `short:"w" help:"Keeps the newest snapshot of the last n weeks."`
+			KeepMonthly int  `short:"m" help:"Keeps the newest snapshot of the last n months."`
+			Cleanup     bool `short:"l" help:"Deletes the parts and bundles that are no longer referenced after the prune."`
+			Try         bool `short:"y" help:"Switches to 'log only' and does not delete any snapshots or files."`
+		} `cmd help:"Removes all snapshots that are not kept by one of the --keep-* rules."`
+	} `cmd help:"Manages the index history (snapshots are browsable read-only in webdav under '/.snapshots/<timestamp>/')."`
+
+	Ls struct {
+		Path string `arg optional default:"/" help:"Folder in the db."`
+		// optional
+		DbFile  string `short:"d" type:"path" default:"index.db2" help:"Path to the db file."`
+		KeyFile string `short:"k" type:"path" default:"key.dat"   help:"Path to the key file."`
+	} `cmd help:"Lists the content of a folder from a local db file."`
+
+	Tree struct {
+		Path string `arg optional default:"/" help:"Folder in the db."`
+		// optional
+		DbFile  string `short:"d" type:"path" default:"index.db2" help:"Path to the db file."`
+		KeyFile string `short:"k" type:"path" default:"key.dat"   help:"Path to the key file."`
+	} `cmd help:"Shows the folder tree from a local db file."`
+
+	Find struct {
+		Pattern string `arg optional help:"Glob pattern for the name (or the path, if the pattern contains '/')."`
+		// optional
+		DbFile  string    `short:"d" type:"path" default:"index.db2" help:"Path to the db file."`
+		KeyFile string    `short:"k" type:"path" default:"key.dat"   help:"Path to the key file."`
+		Path    string    `short:"p" default:"/"                     help:"Only files and folders below this path are found."`
+		MinSize int64     `short:"s"                                 help:"Minimal file size in bytes."`
+		MaxSize int64     `short:"m"                                 help:"Maximal file size in bytes."`
+		After   time.Time `short:"a" format:"2006-01-02"             help:"Changed at or after this day (YYYY-MM-DD)."`
+		Before  time.Time `short:"b" format:"2006-01-02"             help:"Changed before this day (YYYY-MM-DD)."`
+	} `cmd help:"Finds files in a local db file."`
+
+	Stat struct {
+		Path string `arg help:"File or folder in the db."`
+		// optional
+		DbFile  string `short:"d" type:"path" default:"index.db2" help:"Path to the db file."`
+		KeyFile string `short:"k" type:"path" default:"key.dat"   help:"Path to the key file."`
+	} `cmd help:"Shows the details of a file (parts, storage names, compression and bundle)."`
+
+	Cat struct {
+		Path string `arg help:"File in the db."`
+		// optional
+		DbFile  string       `short:"d" type:"path" default:"index.db2"   help:"Path to the db file."`
+		KeyFile string       `short:"k" type:"path" default:"key.dat"     help:"Path to the key file."`
+		Storage StorageFlags `embed`
+	} `cmd help:"Writes the content of a file to stdout."`
+
 	Adduser struct {
 		Username   string `arg help:"WebDav username (user must not yet exist)."`
 		Password   string `arg help:"Password (saved as a bcrypt hash)"`
@@ -94,7 +371,7 @@ var CLI struct {
 
 func main() {
 	description := "The program synchronizes local files with Google Drive and makes them available."
-	ctx := kong.Parse(&CLI, kong.UsageOnError(), kong.Description(description))
+	ctx := kong.Parse(&CLI, kong.UsageOnError(), kong.Description(description), kong.Resolvers(config.NewResolver()))
 	switch ctx.Selected().Name {
 
 	case "version":
@@ -111,29 +388,193 @@ func main() {
 		break
 
 	case "keygen":
-		err := enc.CreateKeyFile(CLI.Keygen.KeyFile)
+		a := CLI.Keygen
+		var err error
+		if a.Protect {
+			var pass []byte
+			pass, err = passphrase("New passphrase: ", true)()
+			if err == nil {
+				err = enc.CreateProtectedKeyFile(a.KeyFile, pass)
+			}
+		} else {
+			err = enc.CreateKeyFile(a.KeyFile)
+		}
 		if err != nil {
 			fmt.Printf("[FATAL ERROR] %v\n", err)
 			os.Exit(31)
 		}
 		break
 
+	case "passwd":
+		a := CLI.Keyfile.Passwd
+		pass, err := newPassphrase("New passphrase: ", true)()
+		if err == nil {
+			err = enc.ChangePassphrase(a.KeyFile, passphrase(fmt.Sprintf("Passphrase for '%s': ", a.KeyFile), false), pass)
+		}
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(41)
+		}
+		break
+
+	case "export-paper":
+		a := CLI.Keyfile.ExportPaper
+		exportPaper(a.KeyFile, a.Output)
+		break
+
+	case "import-paper":
+		a := CLI.Keyfile.ImportPaper
+		importPaper(a.KeyFile, a.Input, a.Protect)
+		break
+
+	case "split":
+		a := CLI.Keyfile.Split
+		splitKey(a.KeyFile, a.Output, a.Shares, a.Threshold)
+		break
+
+	case "combine":
+		a := CLI.Keyfile.Combine
+		combineKey(a.KeyFile, a.Shares, a.Protect)
+		break
+
+	case "export-reader":
+		a := CLI.Keyfile.ExportReader
+		exportReader(a.KeyFile, a.Output)
+		break
+
 	case "scan":
 		debug := uint8(CLI.Debug)
 		a := CLI.Scan
-		upload(true, debug, false, "", "", a.KeyFile, "", "", a.DbFile, a.RootDir, a.Force, !a.NoBundle, false, true)
+		scanOpts, err := a.Scan.options()
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(517)
+		}
+		upload(true, debug, false, StorageFlags{}, a.KeyFile, a.DbFile, a.RootDir, scanOpts, core.UploadOptions{}, a.Force, !a.NoBundle, false, true, false, false, false)
 		break
 
 	case "upload":
 		debug := uint8(CLI.Debug)
 		a := CLI.Upload
-		upload(false, debug, a.SkipFullInit, a.ClientFile, a.TokenFile, a.KeyFile, a.FolderID, a.CacheFile, a.DbFile, a.RootDir, a.Force, !a.NoBundle, a.Cleanup, a.TryCleanup)
+		uploadOpts, err := a.Uploads.options(a.DbFile)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(514)
+		}
+		scanOpts, err := a.Scan.options()
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(516)
+		}
+		upload(false, debug, a.SkipFullInit, a.Storage, a.KeyFile, a.DbFile, a.RootDir, scanOpts, uploadOpts, a.Force, !a.NoBundle, a.Cleanup, a.TryCleanup, a.Snapshot, a.Resume, a.Restart)
+		break
+
+	case "watch":
+		debug := uint8(CLI.Debug)
+		a := CLI.Watch
+		uploadOpts, err := a.Uploads.options(a.DbFile)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1306)
+		}
+		uploadOpts.Progress = newProgress()
+		scanOpts, err := a.Scan.options()
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1307)
+		}
+		scanOpts.Progress = uploadOpts.Progress
+		opts := watch.Options{
+			Debounce:       time.Duration(a.Debounce) * time.Second,
+			UploadInterval: time.Duration(a.UploadInterval) * time.Second,
+			RescanInterval: time.Duration(a.RescanInterval) * time.Second,
+			Bundle:         !a.NoBundle,
+			Snapshot:       a.Snapshot,
+			Scan:           scanOpts,
+			Upload:         uploadOpts,
+		}
+		watchDaemon(debug, a.SkipFullInit, a.Storage, a.KeyFile, a.DbFile, a.RootDir, opts)
 		break
 
 	case "webdav":
 		debug := uint8(CLI.Debug)
 		a := CLI.Webdav
-		startWebdav(debug, a.ClientFile, a.TokenFile, a.KeyFile, a.FolderID, a.CacheFile, a.LocalAddr, a.UserFile, a.CacheSizeMB, a.UseTLS, a.Cert, a.CertKey, a.UpdateInterval)
+		limits, err := newLimits("download", a.Limit, a.ConnLimit, a.LimitFile)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(604)
+		}
+		startWebdav(debug, a.Storage, a.KeyFile, a.Share, a.LocalAddr, a.UserFile, a.CacheSizeMB, a.UseTLS, a.Cert, a.CertKey, a.UpdateInterval, limits)
+		break
+
+	case "restore":
+		debug := uint8(CLI.Debug)
+		a := CLI.Restore
+		restore(debug, a.Storage, a.KeyFile, a.Path, a.TargetDir)
+		break
+
+	case "verify":
+		debug := uint8(CLI.Debug)
+		a := CLI.Verify
+		verify(debug, a.Storage, a.KeyFile, a.Deep)
+		break
+
+	case "share":
+		debug := uint8(CLI.Debug)
+		a := CLI.Share
+		share(debug, a.Storage, a.KeyFile, a.ShareKey, a.Path, a.Name, a.Remove)
+		break
+
+	case "rekey":
+		debug := uint8(CLI.Debug)
+		a := CLI.Rekey
+		rekey(debug, a.Storage, a.KeyFile, a.NewKeyFile, a.DbFile, a.Full, a.Cleanup, a.TryCleanup)
+		break
+
+	case "list":
+		debug := uint8(CLI.Debug)
+		a := CLI.Snapshots.List
+		listSnapshots(debug, a.Storage)
+		break
+
+	case "prune":
+		debug := uint8(CLI.Debug)
+		a := CLI.Snapshots.Prune
+		policy := core.KeepPolicy{Last: a.KeepLast, Daily: a.KeepDaily, Weekly: a.KeepWeekly, Monthly: a.KeepMonthly}
+		pruneSnapshots(debug, a.Storage, a.KeyFile, policy, a.Cleanup, a.Try)
+		break
+
+	case "ls":
+		a := CLI.Ls
+		list(a.KeyFile, a.DbFile, a.Path)
+		break
+
+	case "tree":
+		a := CLI.Tree
+		tree(a.KeyFile, a.DbFile, a.Path)
+		break
+
+	case "find":
+		a := CLI.Find
+		filter := db.Filter{Pattern: a.Pattern, MinSize: a.MinSize, MaxSize: a.MaxSize}
+		if !a.After.IsZero() {
+			filter.After = a.After.Unix()
+		}
+		if !a.Before.IsZero() {
+			filter.Before = a.Before.Unix() - 1
+		}
+		find(a.KeyFile, a.DbFile, a.Path, filter)
+		break
+
+	case "stat":
+		a := CLI.Stat
+		stat(a.KeyFile, a.DbFile, a.Path)
+		break
+
+	case "cat":
+		debug := uint8(CLI.Debug)
+		a := CLI.Cat
+		cat(debug, a.Storage, a.KeyFile, a.DbFile, a.Path)
 		break
 
 	case "adduser":
@@ -149,14 +590,18 @@ func main() {
 
 //-##################################################################################################################-//
 
-func upload(scanOnly bool, debugLvl uint8, skipFullInit bool, clientStr, tokenStr, keyStr, folderId, cacheStr, dbStr, rootStr string, forceFlag, bundleFlag, cleanUpFlag, cleanUpSimulation bool) {
+func upload(scanOnly bool, debugLvl uint8, skipFullInit bool, storage StorageFlags, keyStr, dbStr, rootStr string, scanOpts db.ScanOptions, uploadOpts core.UploadOptions, forceFlag, bundleFlag, cleanUpFlag, cleanUpSimulation, snapshotFlag, resumeFlag, restartFlag bool) {
 
 	// load keyfile
-	keyFile, err := enc.LoadKeyFile(keyStr)
+	keyFile, err := loadKeyFile(keyStr)
 	if err != nil {
 		fmt.Printf("[FATAL ERROR] %v\n", err)
 		os.Exit(501)
 	}
+	if keyFile.IsReader() {
+		fmt.Printf("[FATAL ERROR] '%s' is a reader key file: scan and upload require the master key file\n", keyStr)
+		os.Exit(508)
+	}
 
 	// load db (if exist)
 	oldDb, err := db.FromFile(dbStr, keyFile.IndexKey())
@@ -164,39 +609,117 @@ func upload(scanOnly bool, debugLvl uint8, skipFullInit bool, clientStr, tokenSt
 		println(err) // WARNING: NO EXIT!
 	}
 
+	// unfinished upload (@see core.Journal)
+	// an upload stopped by the quota is continued without --resume
+	journalStr := dbStr + ".journal"
+	pendingStr := dbStr + ".pending"
+	resume := false
+	if !scanOnly && core.JournalExists(journalStr) {
+		paused := core.JournalPaused(journalStr)
+		if resumeFlag && restartFlag || resumeFlag == restartFlag && !paused {
+			fmt.Printf("[FATAL ERROR] unfinished upload found ('%s'): use --resume or --restart\n", journalStr)
+			os.Exit(510)
+		}
+		resume = !restartFlag
+		if _, err := os.Stat(pendingStr); resume && err == nil {
+			oldDb, err = db.FromFile(pendingStr, keyFile.IndexKey()) // the files of the interrupted upload are not scanned again
+			if err != nil {
+				fmt.Printf("[FATAL ERROR] %v\n", err)
+				os.Exit(513)
+			}
+		}
+	}
+
+	// progress (optional)
+	rep := newProgress()
+	scanOpts.Progress = rep
+	uploadOpts.Progress = rep
+
 	// SCAN DIR
-	newDb, change, _, err := db.FromScan(rootStr, oldDb, debugLvl, keyFile)
+	newDb, change, summary, err := db.FromScanWithOptions(rootStr, oldDb, scanOpts, debugLvl, keyFile)
 	if err != nil {
 		fmt.Printf("[FATAL ERROR] %v\n", err)
 		os.Exit(502)
 	}
-	if !change && !forceFlag {
-		// no change AND no upload-force
+	fmt.Printf("[INFO] %s\n", summary)
+	replicated := !scanOnly && len(storage.Backend) > 1
+	if !change && !forceFlag && !replicated && !resume {
+		// no change AND no upload-force (replicas are always repaired)
 		return // --> EXIT
 	}
 
 	// make bundles (optional)
 	if bundleFlag {
-		newDb.MakeBundles(keyFile, debugLvl)
+		newDb.MakeBundlesWithProgress(keyFile, rep, debugLvl)
 	}
 
 	//-----------------------------------------------------
 	if !scanOnly {
-		// build oauth
-		oauth, err := gdrive.OAuth(clientStr, tokenStr, false)
+		// build service for upload
+		service, err := newService(storage, false, skipFullInit, nil, debugLvl)
 		if err != nil {
 			fmt.Printf("[FATAL ERROR] %v\n", err)
 			os.Exit(503)
 		}
 
-		// build service for upload
-		service := gdrive.NewGService(folderId, cacheStr, skipFullInit, oauth, nil, debugLvl)
-
 		// UPLOAD files & db
-		err = core.Upload(rootStr, newDb, keyFile.IndexKey(), service, debugLvl)
-		if err != nil {
-			fmt.Printf("[FATAL ERROR] %v\n", err)
-			os.Exit(504)
+		if change || forceFlag || resume {
+			// progress journal and scanned db for the next run (if the upload is interrupted)
+			journal, err := core.OpenJournal(journalStr, !resume)
+			if err != nil {
+				fmt.Printf("[FATAL ERROR] %v\n", err)
+				os.Exit(511)
+			}
+			if err := db.ToFile(newDb, keyFile.IndexKey(), pendingStr); err != nil {
+				fmt.Printf("[FATAL ERROR] %v\n", err)
+				os.Exit(512)
+			}
+			uploadOpts.Journal = journal
+
+			err = core.UploadWithOptions(rootStr, newDb, keyFile.IndexKey(), service, uploadOpts, debugLvl)
+			quotaErr, paused := err.(*core.QuotaError)
+			if err != nil && !paused {
+				fmt.Printf("[FATAL ERROR] %v\n", err)
+				os.Exit(504)
+			}
+			if resume {
+				fmt.Printf("[INFO] upload resumed: %d parts of the previous run skipped\n", journal.Resumed())
+			}
+			if paused {
+				// quota reached: the uploaded index and the local db contain only the online files (changed files: previous version)
+				// the journal and the scanned db are kept for the next run
+				runBytes, dayBytes := uploadOpts.Quota.Used()
+				fmt.Printf("[INFO] %v (continued by the next run): %d bytes uploaded, %d bytes in the last 24 hours\n", quotaErr, runBytes, dayBytes)
+				if err := journal.Pause(); err != nil {
+					fmt.Printf("[FATAL ERROR] %v\n", err)
+					os.Exit(515)
+				}
+				newDb = quotaErr.Db
+				cleanUpFlag = false // the parts of the missing files are not in the index
+			} else {
+				_ = journal.Remove()
+				_ = os.Remove(pendingStr)
+			}
+		}
+
+		// keep a copy of the index (optional)
+		if snapshotFlag {
+			name, err := core.UploadSnapshot(newDb, keyFile.IndexKey(), service, debugLvl)
+			if err != nil {
+				fmt.Printf("[FATAL ERROR] %v\n", err)
+				os.Exit(509)
+			}
+			fmt.Printf("[INFO] snapshot '%s' uploaded\n", name)
+		}
+
+		// copy missing files to all replicas
+		if rs, ok := service.(replica.Service); ok {
+			if _, err := rs.Repair(); err != nil {
+				fmt.Printf("[ERROR] %v\n", err) // SOFT FAIL: see health
+			}
+			for _, h := range rs.Health() {
+				fmt.Printf("[REPLICA] %s: healthy=%v, files=%d, missing=%d, read_errors=%d %s\n", h.Name, h.Healthy, h.Files, h.Missing, h.ReadErrs, h.LastError)
+			}
 		}
 
 		// unnecessary files online (optional)
@@ -206,7 +729,7 @@ func upload(scanOnly bool, debugLvl uint8, skipFullInit bool, clientStr, tokenSt
 				fmt.Printf("[FATAL ERROR] %v\n", err)
 				os.Exit(505)
 			}
-			err = core.Clean(newDb, service, cleanUpSimulation, debugLvl)
+			err = core.CleanWithProgress(newDb, keyFile.IndexKey(), service, cleanUpSimulation, rep, debugLvl)
 			if err != nil {
 				fmt.Printf("[FATAL ERROR] %v\n", err)
 				os.Exit(506)
@@ -223,23 +746,145 @@ func upload(scanOnly bool, debugLvl uint8, skipFullInit bool, clientStr, tokenSt
 	}
 }
 
-func startWebdav(debugLvl uint8, clientStr, tokenStr, keyStr, folderId, cacheStr, lAddr, userDbStr string, cacheSizeMB int, useTLS bool, certStr, certKeyStr string, updateInterval int) {
+func exportPaper(keyStr, outStr string) {
+	text, err := enc.ExportPaper(keyStr, passphrase(fmt.Sprintf("Passphrase for '%s': ", keyStr), false))
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(51)
+	}
 
-	// check free ram
-	checkFreeRam(cacheSizeMB)
+	// stdout
+	if outStr == "" {
+		fmt.Print(text)
+		return
+	}
 
-	// load keyfile
-	keyFile, err := enc.LoadKeyFile(keyStr)
+	// file (must not exist)
+	fh, err := os.OpenFile(outStr, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
 		fmt.Printf("[FATAL ERROR] %v\n", err)
-		os.Exit(601)
+		os.Exit(52)
+	}
+	defer fh.Close()
+	if _, err := fh.WriteString(text); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(53)
 	}
+}
+
+func importPaper(keyStr, inStr string, protect bool) {
 
-	// build oauth
-	oauth, err := gdrive.OAuth(clientStr, tokenStr, true)
+	// read paper key
+	var b []byte
+	var err error
+	if inStr == "" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(inStr)
+	}
 	if err != nil {
 		fmt.Printf("[FATAL ERROR] %v\n", err)
-		os.Exit(602)
+		os.Exit(61)
+	}
+
+	// passphrase (optional)
+	var pass []byte
+	if protect {
+		pass, err = passphrase("New passphrase: ", true)()
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(62)
+		}
+	}
+
+	// import
+	keyFile, err := enc.ImportPaper(string(b), keyStr, pass)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(63)
+	}
+	fmt.Printf("[INFO] key file '%s' imported: fingerprint %s\n", keyStr, keyFile.Fingerprint())
+}
+
+func splitKey(keyStr, outPrefix string, n, k int) {
+	shares, err := enc.SplitKeyFile(keyStr, passphrase(fmt.Sprintf("Passphrase for '%s': ", keyStr), false), n, k)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(71)
+	}
+
+	// write share files (must not exist)
+	for i, share := range shares {
+		p := fmt.Sprintf("%s.%d.dat", outPrefix, i+1)
+		fh, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(72)
+		}
+		_, err = fh.Write(share)
+		if err2 := fh.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(73)
+		}
+		fmt.Printf("[INFO] share %d of %d (threshold %d): %s\n", i+1, n, k, p)
+	}
+}
+
+func combineKey(keyStr string, shareFiles []string, protect bool) {
+
+	// read share files
+	shares := make([][]byte, 0, len(shareFiles))
+	for _, p := range shareFiles {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(81)
+		}
+		shares = append(shares, b)
+	}
+
+	// passphrase (optional)
+	var pass []byte
+	if protect {
+		var err error
+		pass, err = passphrase("New passphrase: ", true)()
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(82)
+		}
+	}
+
+	// combine
+	keyFile, err := enc.CombineShares(shares, keyStr, pass)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(83)
+	}
+	fmt.Printf("[INFO] key file '%s' recovered: fingerprint %s\n", keyStr, keyFile.Fingerprint())
+}
+
+func exportReader(keyStr, outStr string) {
+	keyFile, err := enc.ExportReaderKey(keyStr, passphrase(fmt.Sprintf("Passphrase for '%s': ", keyStr), false), outStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(91)
+	}
+	fmt.Printf("[INFO] reader key file '%s' written: fingerprint %s\n", outStr, keyFile.Fingerprint())
+}
+
+func startWebdav(debugLvl uint8, storage StorageFlags, keyStr, shareName, lAddr, userDbStr string, cacheSizeMB int, useTLS bool, certStr, certKeyStr string, updateInterval int, limits *limit.Limits) {
+
+	// check free ram
+	checkFreeRam(cacheSizeMB)
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(601)
 	}
 
 	// build sector cache for random read access
@@ -249,16 +894,659 @@ func startWebdav(debugLvl uint8, clientStr, tokenStr, keyStr, folderId, cacheStr
 	}
 
 	// build service for READ ACCESS
-	service := gdrive.NewGService(folderId, cacheStr, false, oauth, sectorCache, debugLvl)
+	service, err := newService(storage, true, false, sectorCache, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(602)
+	}
+
+	// index or share
+	indexName := core.IndexName
+	if shareName != "" {
+		indexName, err = core.ShareIndexName(shareName)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(603)
+		}
+	}
 
 	// RUN webdav server
-	fs := webdav.NewFileSystem(service, keyFile.IndexKey(), debugLvl, updateInterval)
+	fs := webdav.NewFileSystemWithLimits(service, indexName, keyFile.IndexKey(), debugLvl, updateInterval, limits)
 	err = webdav.Serve(lAddr, useTLS, certStr, certKeyStr, fs, userDbStr, debugLvl)
 	if err != nil {
 		fmt.Printf("[DEBUG] %v\n", err) // SOFT FAIL
 	}
 }
 
+func restore(debugLvl uint8, storage StorageFlags, keyStr, relPrefix, targetStr string) {
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(801)
+	}
+
+	// build service for READ ACCESS
+	service, err := newService(storage, true, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(802)
+	}
+	if err := service.Update(); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(803)
+	}
+
+	// load db from storage
+	vDb, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(804)
+	}
+
+	// RESTORE
+	failed, summary, err := core.Restore(vDb, service, relPrefix, targetStr, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(805)
+	}
+	fmt.Printf("%s\n", summary)
+
+	// error report
+	if len(failed) > 0 {
+		list := make([]string, 0, len(failed))
+		for relPath := range failed {
+			list = append(list, relPath)
+		}
+		sort.Strings(list)
+		for _, relPath := range list {
+			fmt.Printf("[ERROR] %s: %v\n", relPath, failed[relPath])
+		}
+		os.Exit(806)
+	}
+}
+
+func verify(debugLvl uint8, storage StorageFlags, keyStr string, deep bool) {
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(901)
+	}
+
+	// build service for READ ACCESS
+	service, err := newService(storage, true, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(902)
+	}
+	if err := service.Update(); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(903)
+	}
+
+	// load db from storage
+	vDb, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(904)
+	}
+
+	// VERIFY
+	report, err := core.Verify(vDb, service, deep, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(905)
+	}
+
+	// print report (JSON)
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(906)
+	}
+	fmt.Printf("%s\n", b)
+	if !report.Ok() {
+		os.Exit(907)
+	}
+}
+
+func share(debugLvl uint8, storage StorageFlags, keyStr, shareKeyStr, relPrefix, name string, remove bool) {
+
+	// build service for upload
+	service, err := newService(storage, false, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1201)
+	}
+	if err := service.Update(); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1202)
+	}
+
+	// REMOVE
+	if remove {
+		if err := core.RemoveShare(name, service); err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1203)
+		}
+		return
+	}
+
+	// load keyfile and db from storage
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1204)
+	}
+	vDb, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1205)
+	}
+
+	// share key: reuse or create
+	var shareKey *enc.KeyFile
+	if _, err := os.Stat(shareKeyStr); err == nil {
+		shareKey, err = loadNewKeyFile(shareKeyStr)
+	} else {
+		shareKey, err = enc.CreateReaderKeyFile(shareKeyStr)
+	}
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1206)
+	}
+	if bytes.Equal(shareKey.IndexKey(), keyFile.IndexKey()) {
+		fmt.Printf("[FATAL ERROR] the share key is the main key\n")
+		os.Exit(1207)
+	}
+
+	// UPLOAD share
+	sub, err := core.UploadShare(vDb, relPrefix, name, shareKey.IndexKey(), keyFile.IndexKey(), service, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1208)
+	}
+	fmt.Printf("[INFO] share '%s' with %d elements uploaded: key file '%s', fingerprint %s\n", name, len(sub.VFiles), shareKeyStr, shareKey.Fingerprint())
+}
+
+func rekey(debugLvl uint8, storage StorageFlags, keyStr, newKeyStr, dbStr string, full, cleanUpFlag, cleanUpSimulation bool) {
+
+	// load keyfiles
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1101)
+	}
+	newKeyFile, err := loadNewKeyFile(newKeyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1102)
+	}
+	if bytes.Equal(keyFile.IndexKey(), newKeyFile.IndexKey()) {
+		fmt.Printf("[FATAL ERROR] the new key is the old key\n")
+		os.Exit(1103)
+	}
+
+	// build service for upload
+	service, err := newService(storage, false, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1104)
+	}
+	if err := service.Update(); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1105)
+	}
+
+	// load db from storage (with the new key, if a previous run has already uploaded the index)
+	vDb, err := core.LoadDb(service, keyFile.IndexKey())
+	if err != nil {
+		var err2 error
+		vDb, err2 = core.LoadDb(service, newKeyFile.IndexKey())
+		if err2 != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1106)
+		}
+		fmt.Printf("[INFO] the online index is already encrypted with the new key\n")
+	}
+
+	// REKEY
+	newDb, err := core.Rekey(vDb, keyFile, newKeyFile, service, full, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v (run the command again to resume)\n", err)
+		os.Exit(1107)
+	}
+
+	// remove the old parts and bundles (optional)
+	if cleanUpFlag {
+		if err := core.CleanWithProgress(newDb, newKeyFile.IndexKey(), service, cleanUpSimulation, newProgress(), debugLvl); err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1108)
+		}
+	}
+
+	// save db local (encrypted with the new key)
+	if err := db.ToFile(newDb, newKeyFile.IndexKey(), dbStr); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1109)
+	}
+	fmt.Printf("[INFO] rekey done: replace '%s' with '%s'\n", keyStr, newKeyStr)
+}
+
+func watchDaemon(debugLvl uint8, skipFullInit bool, storage StorageFlags, keyStr, dbStr, rootStr string, opts watch.Options) {
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1301)
+	}
+	if keyFile.IsReader() {
+		fmt.Printf("[FATAL ERROR] '%s' is a reader key file: watch requires the master key file\n", keyStr)
+		os.Exit(1302)
+	}
+
+	// build service for upload
+	service, err := newService(storage, false, skipFullInit, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1303)
+	}
+
+	// graceful shutdown: the first signal stops the daemon after the running upload, a second SIGINT (Ctrl+C) kills it
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		fmt.Printf("[INFO] stopping (waiting for the running upload)\n")
+		close(stop)
+		for sig := range signals {
+			if sig == syscall.SIGINT {
+				fmt.Printf("[FATAL ERROR] killed\n")
+				os.Exit(1305)
+			}
+		}
+	}()
+
+	// run
+	if err := watch.Run(rootStr, dbStr, keyFile, service, opts, stop, debugLvl); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1304)
+	}
+}
+
+func listSnapshots(debugLvl uint8, storage StorageFlags) {
+
+	// build service (the snapshots are listed by name, no key is required)
+	service, err := newService(storage, true, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1402)
+	}
+	if err := service.Update(); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1403)
+	}
+
+	// print
+	for _, s := range core.Snapshots(service) {
+		fmt.Printf("%s  %12d  %s\n", s.Name(), s.File.Size(), s.Time.Local().Format("2006-01-02 15:04:05"))
+	}
+}
+
+func pruneSnapshots(debugLvl uint8, storage StorageFlags, keyStr string, policy core.KeepPolicy, cleanUpFlag, try bool) {
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1404)
+	}
+
+	// build service
+	service, err := newService(storage, false, false, nil, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1405)
+	}
+
+	// PRUNE
+	removed, err := core.PruneSnapshots(service, policy, try, debugLvl)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1406)
+	}
+	for _, s := range removed {
+		fmt.Printf("[INFO] remove snapshot '%s' (try=%v)\n", s.Name(), try)
+	}
+
+	// remove the parts that are no longer referenced (optional)
+	if cleanUpFlag {
+		vDb, err := core.LoadDb(service, keyFile.IndexKey())
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1407)
+		}
+		if err := core.CleanWithProgress(vDb, keyFile.IndexKey(), service, try, newProgress(), debugLvl); err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1408)
+		}
+	}
+}
+
+// loadLocalDb loads a local db file. The program exits if the file does not exist.
+func loadLocalDb(keyStr, dbStr string) db.Db {
+
+	// load keyfile
+	keyFile, err := loadKeyFile(keyStr)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1001)
+	}
+
+	// db file must exist (FromFile returns an empty db)
+	if _, err := os.Stat(dbStr); err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1002)
+	}
+
+	// load db
+	vDb, err := db.FromFile(dbStr, keyFile.IndexKey())
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1003)
+	}
+	return vDb
+}
+
+// dbElement returns an element from the db. The program exits if the element does not exist.
+func dbElement(vDb db.Db, relPath string) db.VirtFile {
+	// path fix (@see webdav.pathFix)
+	relPath = strings.Trim(relPath, "/")
+	if relPath == "" {
+		relPath = "."
+	}
+
+	// get element
+	vFile, ok := vDb.VFiles[relPath]
+	if !ok {
+		fmt.Printf("[FATAL ERROR] path not found in db: '%s'\n", relPath)
+		os.Exit(1004)
+	}
+	return vFile
+}
+
+// formatLine returns a 'ls -l' like line for an element.
+func formatLine(vFile db.VirtFile, name string) string {
+	t, suffix := "-", ""
+	if vFile.IsDir {
+		t, suffix = "d", "/"
+	}
+	mtime := time.Unix(vFile.MTime, 0).Format("2006-01-02 15:04:05")
+	return fmt.Sprintf("%s %15d  %s  %s%s", t, vFile.FileSize, mtime, name, suffix)
+}
+
+func list(keyStr, dbStr, relPath string) {
+	vDb := loadLocalDb(keyStr, dbStr)
+	vFile := dbElement(vDb, relPath)
+
+	// single file
+	if !vFile.IsDir {
+		fmt.Printf("%s\n", formatLine(vFile, vFile.Name()))
+		return
+	}
+
+	// folder content
+	for _, fc := range vFile.FolderContent {
+		sub, ok := vDb.VFiles[path.Join(vFile.RelPath, fc.RelPath)]
+		if !ok {
+			sub = db.VirtFile{RelPath: fc.RelPath, IsDir: fc.IsDir} // not in db
+		}
+		fmt.Printf("%s\n", formatLine(sub, fc.RelPath))
+	}
+}
+
+func tree(keyStr, dbStr, relPath string) {
+	vDb := loadLocalDb(keyStr, dbStr)
+	vFile := dbElement(vDb, relPath)
+
+	// print recursive
+	var printTree func(vFile db.VirtFile, indent string)
+	printTree = func(vFile db.VirtFile, indent string) {
+		for i, fc := range vFile.FolderContent {
+			branch, next := "├── ", "│   "
+			if i == len(vFile.FolderContent)-1 {
+				branch, next = "└── ", "    "
+			}
+			if !fc.IsDir {
+				fmt.Printf("%s%s%s\n", indent, branch, fc.RelPath)
+				continue
+			}
+			fmt.Printf("%s%s%s/\n", indent, branch, fc.RelPath)
+			if sub, ok := vDb.VFiles[path.Join(vFile.RelPath, fc.RelPath)]; ok {
+				printTree(sub, indent+next)
+			}
+		}
+	}
+	fmt.Printf("%s\n", vFile.RelPath)
+	printTree(vFile, "")
+}
+
+func find(keyStr, dbStr, relPrefix string, filter db.Filter) {
+	vDb := loadLocalDb(keyStr, dbStr)
+
+	// find
+	list, err := vDb.Find(filter)
+	if err != nil {
+		fmt.Printf("[FATAL ERROR] %v\n", err)
+		os.Exit(1005)
+	}
+
+	// print
+	for _, vFile := range list {
+		if core.InPath(vFile.RelPath, relPrefix) {
+			fmt.Printf("%s\n", formatLine(vFile, vFile.RelPath))
+		}
+	}
+}
+
+func stat(keyStr, dbStr, relPath string) {
+	vDb := loadLocalDb(keyStr, dbStr)
+	vFile := dbElement(vDb, relPath)
+
+	// basics
+	fmt.Printf("Path:         %s\n", vFile.RelPath)
+	if vFile.IsDir {
+		fmt.Printf("Type:         folder\n")
+		fmt.Printf("MTime:        %s\n", time.Unix(vFile.MTime, 0).Format(time.RFC3339))
+		fmt.Printf("Content:      %d elements\n", len(vFile.FolderContent))
+		return
+	}
+	fmt.Printf("Type:         file\n")
+	fmt.Printf("Size:         %d\n", vFile.FileSize)
+	fmt.Printf("MTime:        %s\n", time.Unix(vFile.MTime, 0).Format(time.RFC3339))
+	fmt.Printf("Compression:  %v\n", vFile.UseCompression)
+
+	// bundle
+	if bundle, ok := vDb.Bundles[vFile.AlsoInBundle]; ok {
+		fmt.Printf("Bundle:       %s (%d bytes, %d files)\n", bundle.StorageName, bundle.StorageSize, len(bundle.Content))
+	} else {
+		fmt.Printf("Bundle:       -\n")
+	}
+
+	// parts
+	fmt.Printf("Parts:        %d\n", len(vFile.Parts))
+	for i, part := range vFile.Parts {
+		fmt.Printf("  [%d] name:   %s\n", i, part.StorageName)
+		fmt.Printf("      size:   %d\n", part.StorageSize)
+		fmt.Printf("      md5:    %s\n", part.StorageMd5)
+		fmt.Printf("      sha512: %x\n", part.PlainSHA512)
+	}
+}
+
+func cat(debugLvl uint8, storage StorageFlags, keyStr, dbStr, relPath string) {
+	vDb := loadLocalDb(keyStr, dbStr)
+	vFile := dbElement(vDb, relPath)
+	if vFile.IsDir {
+		fmt.Printf("[FATAL ERROR] path is a folder: '%s'\n", vFile.RelPath)
+		os.Exit(1006)
+	}
+
+	// build service (only if there is content)
+	var service interf.Service = impl.NewRamService(nil, impl.DebugOff) // dummy service
+	if vFile.FileSize > 0 {
+		var err error
+		service, err = newService(storage, true, false, nil, debugLvl)
+		if err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1007)
+		}
+		if err := service.Update(); err != nil {
+			fmt.Printf("[FATAL ERROR] %v\n", err)
+			os.Exit(1008)
+		}
+	}
+
+	// open and write to stdout
+	rAt, err := core.Open(vFile, vDb, service, debugLvl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[FATAL ERROR] %v\n", err)
+		os.Exit(1009)
+	}
+	defer rAt.Close()
+	if _, err := io.Copy(os.Stdout, io.NewSectionReader(rAt, 0, vFile.FileSize)); err != nil {
+		fmt.Fprintf(os.Stderr, "[FATAL ERROR] %v\n", err)
+		os.Exit(1010)
+	}
+}
+
+// loadKeyFile loads a (protected) key file.
+// The passphrase is read from --pass-fd, --pass-env or the terminal.
+func loadKeyFile(keyStr string) (*enc.KeyFile, error) {
+	return enc.LoadKeyFileWithPassphrase(keyStr, passphrase(fmt.Sprintf("Passphrase for '%s': ", keyStr), false))
+}
+
+// loadNewKeyFile loads a (protected) key file.
+// The passphrase is read from --new-pass-fd, --new-pass-env or the terminal.
+func loadNewKeyFile(keyStr string) (*enc.KeyFile, error) {
+	return enc.LoadKeyFileWithPassphrase(keyStr, newPassphrase(fmt.Sprintf("Passphrase for '%s': ", keyStr), false))
+}
+
+// newProgress returns the progress reporter of the --progress flag (nil: off).
+func newProgress() *progress.Reporter {
+	switch CLI.Progress {
+	case "bar":
+		return progress.New(progress.Bar(os.Stderr))
+	case "json":
+		return progress.New(progress.JSON(os.Stdout))
+	}
+	return nil
+}
+
+// passphrase returns the passphrase source for key files.
+func passphrase(prompt string, confirm bool) enc.PassphraseFunc {
+	return enc.PassphraseSource(CLI.PassEnv, CLI.PassFd, prompt, confirm)
+}
+
+// newPassphrase returns the passphrase source for new key files.
+func newPassphrase(prompt string, confirm bool) enc.PassphraseFunc {
+	return enc.PassphraseSource(CLI.NewPassEnv, CLI.NewPassFd, prompt, confirm)
+}
+
+// newService builds the storage service selected with the backend flag.
+// Multiple backends are combined to a replicated service (@see replica.Service).
+// readOnly requests only read rights (gdrive); skipFullInit accelerates the start (gdrive).
+// readerCache=nil disable the cache for ReaderAt() and MultiReaderAt()
+func newService(storage StorageFlags, readOnly, skipFullInit bool, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	// single backend
+	if len(storage.Backend) == 1 {
+		return newBackend(storage.Backend[0], storage, readOnly, skipFullInit, readerCache, debugLvl)
+	}
+
+	// replicas
+	replicas := make([]replica.Replica, 0, len(storage.Backend))
+	for i, name := range storage.Backend {
+		for _, other := range storage.Backend[:i] {
+			if name == other {
+				return nil, fmt.Errorf("backend is used twice: '%s'", name)
+			}
+		}
+		service, err := newBackend(name, storage, readOnly, skipFullInit, nil, debugLvl)
+		if err != nil {
+			fmt.Printf("[WARNING] backend '%s' is not available: %v\n", name, err) // SOFT FAIL: use the other replicas
+			continue
+		}
+		replicas = append(replicas, replica.Replica{Name: name, Service: service})
+	}
+	return replica.NewReplicaService(replicas, readerCache, debugLvl)
+}
+
+// newBackend builds a single storage service (gdrive, local, s3 or sftp).
+func newBackend(backend string, storage StorageFlags, readOnly, skipFullInit bool, readerCache interf.Cache, debugLvl uint8) (interf.Service, error) {
+	switch backend {
+	case "local":
+		return local.NewLocalService(storage.StorageDir, readerCache, debugLvl)
+	case "s3":
+		conf := s3.Config{
+			Endpoint:  storage.S3Endpoint,
+			Region:    storage.S3Region,
+			Bucket:    storage.S3Bucket,
+			Prefix:    storage.S3Prefix,
+			AccessKey: storage.S3Access,
+			SecretKey: storage.S3Secret,
+		}
+		return s3.NewS3Service(conf, readerCache, debugLvl)
+	case "sftp":
+		dial := func() (*pkgsftp.Client, error) {
+			return sftp.Dial(storage.SftpAddr, storage.SftpUser, storage.SftpPass, storage.SftpKey, storage.SftpHosts)
+		}
+		return sftp.NewSftpServiceWithDial(dial, storage.SftpDir, readerCache, debugLvl)
+	default:
+		oauth, err := gdrive.OAuth(storage.ClientFile, storage.TokenFile, readOnly)
+		if err != nil {
+			return nil, err
+		}
+		return gdrive.NewGService(storage.FolderID, storage.CacheFile, skipFullInit, oauth, readerCache, debugLvl), nil
+	}
+}
+
+// newLimits returns the bandwidth limits of one direction ('upload' or 'download').
+// The limit file overrides the flags and is reloaded after changes (@see limit.WatchFile).
+func newLimits(direction, globalStr, connStr, fileStr string) (*limit.Limits, error) {
+	global, err := limit.ParseSchedule(globalStr)
+	if err != nil {
+		return nil, fmt.Errorf("--%s-limit: %v", direction, err)
+	}
+	conn, err := limit.ParseSchedule(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("--%s-conn-limit: %v", direction, err)
+	}
+	limits := limit.NewLimits(global, conn)
+
+	// limit file (optional)
+	if fileStr != "" {
+		if _, err := limit.LoadFile(fileStr); err != nil {
+			return nil, err
+		}
+		limit.WatchFile(fileStr, 10*time.Second, func(m map[string]limit.Schedule) {
+			g, c := global, conn // keys not in the file: flag values
+			if s, ok := m[direction]; ok {
+				g = s
+			}
+			if s, ok := m[direction+"-conn"]; ok {
+				c = s
+			}
+			limits.Set(g, c)
+			fmt.Printf("[INFO] %s limit: global='%s', connection='%s'\n", direction, g, c)
+		})
+	}
+	return limits, nil
+}
+
 // checkFreeRam check and print the ram usage.
 // The program crashes if the cache does not have enough memory available.
 // cacheSizeMB + 20% is needed!
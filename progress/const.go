@@ -0,0 +1,12 @@
+package progress
+
+import "time"
+
+// bytesInterval is the minimal time between two Bytes events (transfer progress).
+const bytesInterval = time.Second
+
+// barInterval is the minimal time between two redraws of the progress bar.
+const barInterval = 200 * time.Millisecond
+
+// barWidth is the number of characters of the progress bar.
+const barWidth = 20
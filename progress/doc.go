@@ -0,0 +1,7 @@
+/*
+Package progress reports the progress of long running operations (scan, bundle, upload and clean) as typed events.
+The events contain the counters, the throughput and the ETA of the stage and are rendered as a live terminal
+progress bar (@see Bar) or as JSON lines for monitoring tools (@see JSON).
+
+*/
+package progress
@@ -0,0 +1,224 @@
+package progress
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Stage is a long running operation.
+type Stage string
+
+const (
+	StageScan   Stage = "scan"   // new and changed files are hashed (@see db.FromScanWithOptions)
+	StageBundle Stage = "bundle" // small files are bundled (@see db.Db.MakeBundlesWithProgress)
+	StageUpload Stage = "upload" // parts and bundles are uploaded (@see core.UploadWithOptions)
+	StageClean  Stage = "clean"  // unreferenced storage files are removed (@see core.CleanWithProgress)
+)
+
+// Kind is the type of an event.
+type Kind string
+
+const (
+	Start    Kind = "start"    // stage started
+	Found    Kind = "found"    // file found that must be processed (the totals are increased)
+	Hashed   Kind = "hashed"   // file hashed (scan)
+	Bundled  Kind = "bundled"  // bundle created
+	Uploaded Kind = "uploaded" // part or bundle uploaded
+	Removed  Kind = "removed"  // storage file removed (clean)
+	Skipped  Kind = "skipped"  // item not processed (e.g. exists on storage, quota reached or try mode)
+	Failed   Kind = "failed"   // item failed
+	Bytes    Kind = "bytes"    // transfer progress of the running items
+	Done     Kind = "done"     // stage finished
+)
+
+// Event is a progress event with the state of the stage.
+// Skipped and failed items are also done items (the bytes are counted, so the stage ends at 100%).
+type Event struct {
+	Time       time.Time `json:"time"`
+	Stage      Stage     `json:"stage"`
+	Kind       Kind      `json:"kind"`
+	Path       string    `json:"path,omitempty"` // file, part or bundle of the event (item events)
+	Size       int64     `json:"size,omitempty"` // bytes of the item (item events)
+	Items      int64     `json:"items"`          // done items
+	TotalItems int64     `json:"total_items"`
+	Bytes      int64     `json:"bytes"` // done bytes (with the transferred bytes of the running items)
+	TotalBytes int64     `json:"total_bytes"`
+	Skipped    int64     `json:"skipped"`
+	Failed     int64     `json:"failed"`
+	Rate       float64   `json:"rate"`    // bytes per second (without the skipped items)
+	Elapsed    float64   `json:"elapsed"` // seconds since the start of the stage
+	ETA        float64   `json:"eta"`     // remaining seconds (0=unknown or finished)
+}
+
+// Handler receives the events (@see Bar and JSON). The calls are serialized.
+type Handler func(Event)
+
+// Reporter collects the progress of the current stage and sends the events to the handler (thread-safe).
+// All methods can be called on a nil Reporter (no progress).
+type Reporter struct {
+	mux       *sync.Mutex
+	handler   Handler
+	state     Event
+	start     time.Time
+	running   int64 // transferred bytes of the running tasks
+	work      int64 // done bytes without the skipped items (throughput)
+	lastBytes time.Time
+}
+
+// Task is a running item of a stage (e.g. a file or a part).
+// The transferred bytes are reported while the task runs (@see Task.Add and Task.Reader).
+type Task struct {
+	r    *Reporter
+	path string
+	size int64
+	n    int64 // transferred bytes
+}
+
+// New returns a reporter that sends all events to the handler.
+func New(handler Handler) *Reporter {
+	if handler == nil {
+		return nil
+	}
+	return &Reporter{
+		mux:     new(sync.Mutex),
+		handler: handler,
+	}
+}
+
+// Start starts a new stage with the known totals (Found increases the totals).
+func (r *Reporter) Start(stage Stage, items, bytes int64) {
+	if r == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.start = time.Now()
+	r.running = 0
+	r.work = 0
+	r.state = Event{Stage: stage, TotalItems: items, TotalBytes: bytes}
+	r.emit(Start, "", 0)
+}
+
+// Finish ends the stage.
+func (r *Reporter) Finish() {
+	if r == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.emit(Done, "", 0)
+}
+
+// Found adds an item to the totals of the stage.
+func (r *Reporter) Found(path string, size int64) {
+	if r == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.state.TotalItems++
+	r.state.TotalBytes += size
+	r.emit(Found, path, size)
+}
+
+// Item reports a finished item without a running task (@see Task.Done).
+func (r *Reporter) Item(kind Kind, path string, size int64) {
+	r.Task(path, size).Done(kind)
+}
+
+// Task starts a running item with the size in bytes.
+func (r *Reporter) Task(path string, size int64) *Task {
+	if r == nil {
+		return nil
+	}
+	return &Task{r: r, path: path, size: size}
+}
+
+// Add reports n transferred bytes of the task.
+func (t *Task) Add(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	r := t.r
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	t.n += n
+	r.running += n
+	if time.Since(r.lastBytes) >= bytesInterval {
+		r.lastBytes = time.Now()
+		r.emit(Bytes, "", 0)
+	}
+}
+
+// Reader returns a reader that reports the read bytes of the task (transfer progress).
+func (t *Task) Reader(rd io.Reader) io.Reader {
+	if t == nil {
+		return rd
+	}
+	return &_Reader{rd: rd, t: t}
+}
+
+// Done finishes the task with the kind (e.g. Uploaded, Skipped or Failed). The task size is counted as done bytes.
+func (t *Task) Done(kind Kind) {
+	if t == nil {
+		return
+	}
+	r := t.r
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.running -= t.n
+	t.n = 0
+	r.state.Items++
+	r.state.Bytes += t.size
+	switch kind {
+	case Skipped:
+		r.state.Skipped++
+	case Failed:
+		r.state.Failed++
+		r.work += t.size
+	default:
+		r.work += t.size
+	}
+	r.emit(kind, t.path, t.size)
+}
+
+// emit sends the current state as event (mutex must be locked).
+func (r *Reporter) emit(kind Kind, path string, size int64) {
+	e := r.state
+	e.Time = time.Now()
+	e.Kind = kind
+	e.Path = path
+	e.Size = size
+	e.Bytes += r.running
+
+	// throughput (without skipped items) and ETA
+	elapsed := e.Time.Sub(r.start).Seconds()
+	e.Elapsed = elapsed
+	if elapsed > 0 {
+		e.Rate = float64(r.work+r.running) / elapsed
+	}
+	if e.Rate > 0 && e.TotalBytes > e.Bytes && kind != Done {
+		e.ETA = float64(e.TotalBytes-e.Bytes) / e.Rate
+	}
+	r.handler(e)
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// _Reader reports the read bytes of a task.
+type _Reader struct {
+	rd io.Reader
+	t  *Task
+}
+
+// Read implements io.Reader.
+func (x *_Reader) Read(p []byte) (int, error) {
+	n, err := x.rd.Read(p)
+	x.t.Add(int64(n))
+	return n, err
+}
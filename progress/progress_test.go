@@ -0,0 +1,82 @@
+package progress_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SchnorcherSepp/splitfs/progress"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestReporter(t *testing.T) {
+	events := make([]progress.Event, 0)
+	rep := progress.New(func(e progress.Event) {
+		events = append(events, e)
+	})
+
+	rep.Start(progress.StageUpload, 2, 100)
+	rep.Found("c.dat", 50)
+
+	// running task
+	task := rep.Task("a.dat", 60)
+	if _, err := io.Copy(ioutil.Discard, task.Reader(bytes.NewReader(make([]byte, 60)))); err != nil {
+		t.Fatal(err)
+	}
+	last := events[len(events)-1]
+	if last.Kind != progress.Bytes || last.Bytes != 60 || last.Items != 0 {
+		t.Errorf("wrong bytes event: %+v", last)
+	}
+	task.Done(progress.Uploaded)
+	rep.Item(progress.Skipped, "b.dat", 40)
+	rep.Item(progress.Failed, "c.dat", 50)
+	rep.Finish()
+
+	// final state
+	last = events[len(events)-1]
+	if last.Kind != progress.Done || last.Stage != progress.StageUpload {
+		t.Errorf("wrong event: %+v", last)
+	}
+	if last.Items != 3 || last.TotalItems != 3 || last.Bytes != 150 || last.TotalBytes != 150 || last.Skipped != 1 || last.Failed != 1 || last.ETA != 0 {
+		t.Errorf("wrong state: %+v", last)
+	}
+
+	// nil reporter
+	var nilRep *progress.Reporter
+	nilRep.Start(progress.StageScan, 1, 1)
+	nilRep.Task("x", 1).Done(progress.Hashed)
+	nilRep.Finish()
+	if progress.New(nil) != nil {
+		t.Errorf("reporter without handler")
+	}
+}
+
+func TestRender(t *testing.T) {
+	// JSON lines
+	buf := new(bytes.Buffer)
+	rep := progress.New(progress.JSON(buf))
+	rep.Start(progress.StageScan, 1, 10)
+	rep.Item(progress.Hashed, "a.dat", 10)
+	rep.Finish()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wrong line count: %d", len(lines))
+	}
+	var e progress.Event
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil || e.Kind != progress.Hashed || e.Path != "a.dat" || e.Bytes != 10 {
+		t.Errorf("wrong event: %+v, %v", e, err)
+	}
+
+	// progress bar
+	buf.Reset()
+	rep = progress.New(progress.Bar(buf))
+	rep.Start(progress.StageUpload, 2, 2048)
+	rep.Item(progress.Uploaded, "a.dat", 1024)
+	rep.Item(progress.Skipped, "b.dat", 1024)
+	rep.Finish()
+	out := buf.String()
+	if !strings.Contains(out, "upload [====================] 100%  2/2  2.0 KB/2.0 KB") || !strings.Contains(out, "skipped 1") || !strings.HasSuffix(out, "\n") {
+		t.Errorf("wrong bar: %q", out)
+	}
+}
@@ -0,0 +1,74 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Bar returns a handler that draws a live progress bar line on w (e.g. os.Stderr).
+// The line is redrawn at most every barInterval and ends with a new line when the stage is done.
+func Bar(w io.Writer) Handler {
+	var last time.Time
+	return func(e Event) {
+		if e.Kind != Start && e.Kind != Done && time.Since(last) < barInterval {
+			return
+		}
+		last = time.Now()
+
+		// bar
+		pct := 0.0
+		if e.TotalBytes > 0 {
+			pct = float64(e.Bytes) / float64(e.TotalBytes)
+		} else if e.TotalItems > 0 {
+			pct = float64(e.Items) / float64(e.TotalItems)
+		}
+		if pct > 1 || e.Kind == Done {
+			pct = 1
+		}
+		fill := int(pct * barWidth)
+		bar := strings.Repeat("=", fill) + strings.Repeat(" ", barWidth-fill)
+
+		// line
+		line := fmt.Sprintf("%-6s [%s] %3.0f%%  %d/%d  %s/%s  %s/s", e.Stage, bar, pct*100, e.Items, e.TotalItems,
+			formatSize(e.Bytes), formatSize(e.TotalBytes), formatSize(int64(e.Rate)))
+		if e.ETA > 0 {
+			line += "  ETA " + time.Duration(e.ETA*float64(time.Second)).Round(time.Second).String()
+		}
+		if e.Skipped > 0 || e.Failed > 0 {
+			line += fmt.Sprintf("  (skipped %d, failed %d)", e.Skipped, e.Failed)
+		}
+		end := ""
+		if e.Kind == Done {
+			end = "\n"
+		}
+		_, _ = fmt.Fprintf(w, "\r%s\x1b[K%s", line, end) // \x1b[K: clear the rest of the line
+	}
+}
+
+// JSON returns a handler that writes each event as one line of JSON to w (e.g. for monitoring tools).
+func JSON(w io.Writer) Handler {
+	enc := json.NewEncoder(w)
+	return func(e Event) {
+		_ = enc.Encode(e)
+	}
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// formatSize returns the bytes with the largest possible unit (1024 based), e.g. '1.5 MB'.
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
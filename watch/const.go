@@ -0,0 +1,4 @@
+package watch
+
+// packageName is used for debug and error messages
+const packageName = "watch"
@@ -0,0 +1,5 @@
+/*
+Package watch provides the watch daemon: continuous scan and upload driven by file system events (inotify).
+
+*/
+package watch
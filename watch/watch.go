@@ -0,0 +1,324 @@
+package watch
+
+import (
+	"errors"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	interf "github.com/SchnorcherSepp/storage/interfaces"
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options configures the watch daemon (@see Run).
+type Options struct {
+	// Debounce is the quiet time after the last event, before the changed paths are scanned.
+	Debounce time.Duration
+	// UploadInterval is the minimal time between two uploads.
+	UploadInterval time.Duration
+	// RescanInterval is the interval of the full rescan for missed events (0=off).
+	RescanInterval time.Duration
+	// Bundle bundles small files into large files (@see db.MakeBundles).
+	Bundle bool
+	// Snapshot keeps a copy of each uploaded index (@see core.UploadSnapshot).
+	Snapshot bool
+	// Scan skips excluded files and folders (@see db.ScanOptions).
+	Scan db.ScanOptions
+	// Upload sets the upload workers, the error policy, the limits and the quota (@see core.UploadOptions).
+	// If the quota is reached, the changes are uploaded later.
+	Upload core.UploadOptions
+}
+
+// _Watcher is the state of the watch daemon.
+type _Watcher struct {
+	rootPath string
+	dbPath   string
+	keyFile  *enc.KeyFile
+	service  interf.Service
+	opts     Options
+	debugLvl uint8
+	debug    bool
+
+	fsw     *fsnotify.Watcher
+	vDb     db.Db           // current db (scanned)
+	dirty   bool            // vDb is not uploaded yet
+	pending map[string]bool // changed paths (relative to the root folder)
+	full    bool            // full rescan required (e.g. event queue overflow)
+}
+
+// Run starts the watch daemon and blocks until stop is closed.
+//
+// The daemon does a full scan, then watches all folders below rootPath (inotify).
+// Bursts of events are collected until there are no new events for opts.Debounce and only the
+// affected paths are scanned again (@see db.FromPaths). Changes are uploaded at most once per opts.UploadInterval
+// and the local db file is saved after each successful upload. A full rescan every opts.RescanInterval finds missed events.
+//
+// The daemon never stops during an upload: if stop is closed, the running upload is finished first.
+// Scanned but not uploaded changes are found again by the full scan on the next start.
+func Run(rootPath, dbPath string, keyFile *enc.KeyFile, service interf.Service, opts Options, stop <-chan struct{}, debugLvl uint8) error {
+	// nil check
+	if service == nil || keyFile == nil {
+		return errors.New("service or key is nil")
+	}
+	if keyFile.IsReader() {
+		return errors.New("watch requires the master key file (reader key)")
+	}
+	if opts.Debounce <= 0 || opts.UploadInterval <= 0 || opts.RescanInterval < 0 {
+		return errors.New("invalid watch intervals")
+	}
+
+	// init
+	rootPath = filepath.Clean(rootPath)
+	if p, err := filepath.Abs(dbPath); err == nil {
+		dbPath = p
+	}
+	w := &_Watcher{
+		rootPath: rootPath,
+		dbPath:   dbPath,
+		keyFile:  keyFile,
+		service:  service,
+		opts:     opts,
+		debugLvl: debugLvl,
+		debug:    debugLvl >= impl.DebugLow,
+		pending:  make(map[string]bool),
+	}
+
+	// watch all folders (before the first scan, so no event is missed)
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("ERROR: %s/Run: %v", packageName, err)
+		return err
+	}
+	defer fsw.Close()
+	w.fsw = fsw
+	if err := w.addTree(rootPath); err != nil {
+		log.Printf("ERROR: %s/Run: watch '%s': %v", packageName, rootPath, err)
+		return err
+	}
+
+	// full scan and upload
+	w.vDb, _ = db.FromFile(dbPath, keyFile.IndexKey()) // empty db if not exist
+	if err := w.fullScan(); err != nil {
+		return err
+	}
+	w.upload()
+
+	// timers
+	debounce := time.NewTimer(opts.Debounce)
+	stopTimer(debounce)
+	uploadTicker := time.NewTicker(opts.UploadInterval)
+	defer uploadTicker.Stop()
+	var rescan <-chan time.Time
+	if opts.RescanInterval > 0 {
+		rescanTicker := time.NewTicker(opts.RescanInterval)
+		defer rescanTicker.Stop()
+		rescan = rescanTicker.C
+	}
+
+	// event loop
+	for {
+		select {
+		case <-stop:
+			if w.dirty || len(w.pending) > 0 || w.full {
+				log.Printf("INFO: %s/Run: stopped with changes that are not uploaded (found again on the next start)", packageName)
+			} else if w.debug {
+				log.Printf("DEBUG: %s/Run: stopped", packageName)
+			}
+			return nil
+
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			w.event(ev)
+			resetTimer(debounce, opts.Debounce)
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return errors.New("watcher closed")
+			}
+			log.Printf("ERROR: %s/Run: watcher: %v (full rescan)", packageName, err)
+			w.full = true
+			resetTimer(debounce, opts.Debounce)
+
+		case <-debounce.C:
+			if w.full {
+				if err := w.fullScan(); err != nil {
+					resetTimer(debounce, opts.UploadInterval) // try again later
+				}
+			} else {
+				w.scanPending()
+			}
+
+		case <-uploadTicker.C:
+			if len(w.pending) == 0 && !w.full {
+				w.upload() // only if nothing is in progress
+			}
+
+		case <-rescan:
+			if err := w.fullScan(); err != nil {
+				resetTimer(debounce, opts.UploadInterval) // try again later
+			}
+		}
+	}
+}
+
+// event adds the path to the pending list and watches new folders.
+func (w *_Watcher) event(ev fsnotify.Event) {
+	// ignore the db file (if inside the root folder)
+	if ev.Name == w.dbPath || strings.HasPrefix(ev.Name, w.dbPath+".") {
+		return
+	}
+
+	relPath, err := filepath.Rel(w.rootPath, ev.Name)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return
+	}
+	w.pending[filepath.ToSlash(relPath)] = true
+	if w.debugLvl >= impl.DebugHigh {
+		log.Printf("DEBUG: %s/event: %s", packageName, ev)
+	}
+
+	// watch new folders (the content is scanned with the folder)
+	if ev.Op&fsnotify.Create != 0 {
+		if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+			if err := w.addTree(ev.Name); err != nil {
+				log.Printf("ERROR: %s/event: watch '%s': %v", packageName, ev.Name, err)
+			}
+		}
+	}
+
+	// moved folders: the watch has the old name
+	if ev.Op&fsnotify.Rename != 0 {
+		_ = w.fsw.Remove(ev.Name)
+	}
+}
+
+// scanPending scans all pending paths.
+func (w *_Watcher) scanPending() {
+	if len(w.pending) == 0 {
+		return
+	}
+	relPaths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		relPaths = append(relPaths, p)
+	}
+
+	newDb, changed, summary, err := db.FromPathsWithOptions(w.rootPath, w.vDb, relPaths, w.opts.Scan, w.debugLvl, w.keyFile)
+	w.pending = make(map[string]bool)
+	if err != nil {
+		// e.g. a file was removed during the scan
+		log.Printf("ERROR: %s/scanPending: %v (full rescan)", packageName, err)
+		w.full = true
+		return
+	}
+	w.setDb(newDb, changed, summary)
+}
+
+// fullScan scans the whole root folder.
+func (w *_Watcher) fullScan() error {
+	w.pending = make(map[string]bool)
+	w.full = false
+
+	newDb, changed, summary, err := db.FromScanWithOptions(w.rootPath, w.vDb, w.opts.Scan, w.debugLvl, w.keyFile)
+	if err != nil {
+		log.Printf("ERROR: %s/fullScan: %v", packageName, err)
+		w.full = true
+		return err
+	}
+	w.setDb(newDb, changed, summary)
+	return nil
+}
+
+// setDb sets the scanned db.
+func (w *_Watcher) setDb(newDb db.Db, changed bool, summary string) {
+	if changed {
+		w.vDb = newDb
+		w.dirty = true
+		if w.debug {
+			log.Printf("DEBUG: %s/scan: %s", packageName, summary)
+		}
+	}
+}
+
+// upload uploads the changed db and saves the local db file.
+// On errors the db stays dirty and the upload is repeated later.
+func (w *_Watcher) upload() {
+	if !w.dirty {
+		return
+	}
+
+	// make bundles (optional)
+	if w.opts.Bundle {
+		w.vDb.MakeBundlesWithProgress(w.keyFile, w.opts.Upload.Progress, w.debugLvl)
+	}
+
+	// upload files & db
+	err := core.UploadWithOptions(w.rootPath, w.vDb, w.keyFile.IndexKey(), w.service, w.opts.Upload, w.debugLvl)
+	if quotaErr, ok := err.(*core.QuotaError); ok {
+		// only the online files are in the index and the local db (changed files: previous version), the db stays dirty
+		if err := db.ToFile(quotaErr.Db, w.keyFile.IndexKey(), w.dbPath); err != nil {
+			log.Printf("ERROR: %s/upload: %v", packageName, err)
+		}
+		log.Printf("INFO: %s/upload: %v (try again later)", packageName, err)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: %s/upload: %v (try again later)", packageName, err)
+		return
+	}
+
+	// keep a copy of the index (optional, a failed snapshot is not repeated)
+	if w.opts.Snapshot {
+		if _, err := core.UploadSnapshot(w.vDb, w.keyFile.IndexKey(), w.service, w.debugLvl); err != nil {
+			log.Printf("ERROR: %s/upload: snapshot: %v", packageName, err)
+		}
+	}
+
+	// save db local
+	if err := db.ToFile(w.vDb, w.keyFile.IndexKey(), w.dbPath); err != nil {
+		log.Printf("ERROR: %s/upload: %v (try again later)", packageName, err)
+		return
+	}
+	w.dirty = false
+	log.Printf("INFO: %s/upload: db uploaded (%d elements)", packageName, len(w.vDb.VFiles))
+}
+
+// addTree watches the folder and all sub folders.
+func (w *_Watcher) addTree(root string) error {
+	return filepath.Walk(root, func(absPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed in the meantime
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return w.fsw.Add(absPath)
+	})
+}
+
+// ----------  HELPER  -----------------------------------------------------------------------------------------------//
+
+// stopTimer stops the timer and drains the channel.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// resetTimer restarts the timer with the duration.
+func resetTimer(t *time.Timer, d time.Duration) {
+	stopTimer(t)
+	t.Reset(d)
+}
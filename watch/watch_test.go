@@ -0,0 +1,181 @@
+package watch_test
+
+import (
+	"crypto/rand"
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	enc "github.com/SchnorcherSepp/splitfs/encoding"
+	"github.com/SchnorcherSepp/splitfs/watch"
+	impl "github.com/SchnorcherSepp/storage/defaultimpl"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls the db file until the check returns true.
+func waitFor(t *testing.T, dbPath string, keyFile *enc.KeyFile, check func(vDb db.Db) bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(dbPath); err == nil {
+			if vDb, err := db.FromFile(dbPath, keyFile.IndexKey()); err == nil && check(vDb) {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchTest")
+	defer os.RemoveAll(dir)
+	rootPath := path.Join(dir, "data")
+	dbPath := path.Join(dir, "index.db2")
+	keyPath := path.Join(dir, "key.dat")
+	_ = os.MkdirAll(path.Join(rootPath, "sub"), 0700)
+	_ = ioutil.WriteFile(path.Join(rootPath, "a.txt"), []byte("a"), 0600)
+
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+	service := impl.NewRamService(nil, impl.DebugOff)
+	opts := watch.Options{Debounce: 50 * time.Millisecond, UploadInterval: 100 * time.Millisecond, RescanInterval: time.Hour}
+
+	// TEST: errors
+	if err := watch.Run(rootPath, dbPath, keyFile, nil, opts, nil, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+	if err := watch.Run(rootPath, dbPath, keyFile, service, watch.Options{}, nil, impl.DebugOff); err == nil {
+		t.Error("no error")
+	}
+
+	// start
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watch.Run(rootPath, dbPath, keyFile, service, opts, stop, impl.DebugHigh)
+	}()
+
+	// TEST: first full scan
+	waitFor(t, dbPath, keyFile, func(vDb db.Db) bool {
+		_, ok := vDb.VFiles["a.txt"]
+		return ok
+	})
+
+	// TEST: events (new file in a new folder, changed file, removed file)
+	_ = os.MkdirAll(path.Join(rootPath, "sub", "new"), 0700)
+	_ = ioutil.WriteFile(path.Join(rootPath, "sub", "new", "b.txt"), []byte("bbb"), 0600)
+	_ = os.Remove(path.Join(rootPath, "a.txt"))
+	waitFor(t, dbPath, keyFile, func(vDb db.Db) bool {
+		_, a := vDb.VFiles["a.txt"]
+		b, ok := vDb.VFiles["sub/new/b.txt"]
+		return !a && ok && b.FileSize == 3 && len(vDb.VFiles["sub/new"].FolderContent) == 1
+	})
+
+	// TEST: the uploaded index is the local db
+	if _, err := core.IndexFile(service); err != nil {
+		t.Error(err)
+	}
+
+	// TEST: stop
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("not stopped")
+	}
+}
+
+func TestRun_quota(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watchQuotaTest")
+	defer os.RemoveAll(dir)
+	rootPath := path.Join(dir, "data")
+	dbPath := path.Join(dir, "index.db2")
+	keyPath := path.Join(dir, "key.dat")
+	_ = os.MkdirAll(rootPath, 0700)
+	a := make([]byte, 3000)
+	_, _ = rand.Read(a)
+	_ = ioutil.WriteFile(path.Join(rootPath, "a.txt"), a, 0600)
+
+	if err := enc.CreateKeyFile(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := enc.LoadKeyFile(keyPath)
+	service := impl.NewRamService(nil, impl.DebugOff)
+
+	// daily cap: a.txt fits, b.txt not
+	quota, err := core.OpenQuota("", 0, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mux sync.Mutex
+	now := time.Now()
+	quota.SetClock(func() time.Time {
+		mux.Lock()
+		defer mux.Unlock()
+		return now
+	})
+	opts := watch.Options{Debounce: 50 * time.Millisecond, UploadInterval: 100 * time.Millisecond, RescanInterval: time.Hour}
+	opts.Upload.Quota = quota
+
+	// start
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watch.Run(rootPath, dbPath, keyFile, service, opts, stop, impl.DebugOff)
+	}()
+	waitFor(t, dbPath, keyFile, func(vDb db.Db) bool {
+		_, ok := vDb.VFiles["a.txt"]
+		return ok
+	})
+
+	// TEST: cap reached -> b.txt is not in the index
+	b := make([]byte, 3000)
+	_, _ = rand.Read(b)
+	_ = ioutil.WriteFile(path.Join(rootPath, "b.txt"), b, 0600)
+	deadline := time.Now().Add(10 * time.Second)
+	for !quota.Reached() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !quota.Reached() {
+		t.Fatal("quota not reached")
+	}
+	if vDb, err := db.FromFile(dbPath, keyFile.IndexKey()); err != nil {
+		t.Error(err)
+	} else if _, ok := vDb.VFiles["b.txt"]; ok {
+		t.Error("b.txt in the index")
+	}
+
+	// TEST: next window -> b.txt is uploaded
+	mux.Lock()
+	now = now.Add(25 * time.Hour)
+	mux.Unlock()
+	waitFor(t, dbPath, keyFile, func(vDb db.Db) bool {
+		_, ok := vDb.VFiles["b.txt"]
+		return ok
+	})
+	_ = service.Update()
+	if vDb, _, err := core.LoadIndex(service, core.IndexName, keyFile.IndexKey()); err != nil {
+		t.Error(err)
+	} else if _, ok := vDb.VFiles["b.txt"]; !ok {
+		t.Error("b.txt not in the uploaded index")
+	}
+
+	// stop
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("not stopped")
+	}
+}
@@ -21,6 +21,7 @@ var _ webdav.File = (*_File)(nil)
 // _File is returned by a FileSystem's OpenFile method and can be served by a Handler.
 type _File struct {
 	innerFile   db.VirtFile
+	vDb         db.Db // db of the file (main index or snapshot)
 	fs          *_FileSystem
 	innerReader interf.ReaderAt
 	innerOff    int64
@@ -28,9 +29,10 @@ type _File struct {
 }
 
 // newFile encapsulate a db.VirtFile and return a webdav.File (random read access)
-func newFile(file db.VirtFile, fs *_FileSystem) webdav.File {
+func newFile(file db.VirtFile, vDb db.Db, fs *_FileSystem) webdav.File {
 	return &_File{
 		innerFile:   file,
+		vDb:         vDb,
 		fs:          fs,
 		innerReader: nil, // set by first Read()
 		innerOff:    0,
@@ -66,11 +68,11 @@ func (f *_File) Read(p []byte) (n int, err error) {
 
 	// innerReader set by first Read()
 	if f.innerReader == nil {
-		rAt, err := core.Open(f.innerFile, f.fs.vDb, f.fs.service, f.fs.debugLvl)
+		rAt, err := core.Open(f.innerFile, f.vDb, f.fs.service, f.fs.debugLvl)
 		if err != nil {
 			return 0, err
 		}
-		f.innerReader = rAt
+		f.innerReader = f.fs.limits.ReaderAt(rAt) // download limit (optional)
 	}
 
 	// return
@@ -11,6 +11,7 @@ import (
 	"context"
 	"github.com/SchnorcherSepp/splitfs/core"
 	"github.com/SchnorcherSepp/splitfs/db"
+	"github.com/SchnorcherSepp/splitfs/limit"
 	impl "github.com/SchnorcherSepp/storage/defaultimpl"
 	interf "github.com/SchnorcherSepp/storage/interfaces"
 	"golang.org/x/net/webdav"
@@ -30,13 +31,19 @@ var _ webdav.FileSystem = (*_FileSystem)(nil)
 // Each method has the same semantics as the os package's function of the same
 // name.
 type _FileSystem struct {
-	service  interf.Service
-	dbKey    []byte
-	debugLvl uint8
+	service   interf.Service
+	indexName string // storageName of the db (@see core.IndexName and core.ShareIndexName)
+	dbKey     []byte
+	debugLvl  uint8
 
 	vDb      db.Db
 	dbFileId string // to detect db changes
 	dbMux    *sync.RWMutex
+
+	snapDbs map[string]db.Db // snapshot cache (key: file id, @see snapshots.go)
+	snapMux *sync.Mutex
+
+	limits *limit.Limits // download limit (optional, each opened file is a connection)
 }
 
 // NewFileSystem creates a new webdav file system.
@@ -48,6 +55,19 @@ type _FileSystem struct {
 // 'updateInterval' in seconds controls how often database is updated in the background.
 // The value 0 deactivates the update loop.
 func NewFileSystem(service interf.Service, dbKey []byte, debugLvl uint8, updateInterval int) webdav.FileSystem {
+	return NewFileSystemWithIndex(service, core.IndexName, dbKey, debugLvl, updateInterval)
+}
+
+// NewFileSystemWithIndex creates a new webdav file system like NewFileSystem,
+// but reads the database from the storage file 'indexName' (e.g. a partial index: @see core.ShareIndexName).
+func NewFileSystemWithIndex(service interf.Service, indexName string, dbKey []byte, debugLvl uint8, updateInterval int) webdav.FileSystem {
+	return NewFileSystemWithLimits(service, indexName, dbKey, debugLvl, updateInterval, nil)
+}
+
+// NewFileSystemWithLimits creates a new webdav file system like NewFileSystemWithIndex,
+// but limits the download speed of the files (nil: unlimited, @see limit.Limits).
+// The limits can be changed at runtime (@see limit.Limits.Set).
+func NewFileSystemWithLimits(service interf.Service, indexName string, dbKey []byte, debugLvl uint8, updateInterval int, limits *limit.Limits) webdav.FileSystem {
 	// check nil service
 	if service == nil {
 		service = impl.NewRamService(nil, impl.DebugOff) // dummy service
@@ -55,13 +75,19 @@ func NewFileSystem(service interf.Service, dbKey []byte, debugLvl uint8, updateI
 
 	// build FileSystem
 	fs := &_FileSystem{
-		service:  service,
-		dbKey:    dbKey,
-		debugLvl: debugLvl,
+		service:   service,
+		indexName: indexName,
+		dbKey:     dbKey,
+		debugLvl:  debugLvl,
 
 		vDb:      db.NewDb(),
 		dbFileId: "",
 		dbMux:    new(sync.RWMutex),
+
+		snapDbs: make(map[string]db.Db),
+		snapMux: new(sync.Mutex),
+
+		limits: limits,
 	}
 
 	// start update loop
@@ -90,7 +116,7 @@ func (fs *_FileSystem) OpenFile(_ context.Context, relPath string, _ int, _ os.F
 	relPath = pathFix(relPath)
 
 	// get VirtFile
-	f, ok := fs.vDb.VFiles[relPath]
+	f, vDb, ok := fs.lookup(relPath)
 	if !ok {
 		return nil, os.ErrNotExist
 	}
@@ -101,7 +127,7 @@ func (fs *_FileSystem) OpenFile(_ context.Context, relPath string, _ int, _ os.F
 	*/
 
 	// return webdav file
-	return newFile(f, fs), nil
+	return newFile(f, vDb, fs), nil
 }
 
 // Stat @see os.Stat
@@ -116,7 +142,7 @@ func (fs *_FileSystem) Stat(_ context.Context, relPath string) (os.FileInfo, err
 	relPath = pathFix(relPath)
 
 	// get VirtFile
-	f, ok := fs.vDb.VFiles[relPath]
+	f, _, ok := fs.lookup(relPath)
 	if !ok {
 		return nil, os.ErrNotExist
 	}
@@ -233,36 +259,31 @@ func (fs *_FileSystem) checkDb(silence bool) bool {
 	fs.dbMux.Lock()         // W LOCK
 	defer fs.dbMux.Unlock() // W UNLOCK
 
-	// get db file
-	f, err := fs.service.Files().ByName(core.IndexName)
-	if err != nil {
+	// get db file (newest)
+	list := core.IndexFiles(fs.service, fs.indexName)
+	if len(list) == 0 {
 		if !silence {
-			log.Printf("WARNING: %s/checkDb: %v", packageName, err)
+			log.Printf("WARNING: %s/checkDb: %v", packageName, os.ErrNotExist)
 		}
 		return false // ERROR
 	}
 
 	// changes?
 	// new file id -> db change!
-	if fs.dbFileId == f.Id() && fs.dbFileId != "" {
+	if fs.dbFileId == list[0].Id() && fs.dbFileId != "" {
 		return false // do nothing
 	}
 
 	log.Printf("INFO: %s/Update: download db", packageName)
 
-	// open reader to db file
-	r, err := fs.service.Reader(f, 0)
+	// download and read db (newest valid file)
+	newDb, f, err := core.LoadIndex(fs.service, fs.indexName, fs.dbKey)
 	if err != nil {
 		log.Printf("WARNING: %s/checkDb: %v", packageName, err)
 		return false // ERROR
 	}
-	defer r.Close() // CLOSE
-
-	// read db
-	newDb, err := db.FromReader(r, fs.dbKey)
-	if err != nil {
-		log.Printf("WARNING: %s/checkDb: %v", packageName, err)
-		return false // ERROR
+	if f.Id() == fs.dbFileId {
+		return false // newer files are invalid: keep the current db
 	}
 
 	// success:
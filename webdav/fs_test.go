@@ -120,6 +120,113 @@ func TestFileSystem_startUpdateLoop_checkDb(t *testing.T) {
 	endLogTests(stdoutBuf, "download db", "", t, "Test I")
 }
 
+func TestNewFileSystemWithIndex(t *testing.T) {
+	service := impl.NewRamService(impl.NewCache(17), impl.DebugOff)
+	shareKey := make([]byte, 32)
+
+	// share db with one folder
+	shareDb := db.NewDb()
+	shareDb.VFiles["."] = db.VirtFile{RelPath: ".", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "shared", IsDir: true}}}
+	shareDb.VFiles["shared"] = db.VirtFile{RelPath: "shared", IsDir: true}
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := db.ToWriter(shareDb, shareKey, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Save("index-partner.db2", buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	writeDb(service, make([]byte, 16), t) // main index (other key)
+	_ = service.Update()
+
+	// load share
+	fs := NewFileSystemWithIndex(service, "index-partner.db2", shareKey, impl.DebugOff, 0).(*_FileSystem)
+	if !fs.checkDb(false) {
+		t.Fatal("checkDb fail")
+	}
+	if _, err := fs.Stat(nil, "/shared"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileSystem_snapshots(t *testing.T) {
+	service := impl.NewRamService(impl.NewCache(17), impl.DebugOff)
+	dbKey := make([]byte, 16)
+
+	// main index without snapshots
+	mainDb := db.NewDb()
+	mainDb.VFiles["."] = db.VirtFile{RelPath: ".", IsDir: true}
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := db.ToWriter(mainDb, dbKey, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Save(core.IndexName, buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+	fs := NewFileSystem(service, dbKey, impl.DebugOff, 0).(*_FileSystem)
+	if !fs.checkDb(false) {
+		t.Fatal("checkDb fail")
+	}
+	if _, err := fs.Stat(nil, "/.snapshots"); err != os.ErrNotExist {
+		t.Errorf("snapshot folder without snapshots: %v", err)
+	}
+
+	// snapshot with an old folder
+	oldDb := db.NewDb()
+	oldDb.VFiles["."] = db.VirtFile{RelPath: ".", IsDir: true, FolderContent: []db.FolderEl{{RelPath: "old", IsDir: true}}}
+	oldDb.VFiles["old"] = db.VirtFile{RelPath: "old", IsDir: true}
+	name, err := core.UploadSnapshot(oldDb, dbKey, service, impl.DebugOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = service.Update()
+
+	// TEST: root lists the snapshot folder
+	f, err := fs.OpenFile(nil, "/", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := f.Readdir(0)
+	if len(infos) != 1 || infos[0].Name() != ".snapshots" || !infos[0].IsDir() {
+		t.Errorf("wrong root: %v", infos)
+	}
+
+	// TEST: snapshot folder
+	f, err = fs.OpenFile(nil, "/.snapshots", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ = f.Readdir(0)
+	if len(infos) != 1 || infos[0].Name() != name {
+		t.Errorf("wrong snapshot list: %v", infos)
+	}
+	if info, err := fs.Stat(nil, "/.snapshots/"+name+"/"); err != nil || info.Name() != name || !info.IsDir() {
+		t.Errorf("wrong snapshot: %v, %v", info, err)
+	}
+
+	// TEST: content of the snapshot (not in the main index)
+	if info, err := fs.Stat(nil, "/.snapshots/"+name+"/old"); err != nil || info.Name() != "old" {
+		t.Errorf("wrong snapshot content: %v, %v", info, err)
+	}
+	if _, err := fs.Stat(nil, "/old"); err != os.ErrNotExist {
+		t.Errorf("snapshot content in the main index: %v", err)
+	}
+	if _, err := fs.Stat(nil, "/.snapshots/20000101T000000Z"); err != os.ErrNotExist {
+		t.Errorf("unknown snapshot: %v", err)
+	}
+
+	// TEST: read only
+	if err := fs.RemoveAll(nil, "/.snapshots/"+name); err != webdav.ErrForbidden {
+		t.Error(err)
+	}
+
+	// TEST: shares have no snapshots
+	share := NewFileSystemWithIndex(service, core.IndexName+".share", dbKey, impl.DebugOff, 0)
+	if _, err := share.Stat(nil, "/.snapshots"); err != os.ErrNotExist {
+		t.Errorf("snapshot folder in share: %v", err)
+	}
+}
+
 //====================================================================================================================//
 
 func startLogTests(buf *bytes.Buffer) {
@@ -0,0 +1,110 @@
+package webdav
+
+/*
+	IN THIS FILE: index history (read only)
+		- virtual folder '/.snapshots/<timestamp>/'
+		- snapshot db cache (lazy download)
+*/
+
+import (
+	"github.com/SchnorcherSepp/splitfs/core"
+	"github.com/SchnorcherSepp/splitfs/db"
+	"log"
+	"strings"
+)
+
+// snapshotFolder is the virtual folder with all snapshots (@see core.UploadSnapshot).
+const snapshotFolder = ".snapshots"
+
+// lookup returns the element and the db of the element.
+// Paths below '.snapshots/<timestamp>/' are read from the snapshot (downloaded by the first access).
+// The caller must hold the dbMux read lock.
+func (fs *_FileSystem) lookup(relPath string) (db.VirtFile, db.Db, bool) {
+	// snapshots only for the main index (not for shares)
+	if fs.indexName != core.IndexName || (relPath != "." && relPath != snapshotFolder && !strings.HasPrefix(relPath, snapshotFolder+"/")) {
+		f, ok := fs.vDb.VFiles[relPath]
+		return f, fs.vDb, ok
+	}
+
+	list := core.Snapshots(fs.service)
+
+	// root: add the snapshot folder
+	if relPath == "." {
+		f, ok := fs.vDb.VFiles[relPath]
+		if ok && len(list) > 0 {
+			f.FolderContent = append(append([]db.FolderEl{}, f.FolderContent...), db.FolderEl{RelPath: snapshotFolder, IsDir: true})
+		}
+		return f, fs.vDb, ok
+	}
+
+	// snapshot folder: list all snapshots
+	if relPath == snapshotFolder {
+		if len(list) == 0 {
+			return db.VirtFile{}, fs.vDb, false
+		}
+		f := db.VirtFile{RelPath: snapshotFolder, IsDir: true, MTime: list[len(list)-1].Time.Unix()}
+		for _, s := range list {
+			f.FolderContent = append(f.FolderContent, db.FolderEl{RelPath: snapshotFolder + "/" + s.Name(), IsDir: true})
+		}
+		return f, fs.vDb, true
+	}
+
+	// element in a snapshot
+	rest := strings.TrimPrefix(relPath, snapshotFolder+"/")
+	name, subPath := rest, "."
+	if i := strings.Index(rest, "/"); i >= 0 {
+		name, subPath = rest[:i], rest[i+1:]
+	}
+	sDb, ok := fs.snapshotDb(list, name)
+	if !ok {
+		return db.VirtFile{}, fs.vDb, false
+	}
+	f, ok := sDb.VFiles[subPath]
+	if ok && subPath == "." {
+		f.RelPath = relPath // name of the snapshot folder
+	}
+	return f, sDb, ok
+}
+
+// snapshotDb returns the db of the snapshot (cached).
+func (fs *_FileSystem) snapshotDb(list []core.Snapshot, name string) (db.Db, bool) {
+	fs.snapMux.Lock()         // LOCK
+	defer fs.snapMux.Unlock() // UNLOCK
+
+	for _, s := range list {
+		if s.Name() != name {
+			continue
+		}
+
+		// cache
+		if sDb, ok := fs.snapDbs[s.File.Id()]; ok {
+			return sDb, true
+		}
+
+		// download
+		log.Printf("INFO: %s/snapshotDb: download snapshot '%s'", packageName, name)
+		sDb, err := core.ReadDb(s.File, fs.service, fs.dbKey)
+		if err != nil {
+			log.Printf("WARNING: %s/snapshotDb: %v", packageName, err)
+			return sDb, false
+		}
+		if root, ok := sDb.VFiles["."]; ok {
+			root.MTime = s.Time.Unix() // the snapshot folder has the snapshot time
+			sDb.VFiles["."] = root
+		}
+
+		// remove pruned snapshots from the cache
+		ids := make(map[string]bool)
+		for _, s := range list {
+			ids[s.File.Id()] = true
+		}
+		for id := range fs.snapDbs {
+			if !ids[id] {
+				delete(fs.snapDbs, id)
+			}
+		}
+		fs.snapDbs[s.File.Id()] = sDb
+		return sDb, true
+	}
+	return db.Db{}, false
+}